@@ -4,25 +4,26 @@ go 1.19
 
 require (
 	github.com/hako/branca v0.0.0-20200807062402-6052ac720505
-	github.com/jackc/pgx v3.6.2+incompatible
+	github.com/jackc/pgconn v1.13.0
 	github.com/jackc/pgx/v4 v4.17.2
 	github.com/matryer/way v0.0.0-20180416093233-9632d0c407b0
 	golang.org/x/crypto v0.5.0
+	golang.org/x/image v0.5.0
+	golang.org/x/net v0.7.0
 )
 
 require (
 	github.com/eknkc/basex v1.0.1 // indirect
 	github.com/jackc/chunkreader/v2 v2.0.1 // indirect
-	github.com/jackc/fake v0.0.0-20150926172116-812a484cc733 // indirect
-	github.com/jackc/pgconn v1.13.0 // indirect
 	github.com/jackc/pgio v1.0.0 // indirect
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgproto3/v2 v2.3.1 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
 	github.com/jackc/pgtype v1.13.0 // indirect
+	github.com/jackc/puddle v1.3.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	// golang.org/x/mod v0.7.0 // indirect
-	golang.org/x/sys v0.4.0 // indirect
-	golang.org/x/text v0.6.0 // indirect
+	golang.org/x/sys v0.5.0 // indirect
+	golang.org/x/text v0.7.0 // indirect
 // golang.org/x/tools v0.3.0 // indirect
 )
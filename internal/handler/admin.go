@@ -0,0 +1,237 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/dhruvsingh510/bond_social_api/internal/service"
+	"github.com/matryer/way"
+)
+
+type setUserRoleInput struct {
+	Role string
+}
+
+func (h *handler) modLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	after, first := pageParams(r)
+
+	from, ok := parseSearchTime(q.Get("from"))
+	if !ok {
+		http.Error(w, "invalid from date", http.StatusUnprocessableEntity)
+		return
+	}
+
+	to, ok := parseSearchTime(q.Get("to"))
+	if !ok {
+		http.Error(w, "invalid to date", http.StatusUnprocessableEntity)
+		return
+	}
+
+	var targetID int64
+	if s := q.Get("target_id"); s != "" {
+		var err error
+		if targetID, err = strconv.ParseInt(s, 10, 64); err != nil {
+			http.Error(w, service.ErrInvalidModLogFilter.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	ee, err := h.ModLog(r.Context(), service.ModLogFilter{
+		Actor:      q.Get("actor"),
+		Action:     q.Get("action"),
+		TargetType: q.Get("target_type"),
+		TargetID:   targetID,
+		Community:  q.Get("community"),
+		From:       from,
+		To:         to,
+	}, after, first)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrForbidden {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err == service.ErrInvalidModLogFilter || err == service.ErrInvalidCursor {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err == service.ErrCommunityNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, ee, http.StatusOK)
+}
+
+func (h *handler) setUserRole(w http.ResponseWriter, r *http.Request) {
+	var in setUserRoleInput
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	err := h.SetUserRole(ctx, way.Param(ctx, "username"), in.Role)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrForbidden {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err == service.ErrInvalidRole {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err == service.ErrUserNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type createContentFilterInput struct {
+	Kind    string
+	Pattern string
+	Action  string
+}
+
+func (h *handler) contentFilters(w http.ResponseWriter, r *http.Request) {
+	ff, err := h.ContentFilters(r.Context())
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrForbidden {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, ff, http.StatusOK)
+}
+
+func (h *handler) createContentFilter(w http.ResponseWriter, r *http.Request) {
+	var in createContentFilterInput
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f, err := h.CreateContentFilter(r.Context(), in.Kind, in.Pattern, in.Action)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrForbidden {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err == service.ErrInvalidFilter {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, f, http.StatusCreated)
+}
+
+func (h *handler) deleteContentFilter(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	filterID, err := strconv.ParseInt(way.Param(ctx, "filter_id"), 10, 64)
+	if err != nil {
+		http.Error(w, service.ErrFilterNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	err = h.DeleteContentFilter(ctx, filterID)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrForbidden {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err == service.ErrFilterNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) contentFilterHits(w http.ResponseWriter, r *http.Request) {
+	after, first := pageParams(r)
+
+	hh, err := h.ContentFilterHits(r.Context(), after, first)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrForbidden {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err == service.ErrInvalidCursor {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, hh, http.StatusOK)
+}
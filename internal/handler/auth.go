@@ -2,6 +2,8 @@ package handler
 
 import (
 	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
 	"strings"
 	"github.com/dhruvsingh510/bond_social_api/internal/service"
@@ -69,14 +71,134 @@ func (h *handler) withAuth(next http.Handler) http.Handler {
 		}
 
 		token := a[7:] 
-		uid, err := h.AuthUserID(token)
-		if err != nil {
+		ctx := r.Context()
+		uid, err := h.AuthUserID(ctx, token)
+		if err == service.ErrInvalidToken {
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
 
-		ctx := r.Context()
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
 		ctx = context.WithValue(ctx, service.KeyAuthUserID, uid)
+
+		// suspended users are read-only, except for appealing
+		if isWriteRequest(r) && r.URL.Path != appealPath {
+			sp, err := h.ActiveSuspension(ctx)
+			if err != nil {
+				respondError(w, err)
+				return
+			}
+
+			if sp != nil {
+				http.Error(w, service.ErrUserSuspended.Error(), http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// appealPath is the only write endpoint suspended users can reach
+const appealPath = "/auth_user/suspension/appeal"
+
+func isWriteRequest(r *http.Request) bool {
+	return r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions
+}
+
+// withClient puts in context where the request comes from, as told by clientIP,
+// and the device id the app sends in X-Device-ID.
+func (h *handler) withClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), service.KeyClient, service.Client{
+			IP:     h.clientIP(r),
+			Device: r.Header.Get("X-Device-ID"),
+		})
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
-}
\ No newline at end of file
+}
+
+// clientIP is the remote address of the request, unless it is a trusted proxy.
+// X-Forwarded-For is then walked from the right, each trusted proxy vouching
+// for the hop before it, up to the first address that is not a trusted proxy.
+// Anything left of it may be forged by the client.
+func (h *handler) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if !h.trustedProxy(ip) {
+		return ip
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+
+		ip = hop
+		if !h.trustedProxy(hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+func (h *handler) trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, n := range h.trustedProxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseTrustedProxies parses a comma separated list of IP addresses
+// and CIDR ranges of the proxies allowed to set X-Forwarded-For.
+func ParseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", p)
+			}
+
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", p, err)
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/dhruvsingh510/bond_social_api/internal/service"
+	"github.com/matryer/way"
+)
+
+func (h *handler) comments(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	postID, err := strconv.ParseInt(way.Param(ctx, "post_id"), 10, 64)
+	if err != nil {
+		http.Error(w, service.ErrInvalidPostID.Error(), http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	after, first := pageParams(r)
+	depth, _ := strconv.Atoi(q.Get("depth"))
+
+	cc, err := h.Comments(ctx, postID, q.Get("sort"), after, first, depth)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrInvalidSort || err == service.ErrInvalidCursor {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err == service.ErrInvalidPostID {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, cc, http.StatusOK)
+}
+
+type updateCommentInput struct {
+	Content string `json:"content"`
+}
+
+func (h *handler) updateComment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	commentID, err := strconv.ParseInt(way.Param(ctx, "comment_id"), 10, 64)
+	if err != nil {
+		http.Error(w, service.ErrCommentNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	var in updateCommentInput
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c, err := h.UpdateComment(ctx, commentID, in.Content)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrInvalidComment || err == service.ErrContentRejected {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err == service.ErrCommentNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err == service.ErrForbidden {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, c, http.StatusOK)
+}
+
+func (h *handler) deleteComment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	commentID, err := strconv.ParseInt(way.Param(ctx, "comment_id"), 10, 64)
+	if err != nil {
+		http.Error(w, service.ErrCommentNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	err = h.DeleteComment(ctx, commentID)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrCommentNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err == service.ErrForbidden {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) commentVote(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	commentID, err := strconv.ParseInt(way.Param(ctx, "comment_id"), 10, 64)
+	if err != nil {
+		http.Error(w, service.ErrCommentNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	var in voteInput
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if in.Value == nil {
+		http.Error(w, service.ErrInvalidVote.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	out, err := h.CommentVote(ctx, commentID, *in.Value)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrInvalidVote {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err == service.ErrCommentNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err == service.ErrNotCommunityMember {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, out, http.StatusOK)
+}
@@ -0,0 +1,391 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/dhruvsingh510/bond_social_api/internal/service"
+	"github.com/matryer/way"
+)
+
+type createCommunityInput struct {
+	Name        string
+	Description string
+	Rules       []string
+	Visibility  string
+}
+
+type updateCommunityInput struct {
+	Description *string
+	Rules       *[]string
+	Visibility  *string
+}
+
+type joinCommunityOutput struct {
+	Status string `json:"status"`
+}
+
+type createCommunityInviteInput struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+	MaxUses   *int       `json:"max_uses"`
+}
+
+func (h *handler) createCommunity(w http.ResponseWriter, r *http.Request) {
+	var in createCommunityInput
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c, err := h.CreateCommunity(r.Context(), in.Name, in.Description, in.Rules, in.Visibility)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrInvalidCommunityName ||
+		err == service.ErrInvalidCommunityDescription ||
+		err == service.ErrInvalidCommunityRules ||
+		err == service.ErrInvalidCommunityVisibility {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err == service.ErrCommunityNameTaken {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, c, http.StatusCreated)
+}
+
+func (h *handler) communities(w http.ResponseWriter, r *http.Request) {
+	after, first := pageParams(r)
+
+	cc, err := h.Communities(r.Context(), after, first)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrInvalidCursor {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, cc, http.StatusOK)
+}
+
+func (h *handler) community(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	c, err := h.Community(ctx, way.Param(ctx, "name"))
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrInvalidCommunityName {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err == service.ErrCommunityNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, c, http.StatusOK)
+}
+
+func (h *handler) communityPosts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+	after, first := pageParams(r)
+
+	pp, err := h.Feed(ctx, way.Param(ctx, "name"), q.Get("sort"), q.Get("t"), after, first)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrInvalidSort ||
+		err == service.ErrInvalidPeriod ||
+		err == service.ErrInvalidCursor ||
+		err == service.ErrInvalidCommunityName {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err == service.ErrCommunityNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, pp, http.StatusOK)
+}
+
+func (h *handler) updateCommunity(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var in updateCommunityInput
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c, err := h.UpdateCommunity(ctx, way.Param(ctx, "name"), service.CommunityUpdate{
+		Description: in.Description,
+		Rules:       in.Rules,
+		Visibility:  in.Visibility,
+	})
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrInvalidCommunityName ||
+		err == service.ErrInvalidCommunityDescription ||
+		err == service.ErrInvalidCommunityRules ||
+		err == service.ErrInvalidCommunityVisibility {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err == service.ErrCommunityNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err == service.ErrForbidden {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, c, http.StatusOK)
+}
+
+func (h *handler) joinCommunity(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	status, err := h.JoinCommunity(ctx, way.Param(ctx, "name"))
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrInvalidCommunityName {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err == service.ErrCommunityNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, joinCommunityOutput{Status: status}, http.StatusOK)
+}
+
+func (h *handler) communityJoinRequests(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	after, first := pageParams(r)
+
+	rr, err := h.CommunityJoinRequests(ctx, way.Param(ctx, "name"), after, first)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrInvalidCommunityName || err == service.ErrInvalidCursor {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err == service.ErrCommunityNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err == service.ErrForbidden {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, rr, http.StatusOK)
+}
+
+func (h *handler) approveCommunityJoinRequest(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	err := h.ResolveCommunityJoinRequest(ctx, way.Param(ctx, "name"), way.Param(ctx, "username"), true)
+	respondMembershipChange(w, err)
+}
+
+func (h *handler) rejectCommunityJoinRequest(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	err := h.ResolveCommunityJoinRequest(ctx, way.Param(ctx, "name"), way.Param(ctx, "username"), false)
+	respondMembershipChange(w, err)
+}
+
+func (h *handler) createCommunityInvite(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var in createCommunityInviteInput
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	inv, err := h.CreateCommunityInvite(ctx, way.Param(ctx, "name"), in.ExpiresAt, in.MaxUses)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrInvalidCommunityName || err == service.ErrInvalidInvite {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err == service.ErrCommunityNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err == service.ErrForbidden {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, inv, http.StatusCreated)
+}
+
+func (h *handler) acceptCommunityInvite(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	c, err := h.AcceptCommunityInvite(ctx, way.Param(ctx, "code"))
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrInviteNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err == service.ErrInviteExpired {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, c, http.StatusOK)
+}
+
+func (h *handler) leaveCommunity(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	err := h.LeaveCommunity(ctx, way.Param(ctx, "name"))
+	respondMembershipChange(w, err)
+}
+
+func (h *handler) addCommunityModerator(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	err := h.SetCommunityModerator(ctx, way.Param(ctx, "name"), way.Param(ctx, "username"), true)
+	respondMembershipChange(w, err)
+}
+
+func (h *handler) removeCommunityModerator(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	err := h.SetCommunityModerator(ctx, way.Param(ctx, "name"), way.Param(ctx, "username"), false)
+	respondMembershipChange(w, err)
+}
+
+// respondMembershipChange maps the errors of the community membership actions
+func respondMembershipChange(w http.ResponseWriter, err error) {
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrInvalidCommunityName || err == service.ErrInvalidUsername {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err == service.ErrCommunityNotFound || err == service.ErrUserNotFound || err == service.ErrJoinRequestNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err == service.ErrForbidden {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err == service.ErrNotCommunityMember || err == service.ErrLastCommunityModerator {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"net"
 	"net/http"
 	"context"
 	"github.com/dhruvsingh510/bond_social_api/internal/service"
@@ -9,31 +10,99 @@ import (
 
 type handler struct {
 	*service.Service
+	// proxies whose X-Forwarded-For header is honored
+	trustedProxies []*net.IPNet
 }
 
 type Service interface {
 	AuthUser(ctx context.Context) (service.User, error)
 }
 
-// New creates a http.Handler with predefined routing
-func New(s *service.Service) http.Handler {
-	h := &handler{s}
+// New creates a http.Handler with predefined routing.
+// X-Forwarded-For is only read from requests coming from trustedProxies.
+func New(s *service.Service, trustedProxies []*net.IPNet) http.Handler {
+	h := &handler{s, trustedProxies}
 
 	api := way.NewRouter()
 	api.HandleFunc("GET", "auth_user", h.authUser)
 	api.HandleFunc("POST", "/login", h.login)
+	api.HandleFunc("GET", "/auth_user/suspension", h.authUserSuspension)
+	api.HandleFunc("POST", "/auth_user/suspension/appeal", h.appealSuspension)
+	api.HandleFunc("GET", "/auth_user/saved", h.saved)
+	api.HandleFunc("GET", "/auth_user/saved/collections", h.savedCollections)
 
 	api.HandleFunc("POST", "/users", h.createUser)
 	api.HandleFunc("GET", "/users/:username", h.user)
 	api.HandleFunc("GET", "/users/:username/posts", h.posts)
+	api.HandleFunc("POST", "/users/:username/toggle_follow", h.toggleFollow)
+
+	api.HandleFunc("POST", "/communities", h.createCommunity)
+	api.HandleFunc("GET", "/communities", h.communities)
+	api.HandleFunc("GET", "/communities/:name", h.community)
+	api.HandleFunc("PATCH", "/communities/:name", h.updateCommunity)
+	api.HandleFunc("GET", "/communities/:name/posts", h.communityPosts)
+	api.HandleFunc("POST", "/communities/:name/join", h.joinCommunity)
+	api.HandleFunc("POST", "/communities/:name/leave", h.leaveCommunity)
+	api.HandleFunc("GET", "/communities/:name/join_requests", h.communityJoinRequests)
+	api.HandleFunc("POST", "/communities/:name/join_requests/:username/approve", h.approveCommunityJoinRequest)
+	api.HandleFunc("POST", "/communities/:name/join_requests/:username/reject", h.rejectCommunityJoinRequest)
+	api.HandleFunc("POST", "/communities/:name/invites", h.createCommunityInvite)
+	api.HandleFunc("POST", "/invites/:code/accept", h.acceptCommunityInvite)
+	api.HandleFunc("PUT", "/communities/:name/moderators/:username", h.addCommunityModerator)
+	api.HandleFunc("DELETE", "/communities/:name/moderators/:username", h.removeCommunityModerator)
 
 	api.HandleFunc("POST", "/posts", h.createPost)
+	api.HandleFunc("GET", "/posts", h.feed)
 	api.HandleFunc("GET", "/posts/:post_id", h.post)
-	api.HandleFunc("POST", "/posts/action", h.postVote)
+	api.HandleFunc("PUT", "/posts/:post_id/vote", h.postVote)
+	api.HandleFunc("POST", "/posts/:post_id/save", h.savePost)
+	api.HandleFunc("DELETE", "/posts/:post_id/save", h.unsavePost)
+	api.HandleFunc("POST", "/posts/:post_id/repost", h.repost)
+	api.HandleFunc("DELETE", "/posts/:post_id/repost", h.undoRepost)
+	api.HandleFunc("POST", "/posts/:post_id/poll/vote", h.pollVote)
 	api.HandleFunc("POST", "/posts/comment", h.postComment)
+	api.HandleFunc("GET", "/posts/:post_id/comments", h.comments)
+	api.HandleFunc("PATCH", "/comments/:comment_id", h.updateComment)
+	api.HandleFunc("DELETE", "/comments/:comment_id", h.deleteComment)
+	api.HandleFunc("PUT", "/comments/:comment_id/vote", h.commentVote)
+	api.HandleFunc("POST", "/comments/:comment_id/save", h.saveComment)
+	api.HandleFunc("DELETE", "/comments/:comment_id/save", h.unsaveComment)
+
+	api.HandleFunc("GET", "/tags/:tag/posts", h.tagPosts)
+	api.HandleFunc("GET", "/search", h.search)
+
+	api.HandleFunc("GET", "/timeline", h.timeline)
+
+	api.HandleFunc("POST", "/reports", h.createReport)
+	api.HandleFunc("GET", "/moderation/queue", h.moderationQueue)
+	api.HandleFunc("POST", "/moderation/queue/:case_id/claim", h.claimModerationCase)
+	api.HandleFunc("POST", "/moderation/queue/:case_id/resolve", h.resolveModerationCase)
+	api.HandleFunc("PUT", "/moderation/suspensions/:username", h.suspendUser)
+	api.HandleFunc("DELETE", "/moderation/suspensions/:username", h.liftSuspension)
+	api.HandleFunc("PUT", "/moderation/shadowbans/:username", h.shadowbanUser)
+	api.HandleFunc("DELETE", "/moderation/shadowbans/:username", h.unshadowbanUser)
+	api.HandleFunc("GET", "/moderation/appeals", h.appeals)
+	api.HandleFunc("GET", "/moderation/filter_hits", h.contentFilterHits)
+	api.HandleFunc("GET", "/moderation/flagged_accounts", h.flaggedAccounts)
+	api.HandleFunc("POST", "/moderation/flagged_accounts/:username/clear", h.clearFlaggedAccount)
+	api.HandleFunc("POST", "/moderation/appeals/:appeal_id/approve", h.approveAppeal)
+	api.HandleFunc("POST", "/moderation/appeals/:appeal_id/reject", h.rejectAppeal)
+
+	api.HandleFunc("GET", "/admin/modlog", h.modLog)
+	api.HandleFunc("PUT", "/admin/users/:username/role", h.setUserRole)
+	api.HandleFunc("GET", "/admin/filters", h.contentFilters)
+	api.HandleFunc("POST", "/admin/filters", h.createContentFilter)
+	api.HandleFunc("DELETE", "/admin/filters/:filter_id", h.deleteContentFilter)
+
+	api.HandleFunc("GET", "/notifications", h.notifications)
+	api.HandleFunc("POST", "/notifications/mark_as_read", h.markNotificationsAsRead)
+	api.HandleFunc("POST", "/notifications/:notification_id/mark_as_read", h.markNotificationAsRead)
+
+	api.HandleFunc("POST", "/media", h.uploadMedia)
 
 	r := way.NewRouter()
-	r.Handle("*", "/api...", http.StripPrefix("/api", h.withAuth(api)))
+	r.Handle("*", "/api...", http.StripPrefix("/api", h.withClient(h.withAuth(api))))
+	r.Handle("GET", "/media/", http.StripPrefix("/media/", http.HandlerFunc(h.mediaContent)))
 
 	return r
 }
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/dhruvsingh510/bond_social_api/internal/service"
+)
+
+func (h *handler) uploadMedia(w http.ResponseWriter, r *http.Request) {
+	// leave room for the multipart envelope around the file
+	r.Body = http.MaxBytesReader(w, r.Body, service.MaxMediaSize+1<<20)
+	f, _, err := r.FormFile("file")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, service.ErrMediaTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, service.MaxMediaSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m, err := h.UploadMedia(r.Context(), data)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrInvalidMedia {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err == service.ErrMediaTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err == service.ErrUnsupportedMediaType {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, m, http.StatusCreated)
+}
+
+func (h *handler) mediaContent(w http.ResponseWriter, r *http.Request) {
+	rc, contentType, err := h.MediaContent(r.Context(), r.URL.Path)
+
+	if err == service.ErrMediaNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	defer rc.Close()
+
+	// blob keys are content hashes, so they never change
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	io.Copy(w, rc)
+}
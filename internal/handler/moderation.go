@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/dhruvsingh510/bond_social_api/internal/service"
+	"github.com/matryer/way"
+)
+
+type createReportInput struct {
+	TargetType string `json:"target_type"`
+	TargetID   int64  `json:"target_id"`
+	Reason     string
+	Details    string
+}
+
+type resolveModerationCaseInput struct {
+	Action      string
+	Note        string
+	SuspendDays int `json:"suspend_days"`
+}
+
+func (h *handler) createReport(w http.ResponseWriter, r *http.Request) {
+	var in createReportInput
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := h.CreateReport(r.Context(), service.ReportInput{
+		TargetType: in.TargetType,
+		TargetID:   in.TargetID,
+		Reason:     in.Reason,
+		Details:    in.Details,
+	})
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrInvalidReport ||
+		err == service.ErrInvalidReportReason ||
+		err == service.ErrInvalidReportTarget {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err == service.ErrReportTargetNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) moderationQueue(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	after, first := pageParams(r)
+
+	cc, err := h.ModerationQueue(r.Context(), service.ModerationQueueFilter{
+		Status:     q.Get("status"),
+		TargetType: q.Get("target_type"),
+		Reason:     q.Get("reason"),
+		Community:  q.Get("community"),
+	}, after, first)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrForbidden {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err == service.ErrInvalidModerationFilter || err == service.ErrInvalidCursor {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err == service.ErrCommunityNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, cc, http.StatusOK)
+}
+
+func (h *handler) claimModerationCase(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	caseID, err := strconv.ParseInt(way.Param(ctx, "case_id"), 10, 64)
+	if err != nil {
+		http.Error(w, service.ErrModerationCaseNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	c, err := h.ClaimModerationCase(ctx, caseID)
+	if err != nil {
+		respondModerationCaseError(w, err)
+		return
+	}
+
+	respond(w, c, http.StatusOK)
+}
+
+func (h *handler) resolveModerationCase(w http.ResponseWriter, r *http.Request) {
+	var in resolveModerationCaseInput
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	caseID, err := strconv.ParseInt(way.Param(ctx, "case_id"), 10, 64)
+	if err != nil {
+		http.Error(w, service.ErrModerationCaseNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	c, err := h.ResolveModerationCase(ctx, caseID, service.ResolveInput{
+		Action:      in.Action,
+		Note:        in.Note,
+		SuspendDays: in.SuspendDays,
+	})
+	if err != nil {
+		respondModerationCaseError(w, err)
+		return
+	}
+
+	respond(w, c, http.StatusOK)
+}
+
+// respondModerationCaseError maps the errors of handling a moderation case
+func respondModerationCaseError(w http.ResponseWriter, err error) {
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrForbidden || err == service.ErrForbiddenSuspension {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err == service.ErrModerationCaseNotFound || err == service.ErrReportTargetNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err == service.ErrModerationCaseClaimed || err == service.ErrModerationCaseResolved {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	if err == service.ErrInvalidModerationAction {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	respondError(w, err)
+}
+
+func (h *handler) flaggedAccounts(w http.ResponseWriter, r *http.Request) {
+	after, first := pageParams(r)
+
+	ff, err := h.FlaggedAccounts(r.Context(), after, first)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrForbidden {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err == service.ErrInvalidCursor {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, ff, http.StatusOK)
+}
+
+func (h *handler) clearFlaggedAccount(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	err := h.ClearFlaggedAccount(ctx, way.Param(ctx, "username"))
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrForbidden {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err == service.ErrFlagNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
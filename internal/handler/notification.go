@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/dhruvsingh510/bond_social_api/internal/service"
+	"github.com/matryer/way"
+)
+
+func (h *handler) notifications(w http.ResponseWriter, r *http.Request) {
+	after, first := pageParams(r)
+
+	nn, err := h.Notifications(r.Context(), after, first)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrInvalidCursor {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, nn, http.StatusOK)
+}
+
+func (h *handler) markNotificationAsRead(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	notificationID, err := strconv.ParseInt(way.Param(ctx, "notification_id"), 10, 64)
+	if err != nil {
+		http.Error(w, service.ErrNotificationNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	err = h.MarkNotificationAsRead(ctx, notificationID)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrNotificationNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) markNotificationsAsRead(w http.ResponseWriter, r *http.Request) {
+	err := h.MarkNotificationsAsRead(r.Context())
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -3,22 +3,28 @@ package handler
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 
 	"github.com/dhruvsingh510/bond_social_api/internal/service"
 	"github.com/matryer/way"
 )
 
 type createPostInput struct {
+	Community string
 	Title string
 	Body string
 	Link string
-	Album string
-	Poll string
+	Album []int64
+	Poll *service.PollInput
+	QuotedPostID *int64
 }
 
-type postEngagementInput struct {
-	PostID int64
-	Action string
+type voteInput struct {
+	Value *int
+}
+
+type pollVoteInput struct {
+	Options []int64
 }
 
 type postCommentInput struct {
@@ -35,17 +41,27 @@ func (h *handler) createPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ti, err := h.CreatePost(r.Context(), in.Title, in.Body, in.Link, in.Album, in.Poll)
+	ti, err := h.CreatePost(r.Context(), in.Community, in.Title, in.Body, in.Link, in.Album, in.Poll, in.QuotedPostID)
 	if err == service.ErrUnauthenticated {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 
-	if err == service.ErrInvalidTitle || err == service.ErrInvalidEmail || err == service.ErrInvalidBody || err == service.ErrNoContent {
+	if err == service.ErrInvalidTitle || err == service.ErrInvalidLink || err == service.ErrInvalidBody || err == service.ErrNoContent || err == service.ErrInvalidPoll || err == service.ErrInvalidAlbum || err == service.ErrInvalidCommunityName || err == service.ErrContentRejected || err == service.ErrInvalidQuotedPost {
 		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 		return
 	}
 
+	if err == service.ErrCommunityNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err == service.ErrNotCommunityMember {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
 	if err != nil {
 		respondError(w, err)
 		return
@@ -58,9 +74,11 @@ func (h *handler) posts(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	username := way.Param(ctx, "username")
 
-	pp, err := h.Posts(ctx, username) 
+	after, first := pageParams(r)
 
-	if err == service.ErrInvalidUsername {
+	pp, err := h.Posts(ctx, username, after, first)
+
+	if err == service.ErrInvalidUsername || err == service.ErrInvalidCursor {
 		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 		return
 	}
@@ -72,6 +90,7 @@ func (h *handler) posts(w http.ResponseWriter, r *http.Request) {
 
 	if err != nil {
 		respondError(w, err)
+		return
 	}
 
 	respond(w, pp, http.StatusOK)
@@ -88,23 +107,94 @@ func (h *handler) post(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err == service.ErrInvalidPostID {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
 	if err != nil {
 		respondError(w, err)
+		return
 	}
 
 	respond(w, p, http.StatusOK)
 }
 
 func (h *handler) postVote(w http.ResponseWriter, r *http.Request) {
-	var in postEngagementInput
+	ctx := r.Context()
+	postID, err := strconv.ParseInt(way.Param(ctx, "post_id"), 10, 64)
+	if err != nil {
+		http.Error(w, service.ErrInvalidPostID.Error(), http.StatusNotFound)
+		return
+	}
+
+	var in voteInput
 	defer r.Body.Close()
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	err := h.PostVote(r.Context(), in.PostID, in.Action)
+	if in.Value == nil {
+		http.Error(w, service.ErrInvalidVote.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	out, err := h.PostVote(ctx, postID, *in.Value)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrInvalidVote {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err == service.ErrInvalidPostID {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err == service.ErrNotCommunityMember {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, out, http.StatusOK)
+}
+
+func (h *handler) repost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	postID, err := strconv.ParseInt(way.Param(ctx, "post_id"), 10, 64)
+	if err != nil {
+		http.Error(w, service.ErrInvalidPostID.Error(), http.StatusNotFound)
+		return
+	}
+
+	out, err := h.Repost(ctx, postID)
+	respondRepost(w, out, err)
+}
+
+func (h *handler) undoRepost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	postID, err := strconv.ParseInt(way.Param(ctx, "post_id"), 10, 64)
+	if err != nil {
+		http.Error(w, service.ErrInvalidPostID.Error(), http.StatusNotFound)
+		return
+	}
+
+	out, err := h.UndoRepost(ctx, postID)
+	respondRepost(w, out, err)
+}
 
+func respondRepost(w http.ResponseWriter, out service.RepostOutput, err error) {
 	if err == service.ErrUnauthenticated {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
@@ -119,8 +209,8 @@ func (h *handler) postVote(w http.ResponseWriter, r *http.Request) {
 		respondError(w, err)
 		return
 	}
-	
-	respond(w, "success", http.StatusNoContent)
+
+	respond(w, out, http.StatusOK)
 }
 
 func (h *handler) postComment(w http.ResponseWriter, r *http.Request) {
@@ -131,25 +221,106 @@ func (h *handler) postComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.PostComment(r.Context(), in.PostID, in.ParentCommentID, in.Comment)
+	c, err := h.PostComment(r.Context(), in.PostID, in.ParentCommentID, in.Comment)
 
 	if err == service.ErrUnauthenticated {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 
+	if err == service.ErrInvalidComment || err == service.ErrInvalidParentComment || err == service.ErrThreadTooDeep || err == service.ErrContentRejected {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
 	if err == service.ErrInvalidPostID {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
+	if err == service.ErrNotCommunityMember {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, c, http.StatusCreated)
+}
+
+func (h *handler) feed(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	after, first := pageParams(r)
+
+	pp, err := h.Feed(r.Context(), "", q.Get("sort"), q.Get("t"), after, first)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrInvalidSort || err == service.ErrInvalidPeriod || err == service.ErrInvalidCursor {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
 	if err != nil {
 		respondError(w, err)
 		return
 	}
-	
-	respond(w, "success", http.StatusNoContent)
+
+	respond(w, pp, http.StatusOK)
 }
 
+func (h *handler) pollVote(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	postID, err := strconv.ParseInt(way.Param(ctx, "post_id"), 10, 64)
+	if err != nil {
+		http.Error(w, service.ErrInvalidPostID.Error(), http.StatusNotFound)
+		return
+	}
+
+	var in pollVoteInput
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p, err := h.VotePoll(ctx, postID, in.Options)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrPollNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err == service.ErrInvalidPollOption {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err == service.ErrPollClosed || err == service.ErrAlreadyVoted {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	if err == service.ErrNotCommunityMember {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 
+	if err != nil {
+		respondError(w, err)
+		return
+	}
 
+	respond(w, p, http.StatusOK)
+}
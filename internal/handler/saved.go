@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/dhruvsingh510/bond_social_api/internal/service"
+	"github.com/matryer/way"
+)
+
+type saveInput struct {
+	Collection string `json:"collection"`
+}
+
+// decodeSaveInput decodes the optional body of save requests.
+func decodeSaveInput(w http.ResponseWriter, r *http.Request) (saveInput, bool) {
+	var in saveInput
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return in, false
+	}
+
+	return in, true
+}
+
+func (h *handler) savePost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	postID, err := strconv.ParseInt(way.Param(ctx, "post_id"), 10, 64)
+	if err != nil {
+		http.Error(w, service.ErrInvalidPostID.Error(), http.StatusNotFound)
+		return
+	}
+
+	in, ok := decodeSaveInput(w, r)
+	if !ok {
+		return
+	}
+
+	err = h.SavePost(ctx, postID, in.Collection)
+
+	if err == service.ErrInvalidPostID {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondSavedError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) unsavePost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	postID, err := strconv.ParseInt(way.Param(ctx, "post_id"), 10, 64)
+	if err != nil {
+		http.Error(w, service.ErrInvalidPostID.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err = h.UnsavePost(ctx, postID); err != nil {
+		respondSavedError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) saveComment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	commentID, err := strconv.ParseInt(way.Param(ctx, "comment_id"), 10, 64)
+	if err != nil {
+		http.Error(w, service.ErrCommentNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	in, ok := decodeSaveInput(w, r)
+	if !ok {
+		return
+	}
+
+	err = h.SaveComment(ctx, commentID, in.Collection)
+
+	if err == service.ErrCommentNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondSavedError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) unsaveComment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	commentID, err := strconv.ParseInt(way.Param(ctx, "comment_id"), 10, 64)
+	if err != nil {
+		http.Error(w, service.ErrCommentNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err = h.UnsaveComment(ctx, commentID); err != nil {
+		respondSavedError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) saved(w http.ResponseWriter, r *http.Request) {
+	after, first := pageParams(r)
+
+	var collection *string
+	if q := r.URL.Query(); q.Has("collection") {
+		c := q.Get("collection")
+		collection = &c
+	}
+
+	ii, err := h.Saved(r.Context(), collection, after, first)
+
+	if err == service.ErrInvalidCursor {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err != nil {
+		respondSavedError(w, err)
+		return
+	}
+
+	respond(w, ii, http.StatusOK)
+}
+
+func (h *handler) savedCollections(w http.ResponseWriter, r *http.Request) {
+	cc, err := h.SavedCollections(r.Context())
+	if err != nil {
+		respondSavedError(w, err)
+		return
+	}
+
+	respond(w, cc, http.StatusOK)
+}
+
+func respondSavedError(w http.ResponseWriter, err error) {
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrInvalidCollection {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	respondError(w, err)
+}
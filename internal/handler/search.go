@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/dhruvsingh510/bond_social_api/internal/service"
+)
+
+// parseSearchTime reads an RFC 3339 time or a plain date from the query string.
+func parseSearchTime(s string) (*time.Time, bool) {
+	if s == "" {
+		return nil, true
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return &t, true
+		}
+	}
+
+	return nil, false
+}
+
+func (h *handler) search(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+	after, first := pageParams(r)
+
+	from, ok := parseSearchTime(q.Get("from"))
+	if !ok {
+		http.Error(w, "invalid from date", http.StatusUnprocessableEntity)
+		return
+	}
+
+	to, ok := parseSearchTime(q.Get("to"))
+	if !ok {
+		http.Error(w, "invalid to date", http.StatusUnprocessableEntity)
+		return
+	}
+
+	f := service.SearchFilter{
+		Query:  q.Get("q"),
+		Author: q.Get("author"),
+		Tag:    q.Get("tag"),
+		From:   from,
+		To:     to,
+	}
+
+	var out interface{}
+	var err error
+	switch q.Get("type") {
+	case "", service.SearchPosts:
+		out, err = h.SearchPosts(ctx, f, after, first)
+	case service.SearchComments:
+		out, err = h.SearchComments(ctx, f, after, first)
+	case service.SearchUsers:
+		out, err = h.SearchUsers(ctx, f, after, first)
+	default:
+		err = service.ErrInvalidSearchType
+	}
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrInvalidSearchType ||
+		err == service.ErrInvalidSearchQuery ||
+		err == service.ErrInvalidTag ||
+		err == service.ErrInvalidCursor {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, out, http.StatusOK)
+}
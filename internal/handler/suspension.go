@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/dhruvsingh510/bond_social_api/internal/service"
+	"github.com/matryer/way"
+)
+
+type suspendUserInput struct {
+	Reason string
+	// zero bans the user
+	Days int
+}
+
+type appealSuspensionInput struct {
+	Message string
+}
+
+type suspensionOutput struct {
+	Suspension *service.Suspension `json:"suspension"`
+}
+
+func (h *handler) authUserSuspension(w http.ResponseWriter, r *http.Request) {
+	sp, err := h.ActiveSuspension(r.Context())
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, suspensionOutput{sp}, http.StatusOK)
+}
+
+func (h *handler) appealSuspension(w http.ResponseWriter, r *http.Request) {
+	var in appealSuspensionInput
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a, err := h.AppealSuspension(r.Context(), in.Message)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrInvalidAppeal {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err == service.ErrUserNotSuspended || err == service.ErrAppealExists {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, a, http.StatusCreated)
+}
+
+func (h *handler) suspendUser(w http.ResponseWriter, r *http.Request) {
+	var in suspendUserInput
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	sp, err := h.SuspendUser(ctx, way.Param(ctx, "username"), in.Reason, in.Days)
+
+	if err == service.ErrInvalidSuspension {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err != nil {
+		respondSuspensionError(w, err)
+		return
+	}
+
+	respond(w, sp, http.StatusCreated)
+}
+
+func (h *handler) liftSuspension(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	err := h.LiftSuspension(ctx, way.Param(ctx, "username"))
+
+	if err == service.ErrUserNotSuspended {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondSuspensionError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) shadowbanUser(w http.ResponseWriter, r *http.Request) {
+	h.setShadowban(w, r, true)
+}
+
+func (h *handler) unshadowbanUser(w http.ResponseWriter, r *http.Request) {
+	h.setShadowban(w, r, false)
+}
+
+func (h *handler) setShadowban(w http.ResponseWriter, r *http.Request, shadowbanned bool) {
+	ctx := r.Context()
+	err := h.SetShadowban(ctx, way.Param(ctx, "username"), shadowbanned)
+
+	if err != nil {
+		respondSuspensionError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) appeals(w http.ResponseWriter, r *http.Request) {
+	after, first := pageParams(r)
+
+	aa, err := h.Appeals(r.Context(), after, first)
+
+	if err == service.ErrInvalidCursor {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err != nil {
+		respondSuspensionError(w, err)
+		return
+	}
+
+	respond(w, aa, http.StatusOK)
+}
+
+func (h *handler) approveAppeal(w http.ResponseWriter, r *http.Request) {
+	h.resolveAppeal(w, r, true)
+}
+
+func (h *handler) rejectAppeal(w http.ResponseWriter, r *http.Request) {
+	h.resolveAppeal(w, r, false)
+}
+
+func (h *handler) resolveAppeal(w http.ResponseWriter, r *http.Request, approve bool) {
+	ctx := r.Context()
+	appealID, err := strconv.ParseInt(way.Param(ctx, "appeal_id"), 10, 64)
+	if err != nil {
+		http.Error(w, service.ErrAppealNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	err = h.ResolveAppeal(ctx, appealID, approve)
+
+	if err == service.ErrAppealNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondSuspensionError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// respondSuspensionError maps the errors shared by the suspension endpoints of moderators
+func respondSuspensionError(w http.ResponseWriter, err error) {
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrForbidden || err == service.ErrForbiddenSuspension {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err == service.ErrUserNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	respondError(w, err)
+}
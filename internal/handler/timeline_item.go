@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dhruvsingh510/bond_social_api/internal/service"
+)
+
+func (h *handler) timeline(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		h.subscribeToTimeline(w, r)
+		return
+	}
+
+	after, first := pageParams(r)
+
+	tt, err := h.Timeline(r.Context(), after, first)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err == service.ErrInvalidCursor {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respond(w, tt, http.StatusOK)
+}
+
+// subscribeToTimeline streams new timeline items and notifications as server-sent events
+func (h *handler) subscribeToTimeline(w http.ResponseWriter, r *http.Request) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		respondError(w, fmt.Errorf("streaming unsupported"))
+		return
+	}
+
+	ctx := r.Context()
+	ee, err := h.SubscribeToTimeline(ctx)
+
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-ee:
+			b, err := json.Marshal(e)
+			if err != nil {
+				respondError(w, fmt.Errorf("could not marshal live event: %v", err))
+				return
+			}
+
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, b)
+			f.Flush()
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 )
 
 func respond(w http.ResponseWriter, v interface{}, statusCode int) {
@@ -22,4 +23,11 @@ func respond(w http.ResponseWriter, v interface{}, statusCode int) {
 func respondError(w http.ResponseWriter, err error) {
 	log.Println(err)
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-}
\ No newline at end of file
+}
+
+// pageParams reads the "after" cursor and "first" page size from the query string
+func pageParams(r *http.Request) (string, int) {
+	q := r.URL.Query()
+	first, _ := strconv.Atoi(q.Get("first"))
+	return q.Get("after"), first
+}
@@ -0,0 +1,180 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// exifSegment builds an APP1 segment with a little endian TIFF header
+// holding a single orientation entry.
+func exifSegment(orientation byte) []byte {
+	tiff := []byte{
+		'I', 'I', 42, 0, 8, 0, 0, 0, // header, IFD0 at offset 8
+		1, 0, // one entry
+		0x12, 0x01, 3, 0, 1, 0, 0, 0, orientation, 0, 0, 0, // orientation SHORT
+		0, 0, 0, 0, // no next IFD
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	n := len(payload) + 2
+	return append([]byte{0xFF, 0xE1, byte(n >> 8), byte(n)}, payload...)
+}
+
+func testJPEG(t *testing.T, w, h int) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// insert the EXIF segment right after SOI
+	b := buf.Bytes()
+	return append(append([]byte{0xFF, 0xD8}, exifSegment(6)...), b[2:]...)
+}
+
+func TestStripJPEG(t *testing.T) {
+	data := testJPEG(t, 4, 2)
+	if o := Orientation(data); o != 6 {
+		t.Fatalf("expected orientation 6, got %d", o)
+	}
+
+	stripped, err := StripMetadata(data, "image/jpeg")
+	if err != nil {
+		t.Fatalf("could not strip metadata: %v", err)
+	}
+
+	if bytes.Contains(stripped, []byte("Exif")) {
+		t.Error("expected EXIF segment to be removed")
+	}
+
+	if o := Orientation(stripped); o != 1 {
+		t.Errorf("expected orientation 1 after stripping, got %d", o)
+	}
+
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("stripped JPEG does not decode: %v", err)
+	}
+}
+
+func TestStripGIF(t *testing.T) {
+	var buf bytes.Buffer
+	img := image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black, color.White})
+	if err := gif.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// insert a comment and an XMP extension after the logical screen descriptor
+	// and its 2 colors global color table
+	b := buf.Bytes()
+	comment := append([]byte{0x21, 0xFE, 6}, "secret"...)
+	comment = append(comment, 0)
+	xmp := append([]byte{0x21, 0xFF, 11}, "XMP DataXMP"...)
+	xmp = append(append(xmp, 4), "gps!"...)
+	xmp = append(xmp, 0)
+	data := append(append(append(append([]byte{}, b[:19]...), comment...), xmp...), b[19:]...)
+
+	if _, err := gif.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("test GIF does not decode: %v", err)
+	}
+
+	stripped, err := StripMetadata(data, "image/gif")
+	if err != nil {
+		t.Fatalf("could not strip metadata: %v", err)
+	}
+
+	if bytes.Contains(stripped, []byte("secret")) || bytes.Contains(stripped, []byte("XMP")) {
+		t.Error("expected comment and XMP extensions to be removed")
+	}
+
+	if !bytes.Equal(stripped, b) {
+		t.Error("expected the image blocks to be kept verbatim")
+	}
+}
+
+func TestOrient(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	img.Set(0, 0, color.White)
+
+	out := Orient(img, 6)
+	if b := out.Bounds(); b.Dx() != 2 || b.Dy() != 4 {
+		t.Fatalf("expected 2x4, got %dx%d", b.Dx(), b.Dy())
+	}
+
+	// rotating clockwise moves the top left corner to the top right
+	if r, _, _, _ := out.At(1, 0).RGBA(); r != 0xFFFF {
+		t.Error("expected top left pixel to end up top right")
+	}
+}
+
+func TestBlurhash(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+
+	hash := Blurhash(img, 4, 3)
+	if len(hash) != 28 {
+		t.Fatalf("expected 28 characters, got %q", hash)
+	}
+
+	// pure red encodes as the DC value 0xFF0000
+	if dc := hash[2:6]; dc != "TI:j" {
+		t.Errorf("expected DC TI:j, got %q in %q", dc, hash)
+	}
+}
+
+func TestEncodeWebP(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	solid := image.NewNRGBA(image.Rect(0, 0, 5, 3))
+	for i := range solid.Pix {
+		solid.Pix[i] = 0x80
+	}
+
+	gradient := image.NewNRGBA(image.Rect(0, 0, 70, 45))
+	for y := 0; y < 45; y++ {
+		for x := 0; x < 70; x++ {
+			gradient.Set(x, y, color.NRGBA{R: uint8(x * 3), G: uint8(y * 5), B: uint8(x + y), A: uint8(255 - x)})
+		}
+	}
+
+	// skewed noise makes for codes deeper than VP8L allows
+	noise := image.NewNRGBA(image.Rect(0, 0, 300, 200))
+	for i := range noise.Pix {
+		noise.Pix[i] = uint8(rnd.ExpFloat64() * 8)
+	}
+
+	tests := []struct {
+		name string
+		img  *image.NRGBA
+	}{
+		{"single pixel", image.NewNRGBA(image.Rect(0, 0, 1, 1))},
+		{"solid", solid},
+		{"gradient", gradient},
+		{"noise", noise},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := EncodeWebP(&buf, tt.img); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		out, err := webp.Decode(&buf)
+		if err != nil {
+			t.Fatalf("%s: could not decode: %v", tt.name, err)
+		}
+
+		got, ok := out.(*image.NRGBA)
+		if !ok || got.Bounds() != tt.img.Bounds() || !bytes.Equal(got.Pix, tt.img.Pix) {
+			t.Errorf("%s: decoded image differs from the encoded one", tt.name)
+		}
+	}
+}
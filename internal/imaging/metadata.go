@@ -0,0 +1,336 @@
+// Package imaging holds the image transformations run on uploaded media.
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// ErrMalformed used when an encoded image cannot be parsed
+var ErrMalformed = errors.New("malformed image")
+
+// StripMetadata removes EXIF, XMP and textual metadata from an encoded image
+// without re-encoding its pixels. Formats without such metadata are returned as is.
+func StripMetadata(data []byte, contentType string) ([]byte, error) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		return stripPNG(data)
+	case "image/webp":
+		return stripWebP(data)
+	case "image/gif":
+		return stripGIF(data)
+	}
+
+	return data, nil
+}
+
+// stripJPEG drops every APPn and COM segment other than
+// JFIF (APP0), ICC profiles (APP2) and Adobe color info (APP14).
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, ErrMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF {
+			return nil, ErrMalformed
+		}
+
+		// skip fill bytes
+		for i+1 < len(data) && data[i+1] == 0xFF {
+			i++
+		}
+
+		if i+1 >= len(data) {
+			return nil, ErrMalformed
+		}
+
+		marker := data[i+1]
+
+		// start of scan: the entropy coded data up to the end is kept verbatim
+		if marker == 0xDA {
+			return append(out, data[i:]...), nil
+		}
+
+		// standalone markers carry no length
+		if marker == 0x01 || marker >= 0xD0 && marker <= 0xD7 {
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+
+		if i+4 > len(data) {
+			return nil, ErrMalformed
+		}
+
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) || end < i+4 {
+			return nil, ErrMalformed
+		}
+
+		drop := marker == 0xFE || marker >= 0xE0 && marker <= 0xEF &&
+			marker != 0xE0 && marker != 0xE2 && marker != 0xEE
+		if !drop {
+			out = append(out, data[i:end]...)
+		}
+
+		i = end
+	}
+
+	return nil, ErrMalformed
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngMetadataChunks are the ancillary chunks holding text, EXIF or timestamps
+var pngMetadataChunks = map[string]bool{
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"eXIf": true,
+	"tIME": true,
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, ErrMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+
+	i := len(pngSignature)
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, ErrMalformed
+		}
+
+		// length, type, data and crc
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end > len(data) || end < i+12 {
+			return nil, ErrMalformed
+		}
+
+		if !pngMetadataChunks[string(data[i+4:i+8])] {
+			out = append(out, data[i:end]...)
+		}
+
+		i = end
+	}
+
+	return out, nil
+}
+
+// stripWebP drops the EXIF and XMP chunks of the RIFF container
+// and clears their flags from the extended header.
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, ErrMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+
+	i := 12
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, ErrMalformed
+		}
+
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size&1
+		if end > len(data) || end < i+8 {
+			return nil, ErrMalformed
+		}
+
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			if size < 1 {
+				return nil, ErrMalformed
+			}
+			start := len(out)
+			out = append(out, data[i:end]...)
+			out[start+8] &^= 0x08 | 0x04
+		default:
+			out = append(out, data[i:end]...)
+		}
+
+		i = end
+	}
+
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+
+	return out, nil
+}
+
+// gifKeptApplications are the application extensions kept in GIFs,
+// which control animation looping rather than hold metadata.
+var gifKeptApplications = map[string]bool{
+	"NETSCAPE2.0": true,
+	"ANIMEXTS1.0": true,
+}
+
+// stripGIF drops the comment extensions and the application extensions
+// other than animation ones, such as XMP.
+func stripGIF(data []byte) ([]byte, error) {
+	if len(data) < 13 || string(data[:3]) != "GIF" {
+		return nil, ErrMalformed
+	}
+
+	// header, logical screen descriptor and global color table
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (1 + data[10]&0x07)
+	}
+
+	if i > len(data) {
+		return nil, ErrMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:i]...)
+
+	for i < len(data) {
+		switch data[i] {
+		case 0x3B: // trailer
+			return append(out, data[i]), nil
+		case 0x2C: // image descriptor, local color table and image data
+			if i+11 > len(data) {
+				return nil, ErrMalformed
+			}
+
+			start := i
+			i += 10
+			if data[i-1]&0x80 != 0 {
+				i += 3 << (1 + data[i-1]&0x07)
+			}
+
+			// LZW minimum code size
+			i++
+			end, err := gifSubBlocksEnd(data, i)
+			if err != nil {
+				return nil, err
+			}
+
+			out = append(out, data[start:end]...)
+			i = end
+		case 0x21: // extension
+			if i+2 > len(data) {
+				return nil, ErrMalformed
+			}
+
+			end, err := gifSubBlocksEnd(data, i+2)
+			if err != nil {
+				return nil, err
+			}
+
+			keep := true
+			switch data[i+1] {
+			case 0xFE:
+				keep = false
+			case 0xFF:
+				keep = i+14 <= end && data[i+2] == 11 && gifKeptApplications[string(data[i+3:i+14])]
+			}
+
+			if keep {
+				out = append(out, data[i:end]...)
+			}
+
+			i = end
+		default:
+			return nil, ErrMalformed
+		}
+	}
+
+	return nil, ErrMalformed
+}
+
+// gifSubBlocksEnd returns the offset following the sub-blocks starting at i,
+// terminator included.
+func gifSubBlocksEnd(data []byte, i int) (int, error) {
+	for {
+		if i >= len(data) {
+			return 0, ErrMalformed
+		}
+
+		n := int(data[i])
+		i++
+		if n == 0 {
+			return i, nil
+		}
+
+		i += n
+	}
+}
+
+// Orientation reads the EXIF orientation of a JPEG, from 1 to 8.
+// It returns 1 (upright) when there is none.
+func Orientation(data []byte) int {
+	i := 2
+	for i+4 <= len(data) && data[i] == 0xFF {
+		marker := data[i+1]
+		if marker == 0xDA {
+			break
+		}
+
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) {
+			break
+		}
+
+		if marker == 0xE1 && bytes.HasPrefix(data[i+4:end], []byte("Exif\x00\x00")) {
+			return exifOrientation(data[i+10 : end])
+		}
+
+		i = end
+	}
+
+	return 1
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) || ifd < 8 {
+		return 1
+	}
+
+	n := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < n; e++ {
+		off := ifd + 2 + e*12
+		if off+12 > len(tiff) {
+			return 1
+		}
+
+		if order.Uint16(tiff[off:]) == 0x0112 {
+			o := int(order.Uint16(tiff[off+8:]))
+			if o < 1 || o > 8 {
+				return 1
+			}
+			return o
+		}
+	}
+
+	return 1
+}
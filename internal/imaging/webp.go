@@ -0,0 +1,433 @@
+package imaging
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// VP8L stores dimensions minus one on 14 bits
+	maxWebPSide = 1 << 14
+	// log2 of the side of the tiles sharing a predictor
+	webpPredictorBits = 5
+	// max code lengths of the pixel and code length prefix codes
+	maxCodeLength       = 15
+	maxCodeLengthLength = 7
+)
+
+// ErrTooLarge used when an image exceeds what a format can hold
+var ErrTooLarge = errors.New("image too large")
+
+// webpCodeLengthOrder is the order code length code lengths are written in.
+var webpCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// webpPredictors are the predictor modes tried on every tile:
+// left, top and the average of both.
+var webpPredictors = []uint32{1, 2, 7}
+
+// EncodeWebP writes img as a lossless WebP. It only uses the subtract green
+// and predictor transforms with literal pixels, trading size for a short encoder:
+// files are larger than what libwebp makes but decode the same anywhere.
+func EncodeWebP(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > maxWebPSide || height > maxWebPSide {
+		return ErrTooLarge
+	}
+
+	nrgba := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(nrgba, nrgba.Bounds(), img, b.Min, draw.Src)
+
+	argb := make([]uint32, width*height)
+	opaque := true
+	for i := range argb {
+		p := nrgba.Pix[i*4 : i*4+4]
+		r, g, bl, a := uint32(p[0]), uint32(p[1]), uint32(p[2]), uint32(p[3])
+		// subtract green
+		argb[i] = a<<24 | (r-g)&0xff<<16 | g<<8 | (bl-g)&0xff
+		opaque = opaque && a == 0xff
+	}
+
+	var bw bitWriter
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if opaque {
+		bw.write(0, 1)
+	} else {
+		bw.write(1, 1)
+	}
+	bw.write(0, 3)
+
+	// transforms are undone in reverse order
+	bw.write(1, 1)
+	bw.write(2, 2)
+
+	modes, residuals := predict(argb, width, height)
+	bw.write(1, 1)
+	bw.write(0, 2)
+	bw.write(webpPredictorBits-2, 3)
+	writeEntropyImage(&bw, modes, false)
+
+	bw.write(0, 1)
+	writeEntropyImage(&bw, residuals, true)
+
+	data := bw.bytes()
+	pad := len(data) & 1
+
+	var buf bytes.Buffer
+	buf.Grow(20 + len(data) + pad)
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(12+len(data)+pad))
+	buf.WriteString("WEBPVP8L")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+	if pad != 0 {
+		buf.WriteByte(0)
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// predict picks the predictor of each tile of argb and returns the modes
+// as an image, along with the residuals left to encode.
+func predict(argb []uint32, width, height int) ([]uint32, []uint32) {
+	tile := 1 << webpPredictorBits
+	tilesX, tilesY := (width+tile-1)/tile, (height+tile-1)/tile
+
+	modes := make([]uint32, tilesX*tilesY)
+	residuals := make([]uint32, len(argb))
+	for ty := 0; ty < tilesY; ty++ {
+		for tx := 0; tx < tilesX; tx++ {
+			x0, y0 := tx*tile, ty*tile
+			x1, y1 := minInt(x0+tile, width), minInt(y0+tile, height)
+
+			best, bestCost := webpPredictors[0], -1
+			for _, mode := range webpPredictors {
+				cost := 0
+				for y := y0; y < y1; y++ {
+					for x := x0; x < x1; x++ {
+						cost += residualCost(residual(argb, width, x, y, mode))
+					}
+				}
+
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+
+			modes[ty*tilesX+tx] = 0xff000000 | best<<8
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					residuals[y*width+x] = residual(argb, width, x, y, best)
+				}
+			}
+		}
+	}
+
+	return modes, residuals
+}
+
+// residual is what is left of the pixel at x, y once predicted with mode.
+// The first row and column have fixed predictors.
+func residual(argb []uint32, width, x, y int, mode uint32) uint32 {
+	i := y*width + x
+	var pred uint32
+	switch {
+	case x == 0 && y == 0:
+		pred = 0xff000000
+	case y == 0:
+		pred = argb[i-1]
+	case x == 0:
+		pred = argb[i-width]
+	case mode == 1:
+		pred = argb[i-1]
+	case mode == 2:
+		pred = argb[i-width]
+	default:
+		l, t := argb[i-1], argb[i-width]
+		pred = (((l ^ t) & 0xfefefefe) >> 1) + (l & t)
+	}
+
+	var r uint32
+	for shift := 0; shift < 32; shift += 8 {
+		r |= (argb[i]>>shift - pred>>shift) & 0xff << shift
+	}
+
+	return r
+}
+
+// residualCost estimates how many bits a residual takes, small ones being cheaper.
+func residualCost(r uint32) int {
+	cost := 0
+	for shift := 0; shift < 32; shift += 8 {
+		v := int(int8(r >> shift))
+		if v < 0 {
+			v = -v
+		}
+		cost += v
+	}
+
+	return cost
+}
+
+// writeEntropyImage writes argb as literal pixels under a single group of prefix codes.
+func writeEntropyImage(bw *bitWriter, argb []uint32, topLevel bool) {
+	// no color cache
+	bw.write(0, 1)
+	if topLevel {
+		// no meta prefix codes
+		bw.write(0, 1)
+	}
+
+	// green, red, blue, alpha, distance
+	histograms := [5][]int{make([]int, 280), make([]int, 256), make([]int, 256), make([]int, 256), make([]int, 40)}
+	for _, p := range argb {
+		histograms[0][p>>8&0xff]++
+		histograms[1][p>>16&0xff]++
+		histograms[2][p&0xff]++
+		histograms[3][p>>24]++
+	}
+
+	var codes [5][]prefixCode
+	for i, h := range histograms {
+		codes[i] = writePrefixCode(bw, h)
+	}
+
+	for _, p := range argb {
+		bw.writeCode(codes[0][p>>8&0xff])
+		bw.writeCode(codes[1][p>>16&0xff])
+		bw.writeCode(codes[2][p&0xff])
+		bw.writeCode(codes[3][p>>24])
+	}
+}
+
+type prefixCode struct {
+	bits   uint32
+	length int
+}
+
+// writePrefixCode writes the prefix code fitting the histogram and returns it.
+// Symbols beyond the first 256 are never used.
+func writePrefixCode(bw *bitWriter, histogram []int) []prefixCode {
+	codes := make([]prefixCode, len(histogram))
+
+	var used []int
+	for s, n := range histogram {
+		if n != 0 {
+			used = append(used, s)
+		}
+	}
+
+	if len(used) <= 2 {
+		// simple code, a lone symbol taking no bits
+		if len(used) == 0 {
+			used = append(used, 0)
+		}
+
+		bw.write(1, 1)
+		bw.write(uint32(len(used)-1), 1)
+		bw.write(1, 1)
+		bw.write(uint32(used[0]), 8)
+		if len(used) == 2 {
+			bw.write(uint32(used[1]), 8)
+			codes[used[0]] = prefixCode{bits: 0, length: 1}
+			codes[used[1]] = prefixCode{bits: 1, length: 1}
+		}
+
+		return codes
+	}
+
+	lengths := codeLengths(histogram, maxCodeLength)
+
+	lengthHistogram := make([]int, 19)
+	for _, l := range lengths {
+		lengthHistogram[l]++
+	}
+
+	lengthLengths := codeLengths(lengthHistogram, maxCodeLengthLength)
+	lengthCodes := canonicalCodes(lengthLengths)
+
+	n := 4
+	for i, s := range webpCodeLengthOrder {
+		if lengthLengths[s] != 0 && i+1 > n {
+			n = i + 1
+		}
+	}
+
+	bw.write(0, 1)
+	bw.write(uint32(n-4), 4)
+	for _, s := range webpCodeLengthOrder[:n] {
+		bw.write(uint32(lengthLengths[s]), 3)
+	}
+
+	// code lengths for the whole alphabet
+	bw.write(0, 1)
+	for _, l := range lengths {
+		bw.writeCode(lengthCodes[l])
+	}
+
+	return canonicalCodes(lengths)
+}
+
+// canonicalCodes assigns the canonical prefix codes of the given code lengths.
+// A lone symbol takes no bits.
+func canonicalCodes(lengths []int) []prefixCode {
+	codes := make([]prefixCode, len(lengths))
+
+	var count [maxCodeLength + 1]int
+	used := 0
+	for _, l := range lengths {
+		if l != 0 {
+			count[l]++
+			used++
+		}
+	}
+
+	if used < 2 {
+		return codes
+	}
+
+	var next [maxCodeLength + 1]uint32
+	code := uint32(0)
+	for l := 1; l <= maxCodeLength; l++ {
+		code = (code + uint32(count[l-1])) << 1
+		next[l] = code
+	}
+
+	for s, l := range lengths {
+		if l != 0 {
+			codes[s] = prefixCode{bits: next[l], length: l}
+			next[l]++
+		}
+	}
+
+	return codes
+}
+
+// codeLengths builds the Huffman code lengths of the histogram, at most limit long.
+// Counts get flattened until the tree is shallow enough.
+func codeLengths(histogram []int, limit int) []int {
+	counts := append([]int(nil), histogram...)
+	for {
+		lengths := huffmanLengths(counts)
+
+		deepest := 0
+		for _, l := range lengths {
+			deepest = maxInt(deepest, l)
+		}
+
+		if deepest <= limit {
+			return lengths
+		}
+
+		for s, n := range counts {
+			if n != 0 {
+				counts[s] = maxInt(1, n/2)
+			}
+		}
+	}
+}
+
+type huffmanNode struct {
+	count       int
+	symbol      int
+	left, right *huffmanNode
+}
+
+type huffmanHeap []*huffmanNode
+
+func (h huffmanHeap) Len() int            { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool  { return h[i].count < h[j].count }
+func (h huffmanHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x interface{}) { *h = append(*h, x.(*huffmanNode)) }
+func (h *huffmanHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// huffmanLengths builds the unbounded Huffman code lengths of the counts.
+// A lone symbol gets a length of 1.
+func huffmanLengths(counts []int) []int {
+	lengths := make([]int, len(counts))
+
+	h := huffmanHeap{}
+	for s, n := range counts {
+		if n != 0 {
+			h = append(h, &huffmanNode{count: n, symbol: s})
+		}
+	}
+
+	if len(h) == 1 {
+		lengths[h[0].symbol] = 1
+		return lengths
+	}
+
+	heap.Init(&h)
+	for h.Len() > 1 {
+		a, b := heap.Pop(&h).(*huffmanNode), heap.Pop(&h).(*huffmanNode)
+		heap.Push(&h, &huffmanNode{count: a.count + b.count, left: a, right: b})
+	}
+
+	var walk func(n *huffmanNode, depth int)
+	walk = func(n *huffmanNode, depth int) {
+		if n.left == nil {
+			lengths[n.symbol] = depth
+			return
+		}
+
+		walk(n.left, depth+1)
+		walk(n.right, depth+1)
+	}
+
+	if h.Len() == 1 {
+		walk(h[0], 0)
+	}
+
+	return lengths
+}
+
+// bitWriter packs bits least significant first, as VP8L reads them.
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits int
+}
+
+func (w *bitWriter) write(v uint32, n int) {
+	w.acc |= uint64(v) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.nbits -= 8
+	}
+}
+
+// writeCode writes a prefix code, whose first bit is its most significant one.
+func (w *bitWriter) writeCode(c prefixCode) {
+	var reversed uint32
+	for i := 0; i < c.length; i++ {
+		reversed |= (c.bits >> i & 1) << (c.length - 1 - i)
+	}
+
+	w.write(reversed, c.length)
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.nbits > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.nbits = 0, 0
+	}
+
+	return w.buf
+}
@@ -2,12 +2,13 @@ package service
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
 	"errors"
+
+	"github.com/jackc/pgx/v4"
 )
 
 const (
@@ -23,23 +24,52 @@ type LoginOutput struct {
 	Token string
 	ExpiresAt time.Time
 	AuthUser User
+	// Suspension in effect, if any, as suspended users can only read and appeal
+	Suspension *Suspension `json:",omitempty"`
 }
 
 var (
 	// ErrUnauthenticated used when there is no authenticated user in context
 	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrInvalidToken used when a token cannot be decoded or its session was invalidated
+	ErrInvalidToken = errors.New("invalid token")
 )
 
-// AuthUserID from token
-func (s *Service) AuthUserID(token string) (int64, error) {
+// AuthUserID from token. Tokens hold the user id and the token version of the user
+// when issued, so bumping the version invalidates every session of the user.
+func (s *Service) AuthUserID(ctx context.Context, token string) (int64, error) {
 	str, err := s.Codec.DecodeToString(token)
 	if err != nil {
-		return 0, fmt.Errorf("could not decode token: %v", err)
+		return 0, ErrInvalidToken
+	}
+
+	// tokens issued before versioning hold the user id only
+	idStr, versionStr, _ := strings.Cut(str, ":")
+	i, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+
+	var version int64
+	if versionStr != "" {
+		if version, err = strconv.ParseInt(versionStr, 10, 64); err != nil {
+			return 0, ErrInvalidToken
+		}
+	}
+
+	var current int64
+	query := "SELECT token_version FROM users WHERE id = $1"
+	err = s.Db.QueryRow(ctx, query, i).Scan(&current)
+	if err == pgx.ErrNoRows {
+		return 0, ErrInvalidToken
 	}
 
-	i, err := strconv.ParseInt(str, 10, 64) 
 	if err != nil {
-		return 0, fmt.Errorf("could not parse auth user id from token: %v", err)
+		return 0, fmt.Errorf("could not query select user token version: %v", err)
+	}
+
+	if version != current {
+		return 0, ErrInvalidToken
 	}
 
 	return i, nil
@@ -55,10 +85,11 @@ func(s *Service) Login(ctx context.Context, email string, password string) (Logi
 	}
 
 	var hash string
-	query := "SELECT id, username, password FROM users WHERE email = $1"
-	err := s.Db.QueryRow(ctx, query, email).Scan(&out.AuthUser.ID, &out.AuthUser.Username, &hash)
+	var version int64
+	query := "SELECT id, username, password, token_version FROM users WHERE email = $1"
+	err := s.Db.QueryRow(ctx, query, email).Scan(&out.AuthUser.ID, &out.AuthUser.Username, &hash, &version)
 
-	if err == sql.ErrNoRows {
+	if err == pgx.ErrNoRows {
 		return out, ErrUserNotFound
 	}
 
@@ -70,13 +101,23 @@ func(s *Service) Login(ctx context.Context, email string, password string) (Logi
 		return out, ErrInvalidPassword	
 	}
 
-	out.Token, err = s.Codec.EncodeToString(strconv.FormatInt(out.AuthUser.ID, 10))
+	out.Token, err = s.Codec.EncodeToString(fmt.Sprintf("%d:%d", out.AuthUser.ID, version))
 	if err != nil {
 		return out, fmt.Errorf("could not create token: %v", err)
 	}
 
 	out.ExpiresAt = time.Now().Add(TokenLifespan)
 
+	ip, device := clientFromContext(ctx)
+	if _, err = s.Db.Exec(ctx, recordClientQuery, out.AuthUser.ID, ip, device); err != nil {
+		return out, fmt.Errorf("could not upsert user client: %v", err)
+	}
+
+	out.Suspension, err = activeSuspension(ctx, s.Db, out.AuthUser.ID)
+	if err != nil {
+		return out, err
+	}
+
 	return out, nil
 }
 
@@ -91,7 +132,7 @@ func (s *Service) AuthUser(ctx context.Context) (User, error) {
 
 	query := "SELECT username FROM users WHERE id = $1"
 	err := s.Db.QueryRow(ctx, query, uid).Scan(&u.Username)
-	if err == sql.ErrNoRows {
+	if err == pgx.ErrNoRows {
 		return u, ErrUserNotFound
 	}
 
@@ -0,0 +1,714 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	SortOld = "old"
+
+	defaultCommentDepth = 5
+	maxCommentDepth     = 10
+	// how many replies of each comment are loaded inline
+	repliesPerComment = 10
+	maxCommentLen     = 10000
+	// deepest level a reply can be posted at, 1 being top level comments
+	maxThreadDepth = 50
+)
+
+var (
+	ErrInvalidComment       = errors.New("invalid comment")
+	ErrCommentNotFound      = errors.New("comment not found")
+	ErrInvalidParentComment = errors.New("invalid parent comment")
+	ErrThreadTooDeep        = errors.New("comment thread too deep")
+)
+
+// commentColumns lists the columns read by Comment.scanDest, in order.
+// Queries select them from post_comments aliased as c, left joined with its author.
+const commentColumns = "c.id, c.post_id, c.parent_id, c.user_id, users.username, c.path::TEXT, c.content, " +
+	"c.replies_count, c.upvotes, c.downvotes, c.score, c.created_at, c.edited_at, c.deleted_at, c.deleted_by"
+
+const commentAuthorJoin = " LEFT JOIN users ON users.id = c.user_id"
+
+// visibleCommentCondition is an SQL condition true for the comments of table
+// the user in the uid placeholder can read: comments not held nor hidden
+// by the content filters nor by shadowbanned users, unless the user wrote them.
+func visibleCommentCondition(table, uid string) string {
+	return hiddenContentCondition(table, uid)
+}
+
+// hiddenContentCondition is an SQL condition true for the posts or comments of table
+// which are not visible to their author only, or whose author is the user in the uid placeholder.
+// Content is visible to its author only when caught by the filters or written by a shadowbanned user.
+func hiddenContentCondition(table, uid string) string {
+	return "(" + table + ".user_id = " + uid + " OR " + table + ".filter_action IS NULL AND NOT EXISTS (" +
+		"SELECT 1 FROM users authors WHERE authors.id = " + table + ".user_id AND authors.shadowbanned))"
+}
+
+// Comment model
+// Deleted comments stay in the tree so their replies survive,
+// with their content and author hidden.
+type Comment struct {
+	ID           int64  `json:"id"`
+	PostID       int64  `json:"post_id"`
+	ParentID     *int64 `json:"parent_id,omitempty"`
+	UserID       *int64 `json:"user_id,omitempty"`
+	User         *User  `json:"user,omitempty"`
+	Path         string `json:"path"`
+	Content      string `json:"content"`
+	RepliesCount int    `json:"replies_count"`
+	Upvotes      int64  `json:"upvotes"`
+	Downvotes    int64  `json:"downvotes"`
+	// Vote is the vote of the authenticated user, 0 if none
+	Vote      int        `json:"vote"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	Deleted   bool       `json:"deleted"`
+
+	username  sql.NullString
+	score     float64
+	deletedAt *time.Time
+	deletedBy *int64
+}
+
+func (c *Comment) scanDest() []interface{} {
+	return []interface{}{
+		&c.ID,
+		&c.PostID,
+		&c.ParentID,
+		&c.UserID,
+		&c.username,
+		&c.Path,
+		&c.Content,
+		&c.RepliesCount,
+		&c.Upvotes,
+		&c.Downvotes,
+		&c.score,
+		&c.CreatedAt,
+		&c.EditedAt,
+		&c.deletedAt,
+		&c.deletedBy,
+	}
+}
+
+// afterScan fills the fields derived from the scanned columns.
+func (c *Comment) afterScan() {
+	if c.deletedAt != nil {
+		c.Deleted = true
+		c.Content = "[deleted]"
+		// removed by someone else than the author, i.e. a moderator
+		if c.deletedBy == nil || c.UserID == nil || *c.deletedBy != *c.UserID {
+			c.Content = "[removed]"
+		}
+		c.UserID = nil
+		c.EditedAt = nil
+		return
+	}
+
+	if c.UserID != nil && c.username.Valid {
+		c.User = &User{ID: *c.UserID, Username: c.username.String}
+	}
+}
+
+// CommentNode is a comment along with the replies loaded under it.
+// MoreReplies is set when some replies were left out, either past
+// the depth limit or the width limit, and is the cursor to load them.
+type CommentNode struct {
+	Comment
+	Replies     []*CommentNode `json:"replies"`
+	MoreReplies string         `json:"more_replies,omitempty"`
+}
+
+type commentSort struct {
+	expr string
+	desc bool
+	// key and parseKey convert the sort value of a comment to and from a cursor key
+	key      func(c Comment) string
+	parseKey func(key string) (interface{}, error)
+}
+
+// order is the ORDER BY clause of the sort, with columns of table alias prefix
+func (cs commentSort) order(prefix string) string {
+	if cs.desc {
+		return prefix + cs.expr + " DESC, " + prefix + "id DESC"
+	}
+	return prefix + cs.expr + " ASC, " + prefix + "id ASC"
+}
+
+func (cs commentSort) after() string {
+	if cs.desc {
+		return "<"
+	}
+	return ">"
+}
+
+func commentTimeKey(c Comment) string {
+	return c.CreatedAt.UTC().Format(time.RFC3339Nano)
+}
+
+func parseCommentTimeKey(key string) (interface{}, error) {
+	return time.Parse(time.RFC3339Nano, key)
+}
+
+var commentSorts = map[string]commentSort{
+	SortNew: {expr: "created_at", desc: true, key: commentTimeKey, parseKey: parseCommentTimeKey},
+	SortOld: {expr: "created_at", key: commentTimeKey, parseKey: parseCommentTimeKey},
+	// score is the Wilson lower bound of the upvote ratio,
+	// so a few lucky votes do not outrank a well established comment
+	SortTop: {
+		expr: "score",
+		desc: true,
+		key:  func(c Comment) string { return strconv.FormatFloat(c.score, 'g', -1, 64) },
+		parseKey: func(key string) (interface{}, error) {
+			return strconv.ParseFloat(key, 64)
+		},
+	},
+}
+
+// encodeCommentCursor points after the reply id with sort key of a parent comment,
+// 0 being the post itself. An empty key points to the first reply.
+func encodeCommentCursor(sort string, parentID int64, key string, id int64) string {
+	return encodeCursor(sort+"|"+strconv.FormatInt(parentID, 10)+"|"+key, id)
+}
+
+func decodeCommentCursor(s string) (string, int64, string, int64, error) {
+	k, id, err := decodeCursor(s)
+	if err != nil {
+		return "", 0, "", 0, err
+	}
+
+	parts := strings.SplitN(k, "|", 3)
+	if len(parts) != 3 {
+		return "", 0, "", 0, ErrInvalidCursor
+	}
+
+	parentID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", 0, "", 0, ErrInvalidCursor
+	}
+
+	return parts[0], parentID, parts[2], id, nil
+}
+
+// Comments gets a page of the comment tree of a post.
+// Without cursor it starts at the top level comments; a next_cursor or
+// more_replies cursor continues the replies it was given for.
+// Each page loads replies up to depth levels, and at most repliesPerComment per comment.
+func (s *Service) Comments(
+	ctx context.Context,
+	postID int64,
+	sort string,
+	after string,
+	first int,
+	depth int,
+) (Page[*CommentNode], error) {
+	var page Page[*CommentNode]
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return page, ErrUnauthenticated
+	}
+
+	if sort == "" {
+		sort = SortNew
+	}
+
+	cs, ok := commentSorts[sort]
+	if !ok {
+		return page, ErrInvalidSort
+	}
+
+	if depth <= 0 {
+		depth = defaultCommentDepth
+	}
+
+	if depth > maxCommentDepth {
+		depth = maxCommentDepth
+	}
+
+	first = normalizePageSize(first)
+
+	if err := postVisible(ctx, s.Db, uid, postID); err != nil {
+		return page, err
+	}
+
+	var parentID, lastID int64
+	var key string
+	if after != "" {
+		var cursorSort string
+		var err error
+		cursorSort, parentID, key, lastID, err = decodeCommentCursor(after)
+		if err != nil {
+			return page, err
+		}
+
+		if cursorSort != sort {
+			return page, ErrInvalidCursor
+		}
+	}
+
+	query := "SELECT " + commentColumns + " FROM post_comments c" + commentAuthorJoin +
+		" WHERE c.post_id = $1 AND " + visibleCommentCondition("c", "$2")
+	args := []interface{}{postID, uid}
+
+	if parentID == 0 {
+		query += " AND c.parent_id IS NULL"
+	} else {
+		args = append(args, parentID)
+		query += " AND c.parent_id = $3"
+	}
+
+	if key != "" {
+		v, err := cs.parseKey(key)
+		if err != nil {
+			return page, ErrInvalidCursor
+		}
+
+		args = append(args, v, lastID)
+		query += fmt.Sprintf(" AND (c.%s, c.id) %s ($%d, $%d)", cs.expr, cs.after(), len(args)-1, len(args))
+	}
+
+	query += " ORDER BY " + cs.order("c.") + " LIMIT " + strconv.Itoa(first+1)
+
+	roots, err := s.queryCommentNodes(ctx, query, args...)
+	if err != nil {
+		return page, err
+	}
+
+	page = newPage(roots, first, func(n *CommentNode) string {
+		return encodeCommentCursor(sort, parentID, cs.key(n.Comment), n.ID)
+	})
+
+	if len(page.Items) != 0 && depth > 1 {
+		if err = s.loadReplies(ctx, uid, postID, cs, page.Items, depth); err != nil {
+			return page, err
+		}
+	}
+
+	all := flattenCommentNodes(page.Items)
+	if err = visibleRepliesCounts(ctx, s.Db, uid, all...); err != nil {
+		return page, err
+	}
+
+	var walk func(nn []*CommentNode)
+	walk = func(nn []*CommentNode) {
+		for _, n := range nn {
+			if len(n.Replies) < n.RepliesCount {
+				if len(n.Replies) == 0 {
+					n.MoreReplies = encodeCommentCursor(sort, n.ID, "", 0)
+				} else {
+					last := n.Replies[len(n.Replies)-1]
+					n.MoreReplies = encodeCommentCursor(sort, n.ID, cs.key(last.Comment), last.ID)
+				}
+			}
+
+			walk(n.Replies)
+		}
+	}
+
+	walk(page.Items)
+
+	if err = commentVotes(ctx, s.Db, uid, all...); err != nil {
+		return page, err
+	}
+
+	return page, nil
+}
+
+// loadReplies attaches to roots, all on the same level, their replies
+// down to depth levels with the first repliesPerComment of each comment.
+func (s *Service) loadReplies(ctx context.Context, uid, postID int64, cs commentSort, roots []*CommentNode, depth int) error {
+	paths := make([]string, len(roots))
+	for i, n := range roots {
+		paths[i] = n.Path
+	}
+
+	level := strings.Count(roots[0].Path, ".") + 1
+
+	query := "SELECT " + commentColumns + ` FROM (
+			SELECT *, nlevel(path) AS level, ROW_NUMBER() OVER (PARTITION BY parent_id ORDER BY ` + cs.order("") + `) AS rank
+			FROM post_comments
+			WHERE post_id = $1 AND path <@ ANY($2::TEXT[]::LTREE[]) AND nlevel(path) > $3 AND nlevel(path) < $3 + $4
+				AND ` + visibleCommentCondition("post_comments", "$6") + `
+		) AS c` + commentAuthorJoin + `
+		WHERE c.rank <= $5
+		ORDER BY c.level, c.rank`
+
+	replies, err := s.queryCommentNodes(ctx, query, postID, paths, level, depth, repliesPerComment, uid)
+	if err != nil {
+		return err
+	}
+
+	nodes := make(map[int64]*CommentNode, len(roots)+len(replies))
+	for _, n := range roots {
+		nodes[n.ID] = n
+	}
+
+	// replies come level by level, so parents are always seen first;
+	// a reply whose parent was cut by the width limit is dropped
+	for _, n := range replies {
+		if p, ok := nodes[*n.ParentID]; ok {
+			p.Replies = append(p.Replies, n)
+			nodes[n.ID] = n
+		}
+	}
+
+	return nil
+}
+
+// flattenCommentNodes lists the comments of nn and of all their loaded replies.
+func flattenCommentNodes(nn []*CommentNode) []*Comment {
+	var cc []*Comment
+	for _, n := range nn {
+		cc = append(cc, &n.Comment)
+		cc = append(cc, flattenCommentNodes(n.Replies)...)
+	}
+	return cc
+}
+
+// visibleRepliesCounts sets the replies count of each comment to the replies
+// the user uid can see. The stored count includes replies only their authors can see,
+// which would give away held content and shadowbanned users.
+func visibleRepliesCounts(ctx context.Context, db querier, uid int64, cc ...*Comment) error {
+	byID := map[int64]*Comment{}
+	var ids []int64
+	for _, c := range cc {
+		// the stored count is an upper bound
+		if c.RepliesCount != 0 {
+			byID[c.ID] = c
+			ids = append(ids, c.ID)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query := "SELECT r.parent_id, COUNT(*) FROM post_comments r WHERE r.parent_id = ANY($1) AND " +
+		visibleCommentCondition("r", "$2") + " GROUP BY r.parent_id"
+	rows, err := db.Query(ctx, query, ids, uid)
+	if err != nil {
+		return fmt.Errorf("could not sql query visible replies counts: %v", err)
+	}
+
+	defer rows.Close()
+
+	for _, c := range byID {
+		c.RepliesCount = 0
+	}
+
+	for rows.Next() {
+		var id int64
+		var count int
+		if err := rows.Scan(&id, &count); err != nil {
+			return fmt.Errorf("could not iterate over visible replies counts: %v", err)
+		}
+
+		byID[id].RepliesCount = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate over visible replies counts: %v", err)
+	}
+
+	return nil
+}
+
+func (s *Service) queryCommentNodes(ctx context.Context, query string, args ...interface{}) ([]*CommentNode, error) {
+	rows, err := s.Db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not sql query comments: %v", err)
+	}
+
+	defer rows.Close()
+
+	nn := []*CommentNode{}
+	for rows.Next() {
+		n := &CommentNode{Replies: []*CommentNode{}}
+		if err := rows.Scan(n.scanDest()...); err != nil {
+			return nil, fmt.Errorf("could not iterate over comments: %v", err)
+		}
+
+		n.afterScan()
+		nn = append(nn, n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over comments: %v", err)
+	}
+
+	return nn, nil
+}
+
+// comment gets a single comment by id
+func comment(ctx context.Context, db querier, commentID int64) (Comment, error) {
+	var c Comment
+
+	query := "SELECT " + commentColumns + " FROM post_comments c" + commentAuthorJoin + " WHERE c.id = $1"
+	err := db.QueryRow(ctx, query, commentID).Scan(c.scanDest()...)
+	if err == pgx.ErrNoRows {
+		return c, ErrCommentNotFound
+	}
+
+	if err != nil {
+		return c, fmt.Errorf("could not query select comment: %v", err)
+	}
+
+	c.afterScan()
+
+	return c, nil
+}
+
+// commentVotes sets the vote of the user uid on each comment of cc
+func commentVotes(ctx context.Context, db querier, uid int64, cc ...*Comment) error {
+	if len(cc) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*Comment, len(cc))
+	ids := make([]int64, len(cc))
+	for i, c := range cc {
+		byID[c.ID] = c
+		ids[i] = c.ID
+	}
+
+	query := "SELECT comment_id, value, counted FROM comment_votes WHERE user_id = $1 AND comment_id = ANY($2)"
+	rows, err := db.Query(ctx, query, uid, ids)
+	if err != nil {
+		return fmt.Errorf("could not sql query comment votes: %v", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var value int
+		var counted bool
+		if err := rows.Scan(&id, &value, &counted); err != nil {
+			return fmt.Errorf("could not iterate over comment votes: %v", err)
+		}
+
+		byID[id].Vote = value
+		// the user sees their own vote count, even when it does not count for others
+		if !counted {
+			up, down := voteDeltas(0, value)
+			byID[id].Upvotes += int64(up)
+			byID[id].Downvotes += int64(down)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate over comment votes: %v", err)
+	}
+
+	return nil
+}
+
+// saveCommentEntities stores the tags and mentions of a comment,
+// and returns the ids of the newly mentioned users.
+func saveCommentEntities(ctx context.Context, tx pgx.Tx, c Comment) ([]int64, error) {
+	if err := saveTags(ctx, tx, "comment_tags", "comment_id", c.ID, extractTags(c.Content)); err != nil {
+		return nil, err
+	}
+
+	return saveMentions(ctx, tx, "comment_mentions", "comment_id", c.ID, extractMentions(c.Content))
+}
+
+// authorizeCommentChange locks a comment for update inside tx and checks
+// the user uid is its author, a site moderator or a moderator of the community
+// of its post, reporting whether uid is moderating someone else's comment.
+// Deleted comments cannot change.
+func (s *Service) authorizeCommentChange(ctx context.Context, tx pgx.Tx, uid, commentID int64) (bool, error) {
+	var authorID, communityID *int64
+	var deleted bool
+	query := `SELECT post_comments.user_id, post_comments.deleted_at IS NOT NULL, posts.community_id
+		FROM post_comments INNER JOIN posts ON posts.id = post_comments.post_id
+		WHERE post_comments.id = $1 FOR UPDATE OF post_comments`
+	err := tx.QueryRow(ctx, query, commentID).Scan(&authorID, &deleted, &communityID)
+	if err == pgx.ErrNoRows || err == nil && deleted {
+		return false, ErrCommentNotFound
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("could not query select comment author: %v", err)
+	}
+
+	if authorID != nil && *authorID == uid {
+		return false, nil
+	}
+
+	var moderator bool
+	if communityID != nil {
+		moderator, err = canModerateCommunity(ctx, tx, *communityID, uid)
+	} else {
+		var role string
+		role, err = userRole(ctx, tx, uid)
+		moderator = canModerate(role)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if !moderator {
+		return false, ErrForbidden
+	}
+
+	return true, nil
+}
+
+// UpdateComment changes the content of a comment, by its author or a moderator.
+func (s *Service) UpdateComment(ctx context.Context, commentID int64, content string) (Comment, error) {
+	var c Comment
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return c, ErrUnauthenticated
+	}
+
+	content = strings.TrimSpace(content)
+	if content == "" || len([]rune(content)) > maxCommentLen {
+		return c, ErrInvalidComment
+	}
+
+	fr, err := s.checkContent(ctx, uid, TargetComment, content)
+	if err != nil {
+		return c, err
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return c, fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	moderating, err := s.authorizeCommentChange(ctx, tx, uid, commentID)
+	if err != nil {
+		return c, err
+	}
+
+	var before map[string]interface{}
+	if moderating {
+		if before, _, err = contentSnapshot(ctx, tx, TargetComment, commentID); err != nil {
+			return c, err
+		}
+	}
+
+	var hidden bool
+	query := "UPDATE post_comments SET content = $2, edited_at = NOW() WHERE id = $1 RETURNING filter_action IS NOT NULL"
+	if err = tx.QueryRow(ctx, query, commentID, content).Scan(&hidden); err != nil {
+		return c, fmt.Errorf("could not update comment: %v", err)
+	}
+
+	if err = applyContentFilters(ctx, tx, uid, fr, TargetComment, commentID); err != nil {
+		return c, err
+	}
+
+	if moderating {
+		if err = logContentAction(ctx, tx, uid, ModActionEditComment, TargetComment, commentID, "", before); err != nil {
+			return c, err
+		}
+	}
+
+	if c, err = comment(ctx, tx, commentID); err != nil {
+		return c, err
+	}
+
+	if err = commentVotes(ctx, tx, uid, &c); err != nil {
+		return c, err
+	}
+
+	mentioned, err := saveCommentEntities(ctx, tx, c)
+	if err != nil {
+		return c, err
+	}
+
+	// mentions are attributed to the comment author even when a moderator edits,
+	// and nobody is notified of content only its author can see
+	var nn []*Notification
+	if c.UserID != nil && !hidden && !fr.hides() {
+		if nn, err = notifyMentions(ctx, tx, *c.UserID, mentioned, c.PostID, &c.ID); err != nil {
+			return c, err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return c, fmt.Errorf("could not commit comment update: %v", err)
+	}
+
+	s.broadcastNotifications(nn...)
+
+	return c, nil
+}
+
+// DeleteComment soft deletes a comment, by its author or a moderator.
+// The row stays as a placeholder so replies keep their place in the tree.
+func (s *Service) DeleteComment(ctx context.Context, commentID int64) error {
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return ErrUnauthenticated
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	moderating, err := s.authorizeCommentChange(ctx, tx, uid, commentID)
+	if err != nil {
+		return err
+	}
+
+	var before map[string]interface{}
+	if moderating {
+		if before, _, err = contentSnapshot(ctx, tx, TargetComment, commentID); err != nil {
+			return err
+		}
+	}
+
+	if err = removeComment(ctx, tx, commentID, uid); err != nil {
+		return err
+	}
+
+	if moderating {
+		if err = logContentAction(ctx, tx, uid, ModActionRemoveComment, TargetComment, commentID, "", before); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("could not commit comment deletion: %v", err)
+	}
+
+	return nil
+}
+
+// removeComment soft deletes a comment on behalf of the user by,
+// either its author or a moderator.
+func removeComment(ctx context.Context, tx pgx.Tx, commentID, by int64) error {
+	query := "UPDATE post_comments SET content = '', deleted_at = NOW(), deleted_by = $2 WHERE id = $1"
+	if _, err := tx.Exec(ctx, query, commentID, by); err != nil {
+		return fmt.Errorf("could not delete comment: %v", err)
+	}
+
+	if err := saveTags(ctx, tx, "comment_tags", "comment_id", commentID, nil); err != nil {
+		return err
+	}
+
+	if _, err := saveMentions(ctx, tx, "comment_mentions", "comment_id", commentID, nil); err != nil {
+		return err
+	}
+
+	return nil
+}
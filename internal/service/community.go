@@ -0,0 +1,954 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	CommunityMember    = "member"
+	CommunityModerator = "moderator"
+
+	// anyone reads and posts
+	CommunityPublic = "public"
+	// anyone reads, only members post
+	CommunityRestricted = "restricted"
+	// only members read and post
+	CommunityPrivate = "private"
+
+	// JoinCommunity outcomes
+	JoinJoined    = "joined"
+	JoinRequested = "requested"
+
+	maxCommunityDescriptionLen = 1000
+	maxCommunityRules          = 15
+	maxCommunityRuleLen        = 500
+)
+
+var (
+	rxCommunityName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]{2,20}$`)
+
+	ErrInvalidCommunityName        = errors.New("invalid community name")
+	ErrInvalidCommunityDescription = errors.New("invalid community description")
+	ErrInvalidCommunityRules       = errors.New("invalid community rules")
+	ErrInvalidCommunityVisibility  = errors.New("invalid community visibility")
+	ErrCommunityNameTaken          = errors.New("community name taken")
+	ErrCommunityNotFound           = errors.New("community not found")
+	ErrNotCommunityMember          = errors.New("not a member of the community")
+	ErrLastCommunityModerator      = errors.New("community must keep a moderator")
+	ErrJoinRequestNotFound         = errors.New("join request not found")
+)
+
+var communityVisibilities = map[string]bool{
+	CommunityPublic:     true,
+	CommunityRestricted: true,
+	CommunityPrivate:    true,
+}
+
+// Community model
+// Member, Moderator and JoinRequested are about the authenticated user.
+type Community struct {
+	ID            int64     `json:"id"`
+	Name          string    `json:"name"`
+	Description   string    `json:"description"`
+	Rules         []string  `json:"rules"`
+	Visibility    string    `json:"visibility"`
+	CreatorID     *int64    `json:"creator_id,omitempty"`
+	MembersCount  int64     `json:"members_count"`
+	CreatedAt     time.Time `json:"created_at"`
+	Member        bool      `json:"member"`
+	Moderator     bool      `json:"moderator"`
+	JoinRequested bool      `json:"join_requested"`
+}
+
+// CommunityUpdate lists the settings to change. Nil fields are left as is.
+type CommunityUpdate struct {
+	Description *string
+	Rules       *[]string
+	Visibility  *string
+}
+
+// communityColumns lists the communities columns read by Community.scanDest, in order,
+// along with the membership of the user in the first query argument.
+const communityColumns = `communities.id, communities.name, communities.description, communities.rules,
+	communities.visibility, communities.creator_id, communities.members_count, communities.created_at,
+	community_members.role IS NOT NULL, COALESCE(community_members.role = 'moderator', FALSE),
+	EXISTS (SELECT 1 FROM community_join_requests
+		WHERE community_join_requests.community_id = communities.id AND community_join_requests.user_id = $1)`
+
+const communityMemberJoin = ` LEFT JOIN community_members
+	ON community_members.community_id = communities.id AND community_members.user_id = $1`
+
+func (c *Community) scanDest() []interface{} {
+	return []interface{}{
+		&c.ID,
+		&c.Name,
+		&c.Description,
+		&c.Rules,
+		&c.Visibility,
+		&c.CreatorID,
+		&c.MembersCount,
+		&c.CreatedAt,
+		&c.Member,
+		&c.Moderator,
+		&c.JoinRequested,
+	}
+}
+
+func validateCommunityDescription(description string) (string, error) {
+	description = strings.TrimSpace(description)
+	if len([]rune(description)) > maxCommunityDescriptionLen {
+		return "", ErrInvalidCommunityDescription
+	}
+
+	return description, nil
+}
+
+func validateCommunityRules(rules []string) ([]string, error) {
+	if len(rules) > maxCommunityRules {
+		return nil, ErrInvalidCommunityRules
+	}
+
+	clean := make([]string, 0, len(rules))
+	for _, r := range rules {
+		r = strings.TrimSpace(r)
+		if r == "" || len([]rune(r)) > maxCommunityRuleLen {
+			return nil, ErrInvalidCommunityRules
+		}
+
+		clean = append(clean, r)
+	}
+
+	return clean, nil
+}
+
+// CreateCommunity creates a community moderated by the authenticated user.
+// The visibility defaults to public.
+func (s *Service) CreateCommunity(
+	ctx context.Context,
+	name string,
+	description string,
+	rules []string,
+	visibility string,
+) (Community, error) {
+	var c Community
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return c, ErrUnauthenticated
+	}
+
+	name = strings.TrimSpace(name)
+	if !rxCommunityName.MatchString(name) {
+		return c, ErrInvalidCommunityName
+	}
+
+	description, err := validateCommunityDescription(description)
+	if err != nil {
+		return c, err
+	}
+
+	cleanRules, err := validateCommunityRules(rules)
+	if err != nil {
+		return c, err
+	}
+
+	if visibility == "" {
+		visibility = CommunityPublic
+	}
+
+	if !communityVisibilities[visibility] {
+		return c, ErrInvalidCommunityVisibility
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return c, fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	query := `INSERT INTO communities (name, description, rules, visibility, creator_id, members_count)
+		VALUES ($1, $2, $3, $4, $5, 1) RETURNING id, created_at`
+	err = tx.QueryRow(ctx, query, name, description, cleanRules, visibility, uid).Scan(&c.ID, &c.CreatedAt)
+	if isUniqueViolation(err) {
+		return c, ErrCommunityNameTaken
+	}
+
+	if err != nil {
+		return c, fmt.Errorf("could not insert community: %v", err)
+	}
+
+	query = "INSERT INTO community_members (community_id, user_id, role) VALUES ($1, $2, $3)"
+	if _, err = tx.Exec(ctx, query, c.ID, uid, CommunityModerator); err != nil {
+		return c, fmt.Errorf("could not insert community creator membership: %v", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return c, fmt.Errorf("could not commit community: %v", err)
+	}
+
+	c.Name = name
+	c.Description = description
+	c.Rules = cleanRules
+	c.Visibility = visibility
+	c.CreatorID = &uid
+	c.MembersCount = 1
+	c.Member = true
+	c.Moderator = true
+
+	return c, nil
+}
+
+// Community gets a community by name
+func (s *Service) Community(ctx context.Context, name string) (Community, error) {
+	var c Community
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return c, ErrUnauthenticated
+	}
+
+	name = strings.TrimSpace(name)
+	if !rxCommunityName.MatchString(name) {
+		return c, ErrInvalidCommunityName
+	}
+
+	query := "SELECT " + communityColumns + " FROM communities" + communityMemberJoin + " WHERE lower(communities.name) = lower($2)"
+	err := s.Db.QueryRow(ctx, query, uid, name).Scan(c.scanDest()...)
+	if err == pgx.ErrNoRows {
+		return c, ErrCommunityNotFound
+	}
+
+	if err != nil {
+		return c, fmt.Errorf("could not query select community: %v", err)
+	}
+
+	return c, nil
+}
+
+// Communities gets a page of communities, the biggest first
+func (s *Service) Communities(ctx context.Context, after string, first int) (Page[Community], error) {
+	var page Page[Community]
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return page, ErrUnauthenticated
+	}
+
+	first = normalizePageSize(first)
+
+	query := "SELECT " + communityColumns + " FROM communities" + communityMemberJoin
+	args := []interface{}{uid}
+
+	if after != "" {
+		key, id, err := decodeCursor(after)
+		if err != nil {
+			return page, err
+		}
+
+		count, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return page, ErrInvalidCursor
+		}
+
+		query += " WHERE (communities.members_count, communities.id) < ($2, $3)"
+		args = append(args, count, id)
+	}
+
+	query += " ORDER BY communities.members_count DESC, communities.id DESC LIMIT " + strconv.Itoa(first+1)
+
+	rows, err := s.Db.Query(ctx, query, args...)
+	if err != nil {
+		return page, fmt.Errorf("could not sql query communities: %v", err)
+	}
+
+	defer rows.Close()
+
+	cc := []Community{}
+	for rows.Next() {
+		var c Community
+		if err := rows.Scan(c.scanDest()...); err != nil {
+			return page, fmt.Errorf("could not iterate over communities: %v", err)
+		}
+
+		cc = append(cc, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return page, fmt.Errorf("could not iterate over communities: %v", err)
+	}
+
+	page = newPage(cc, first, func(c Community) string {
+		return encodeCursor(strconv.FormatInt(c.MembersCount, 10), c.ID)
+	})
+
+	return page, nil
+}
+
+// communityID resolves a community name. Inside a transaction, lock is
+// "FOR UPDATE" or "FOR SHARE" to lock the community row, empty otherwise.
+// Membership changes lock for update so moderator checks cannot race.
+func communityID(ctx context.Context, db querier, name string, lock string) (int64, error) {
+	name = strings.TrimSpace(name)
+	if !rxCommunityName.MatchString(name) {
+		return 0, ErrInvalidCommunityName
+	}
+
+	var id int64
+	query := "SELECT id FROM communities WHERE lower(name) = lower($1) " + lock
+	err := db.QueryRow(ctx, query, name).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return 0, ErrCommunityNotFound
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("could not query select community id: %v", err)
+	}
+
+	return id, nil
+}
+
+// communityRole gets the role of a user in a community, empty if not a member
+func communityRole(ctx context.Context, db querier, communityID, uid int64) (string, error) {
+	var role string
+	query := "SELECT role FROM community_members WHERE community_id = $1 AND user_id = $2"
+	err := db.QueryRow(ctx, query, communityID, uid).Scan(&role)
+	if err != nil && err != pgx.ErrNoRows {
+		return "", fmt.Errorf("could not query select community role: %v", err)
+	}
+
+	return role, nil
+}
+
+// canModerateCommunity reports whether the user is a moderator of the community,
+// or a site wide moderator.
+func canModerateCommunity(ctx context.Context, db querier, communityID, uid int64) (bool, error) {
+	role, err := communityRole(ctx, db, communityID, uid)
+	if err != nil {
+		return false, err
+	}
+
+	if role == CommunityModerator {
+		return true, nil
+	}
+
+	siteRole, err := userRole(ctx, db, uid)
+	if err != nil {
+		return false, err
+	}
+
+	return canModerate(siteRole), nil
+}
+
+// communityNames maps community ids to their names
+func communityNames(ctx context.Context, db querier, ids []int64) (map[int64]string, error) {
+	names := map[int64]string{}
+	if len(ids) == 0 {
+		return names, nil
+	}
+
+	query := "SELECT id, name FROM communities WHERE id = ANY($1)"
+	rows, err := db.Query(ctx, query, ids)
+	if err != nil {
+		return nil, fmt.Errorf("could not sql query community names: %v", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, fmt.Errorf("could not iterate over community names: %v", err)
+		}
+
+		names[id] = name
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over community names: %v", err)
+	}
+
+	return names, nil
+}
+
+// JoinCommunity makes the authenticated user a member of a public community,
+// or requests to join a restricted or private one. Joining twice is a no-op.
+func (s *Service) JoinCommunity(ctx context.Context, name string) (string, error) {
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return "", ErrUnauthenticated
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	id, err := communityID(ctx, tx, name, "FOR UPDATE")
+	if err != nil {
+		return "", err
+	}
+
+	role, err := communityRole(ctx, tx, id, uid)
+	if err != nil {
+		return "", err
+	}
+
+	if role != "" {
+		return JoinJoined, nil
+	}
+
+	var visibility string
+	query := "SELECT visibility FROM communities WHERE id = $1"
+	if err = tx.QueryRow(ctx, query, id).Scan(&visibility); err != nil {
+		return "", fmt.Errorf("could not query select community visibility: %v", err)
+	}
+
+	status := JoinJoined
+	if visibility == CommunityPublic {
+		err = addCommunityMember(ctx, tx, id, uid)
+	} else {
+		status = JoinRequested
+		query = "INSERT INTO community_join_requests (community_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING"
+		if _, err = tx.Exec(ctx, query, id, uid); err != nil {
+			err = fmt.Errorf("could not insert community join request: %v", err)
+		}
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("could not commit community join: %v", err)
+	}
+
+	return status, nil
+}
+
+// addCommunityMember adds a regular member to a community,
+// clearing any pending join request of theirs.
+func addCommunityMember(ctx context.Context, tx pgx.Tx, communityID, uid int64) error {
+	query := "INSERT INTO community_members (community_id, user_id, role) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING"
+	tag, err := tx.Exec(ctx, query, communityID, uid, CommunityMember)
+	if err != nil {
+		return fmt.Errorf("could not insert community membership: %v", err)
+	}
+
+	if tag.RowsAffected() != 0 {
+		query = "UPDATE communities SET members_count = members_count + 1 WHERE id = $1"
+		if _, err = tx.Exec(ctx, query, communityID); err != nil {
+			return fmt.Errorf("could not update community members count: %v", err)
+		}
+	}
+
+	query = "DELETE FROM community_join_requests WHERE community_id = $1 AND user_id = $2"
+	if _, err = tx.Exec(ctx, query, communityID, uid); err != nil {
+		return fmt.Errorf("could not delete community join request: %v", err)
+	}
+
+	return nil
+}
+
+// LeaveCommunity removes the authenticated user from the community members.
+// The last moderator of a community cannot leave it.
+func (s *Service) LeaveCommunity(ctx context.Context, name string) error {
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return ErrUnauthenticated
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	id, err := communityID(ctx, tx, name, "FOR UPDATE")
+	if err != nil {
+		return err
+	}
+
+	role, err := communityRole(ctx, tx, id, uid)
+	if err != nil {
+		return err
+	}
+
+	if role == "" {
+		return ErrNotCommunityMember
+	}
+
+	if role == CommunityModerator {
+		if err = ensureOtherModerator(ctx, tx, id, uid); err != nil {
+			return err
+		}
+	}
+
+	query := "DELETE FROM community_members WHERE community_id = $1 AND user_id = $2"
+	if _, err = tx.Exec(ctx, query, id, uid); err != nil {
+		return fmt.Errorf("could not delete community membership: %v", err)
+	}
+
+	query = "UPDATE communities SET members_count = members_count - 1 WHERE id = $1"
+	if _, err = tx.Exec(ctx, query, id); err != nil {
+		return fmt.Errorf("could not update community members count: %v", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("could not commit community leave: %v", err)
+	}
+
+	return nil
+}
+
+func ensureOtherModerator(ctx context.Context, tx pgx.Tx, communityID, uid int64) error {
+	var others bool
+	query := `SELECT EXISTS (SELECT 1 FROM community_members
+		WHERE community_id = $1 AND user_id <> $2 AND role = 'moderator')`
+	if err := tx.QueryRow(ctx, query, communityID, uid).Scan(&others); err != nil {
+		return fmt.Errorf("could not query select other community moderators: %v", err)
+	}
+
+	if !others {
+		return ErrLastCommunityModerator
+	}
+
+	return nil
+}
+
+// SetCommunityModerator makes a member of the community one of its moderators,
+// or a regular member again. Only moderators can change moderators.
+func (s *Service) SetCommunityModerator(ctx context.Context, name, username string, moderator bool) error {
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return ErrUnauthenticated
+	}
+
+	username = strings.TrimSpace(username)
+	if !rxUsername.MatchString(username) {
+		return ErrInvalidUsername
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	id, err := communityID(ctx, tx, name, "FOR UPDATE")
+	if err != nil {
+		return err
+	}
+
+	ok, err := canModerateCommunity(ctx, tx, id, uid)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrForbidden
+	}
+
+	var memberID int64
+	query := "SELECT id FROM users WHERE username = $1"
+	err = tx.QueryRow(ctx, query, username).Scan(&memberID)
+	if err == pgx.ErrNoRows {
+		return ErrUserNotFound
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not query select user id from username: %v", err)
+	}
+
+	role, err := communityRole(ctx, tx, id, memberID)
+	if err != nil {
+		return err
+	}
+
+	if role == "" {
+		return ErrNotCommunityMember
+	}
+
+	newRole := CommunityMember
+	if moderator {
+		newRole = CommunityModerator
+	} else if role == CommunityModerator {
+		if err = ensureOtherModerator(ctx, tx, id, memberID); err != nil {
+			return err
+		}
+	}
+
+	query = "UPDATE community_members SET role = $3 WHERE community_id = $1 AND user_id = $2"
+	if _, err = tx.Exec(ctx, query, id, memberID, newRole); err != nil {
+		return fmt.Errorf("could not update community member role: %v", err)
+	}
+
+	if role != newRole {
+		err = logModAction(ctx, tx, modAction{
+			actorID:     uid,
+			action:      ModActionSetCommunityRole,
+			targetType:  TargetUser,
+			targetID:    memberID,
+			communityID: &id,
+			before:      map[string]string{"role": role},
+			after:       map[string]string{"role": newRole},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("could not commit community moderator change: %v", err)
+	}
+
+	return nil
+}
+
+// UpdateCommunity changes the settings of a community. Only moderators can.
+func (s *Service) UpdateCommunity(ctx context.Context, name string, in CommunityUpdate) (Community, error) {
+	var c Community
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return c, ErrUnauthenticated
+	}
+
+	var sets []string
+	var args []interface{}
+	set := func(column string, v interface{}) {
+		args = append(args, v)
+		sets = append(sets, column+" = $"+strconv.Itoa(len(args)+1))
+	}
+
+	if in.Description != nil {
+		description, err := validateCommunityDescription(*in.Description)
+		if err != nil {
+			return c, err
+		}
+
+		set("description", description)
+	}
+
+	if in.Rules != nil {
+		rules, err := validateCommunityRules(*in.Rules)
+		if err != nil {
+			return c, err
+		}
+
+		set("rules", rules)
+	}
+
+	if in.Visibility != nil {
+		if !communityVisibilities[*in.Visibility] {
+			return c, ErrInvalidCommunityVisibility
+		}
+
+		set("visibility", *in.Visibility)
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return c, fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	id, err := communityID(ctx, tx, name, "FOR UPDATE")
+	if err != nil {
+		return c, err
+	}
+
+	ok, err := canModerateCommunity(ctx, tx, id, uid)
+	if err != nil {
+		return c, err
+	}
+
+	if !ok {
+		return c, ErrForbidden
+	}
+
+	var before Community
+	query := "SELECT " + communityColumns + " FROM communities" + communityMemberJoin + " WHERE communities.id = $2"
+	if err = tx.QueryRow(ctx, query, uid, id).Scan(before.scanDest()...); err != nil {
+		return c, fmt.Errorf("could not query select community: %v", err)
+	}
+
+	if len(sets) == 0 {
+		return before, nil
+	}
+
+	query = "UPDATE communities SET " + strings.Join(sets, ", ") + " WHERE id = $1"
+	if _, err = tx.Exec(ctx, query, append([]interface{}{id}, args...)...); err != nil {
+		return c, fmt.Errorf("could not update community: %v", err)
+	}
+
+	query = "SELECT " + communityColumns + " FROM communities" + communityMemberJoin + " WHERE communities.id = $2"
+	if err = tx.QueryRow(ctx, query, uid, id).Scan(c.scanDest()...); err != nil {
+		return c, fmt.Errorf("could not query select updated community: %v", err)
+	}
+
+	err = logModAction(ctx, tx, modAction{
+		actorID:     uid,
+		action:      ModActionUpdateCommunity,
+		targetType:  TargetCommunity,
+		targetID:    id,
+		communityID: &id,
+		before:      communitySettings(before),
+		after:       communitySettings(c),
+	})
+	if err != nil {
+		return c, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return c, fmt.Errorf("could not commit community update: %v", err)
+	}
+
+	return c, nil
+}
+
+// communitySettings is the part of a community its moderators change, for the moderation log
+func communitySettings(c Community) map[string]interface{} {
+	return map[string]interface{}{
+		"description": c.Description,
+		"rules":       c.Rules,
+		"visibility":  c.Visibility,
+	}
+}
+
+// CommunityJoinRequest is a user waiting to be approved as member
+type CommunityJoinRequest struct {
+	User      User      `json:"user"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CommunityJoinRequests gets a page of the pending join requests of a community,
+// oldest first. Only moderators can see them.
+func (s *Service) CommunityJoinRequests(
+	ctx context.Context,
+	name string,
+	after string,
+	first int,
+) (Page[CommunityJoinRequest], error) {
+	var page Page[CommunityJoinRequest]
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return page, ErrUnauthenticated
+	}
+
+	id, err := communityID(ctx, s.Db, name, "")
+	if err != nil {
+		return page, err
+	}
+
+	ok, err := canModerateCommunity(ctx, s.Db, id, uid)
+	if err != nil {
+		return page, err
+	}
+
+	if !ok {
+		return page, ErrForbidden
+	}
+
+	first = normalizePageSize(first)
+
+	query := `SELECT users.id, users.username, community_join_requests.created_at
+		FROM community_join_requests
+		INNER JOIN users ON users.id = community_join_requests.user_id
+		WHERE community_join_requests.community_id = $1`
+	args := []interface{}{id}
+
+	if after != "" {
+		createdAt, userID, err := decodeTimeCursor(after)
+		if err != nil {
+			return page, err
+		}
+
+		query += " AND (community_join_requests.created_at, users.id) > ($2, $3)"
+		args = append(args, createdAt, userID)
+	}
+
+	query += " ORDER BY community_join_requests.created_at, users.id LIMIT " + strconv.Itoa(first+1)
+
+	rows, err := s.Db.Query(ctx, query, args...)
+	if err != nil {
+		return page, fmt.Errorf("could not sql query community join requests: %v", err)
+	}
+
+	defer rows.Close()
+
+	rr := []CommunityJoinRequest{}
+	for rows.Next() {
+		var r CommunityJoinRequest
+		if err := rows.Scan(&r.User.ID, &r.User.Username, &r.CreatedAt); err != nil {
+			return page, fmt.Errorf("could not iterate over community join requests: %v", err)
+		}
+
+		rr = append(rr, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return page, fmt.Errorf("could not iterate over community join requests: %v", err)
+	}
+
+	page = newPage(rr, first, func(r CommunityJoinRequest) string {
+		return encodeTimeCursor(r.CreatedAt, r.User.ID)
+	})
+
+	return page, nil
+}
+
+// ResolveCommunityJoinRequest approves or rejects the pending join request
+// of the user with the given username. Only moderators can.
+func (s *Service) ResolveCommunityJoinRequest(ctx context.Context, name, username string, approve bool) error {
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return ErrUnauthenticated
+	}
+
+	username = strings.TrimSpace(username)
+	if !rxUsername.MatchString(username) {
+		return ErrInvalidUsername
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	id, err := communityID(ctx, tx, name, "FOR UPDATE")
+	if err != nil {
+		return err
+	}
+
+	ok, err := canModerateCommunity(ctx, tx, id, uid)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrForbidden
+	}
+
+	var requesterID int64
+	query := `DELETE FROM community_join_requests
+		WHERE community_id = $1 AND user_id = (SELECT id FROM users WHERE username = $2)
+		RETURNING user_id`
+	err = tx.QueryRow(ctx, query, id, username).Scan(&requesterID)
+	if err == pgx.ErrNoRows {
+		return ErrJoinRequestNotFound
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not delete community join request: %v", err)
+	}
+
+	if approve {
+		if err = addCommunityMember(ctx, tx, id, requesterID); err != nil {
+			return err
+		}
+	}
+
+	err = logModAction(ctx, tx, modAction{
+		actorID:     uid,
+		action:      ModActionResolveJoinRequest,
+		targetType:  TargetUser,
+		targetID:    requesterID,
+		communityID: &id,
+		after:       map[string]bool{"approved": approve},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("could not commit community join request resolution: %v", err)
+	}
+
+	return nil
+}
+
+// visiblePostCondition is an SQL condition true for the posts of table
+// the user in the uid placeholder can read: posts not removed, not held nor hidden
+// by the content filters nor by shadowbanned users unless the user wrote them,
+// and outside of communities, in non private communities, or in private communities
+// the user is a member of.
+func visiblePostCondition(table, uid string) string {
+	return "(" + table + ".deleted_at IS NULL AND " + hiddenContentCondition(table, uid) + " AND " +
+		"(" + table + ".community_id IS NULL OR EXISTS (" +
+		"SELECT 1 FROM communities WHERE communities.id = " + table + ".community_id AND (" +
+		"communities.visibility <> 'private' OR EXISTS (" +
+		"SELECT 1 FROM community_members WHERE community_members.community_id = communities.id " +
+		"AND community_members.user_id = " + uid + ")))))"
+}
+
+// postVisible checks the post exists and the user uid can read it.
+func postVisible(ctx context.Context, db querier, uid, postID int64) error {
+	var ok bool
+	query := "SELECT EXISTS (SELECT 1 FROM posts WHERE posts.id = $1 AND " + visiblePostCondition("posts", "$2") + ")"
+	if err := db.QueryRow(ctx, query, postID, uid).Scan(&ok); err != nil {
+		return fmt.Errorf("could not query select post visibility: %v", err)
+	}
+
+	if !ok {
+		return ErrInvalidPostID
+	}
+
+	return nil
+}
+
+// canParticipateInPost checks the user uid can see the post and take part in it,
+// by commenting or voting: posts of a community require the rights to post there.
+func canParticipateInPost(ctx context.Context, db querier, uid, postID int64) error {
+	if err := postVisible(ctx, db, uid, postID); err != nil {
+		return err
+	}
+
+	var communityID *int64
+	query := "SELECT community_id FROM posts WHERE id = $1"
+	if err := db.QueryRow(ctx, query, postID).Scan(&communityID); err != nil {
+		return fmt.Errorf("could not query select post community: %v", err)
+	}
+
+	if communityID == nil {
+		return nil
+	}
+
+	return canPostInCommunity(ctx, db, *communityID, uid)
+}
+
+// canPostInCommunity checks the user uid can post in the community:
+// anyone in public ones, only members in the others.
+func canPostInCommunity(ctx context.Context, db querier, communityID, uid int64) error {
+	var visibility string
+	var member bool
+	query := `SELECT visibility, EXISTS (
+			SELECT 1 FROM community_members WHERE community_id = communities.id AND user_id = $2
+		) FROM communities WHERE id = $1`
+	if err := db.QueryRow(ctx, query, communityID, uid).Scan(&visibility, &member); err != nil {
+		return fmt.Errorf("could not query select community posting rights: %v", err)
+	}
+
+	if visibility != CommunityPublic && !member {
+		return ErrNotCommunityMember
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	ErrInvalidInvite  = errors.New("invalid invite")
+	ErrInviteNotFound = errors.New("invite not found")
+	// ErrInviteExpired used when an invite is past its expiry or its maximum uses
+	ErrInviteExpired = errors.New("invite expired")
+)
+
+// CommunityInvite is a link to join a community without approval.
+// Nil ExpiresAt or MaxUses mean no limit.
+type CommunityInvite struct {
+	Code      string     `json:"code"`
+	Community string     `json:"community"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MaxUses   *int       `json:"max_uses,omitempty"`
+	Uses      int        `json:"uses"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func newInviteCode() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate invite code: %v", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreateCommunityInvite creates an invite to a community. Only moderators can.
+func (s *Service) CreateCommunityInvite(
+	ctx context.Context,
+	name string,
+	expiresAt *time.Time,
+	maxUses *int,
+) (CommunityInvite, error) {
+	var inv CommunityInvite
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return inv, ErrUnauthenticated
+	}
+
+	if expiresAt != nil && !expiresAt.After(time.Now()) || maxUses != nil && *maxUses <= 0 {
+		return inv, ErrInvalidInvite
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return inv, fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	id, err := communityID(ctx, tx, name, "")
+	if err != nil {
+		return inv, err
+	}
+
+	ok, err := canModerateCommunity(ctx, tx, id, uid)
+	if err != nil {
+		return inv, err
+	}
+
+	if !ok {
+		return inv, ErrForbidden
+	}
+
+	if inv.Code, err = newInviteCode(); err != nil {
+		return inv, err
+	}
+
+	query := `INSERT INTO community_invites (code, community_id, creator_id, expires_at, max_uses)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING (SELECT name FROM communities WHERE id = $2), created_at`
+	err = tx.QueryRow(ctx, query, inv.Code, id, uid, expiresAt, maxUses).Scan(&inv.Community, &inv.CreatedAt)
+	if err != nil {
+		return inv, fmt.Errorf("could not insert community invite: %v", err)
+	}
+
+	// the code itself grants membership, so it stays out of the log
+	err = logModAction(ctx, tx, modAction{
+		actorID:     uid,
+		action:      ModActionCreateInvite,
+		targetType:  TargetCommunity,
+		targetID:    id,
+		communityID: &id,
+		after:       map[string]interface{}{"expires_at": expiresAt, "max_uses": maxUses},
+	})
+	if err != nil {
+		return inv, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return inv, fmt.Errorf("could not commit community invite: %v", err)
+	}
+
+	inv.ExpiresAt = expiresAt
+	inv.MaxUses = maxUses
+
+	return inv, nil
+}
+
+// AcceptCommunityInvite makes the authenticated user a member of the community
+// of the invite. Members accepting an invite do not use it up.
+func (s *Service) AcceptCommunityInvite(ctx context.Context, code string) (Community, error) {
+	var c Community
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return c, ErrUnauthenticated
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return c, fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	var communityID int64
+	var expired bool
+	query := `SELECT community_id, COALESCE(expires_at <= NOW(), FALSE) OR COALESCE(uses >= max_uses, FALSE)
+		FROM community_invites WHERE code = $1 FOR UPDATE`
+	err = tx.QueryRow(ctx, query, code).Scan(&communityID, &expired)
+	if err == pgx.ErrNoRows {
+		return c, ErrInviteNotFound
+	}
+
+	if err != nil {
+		return c, fmt.Errorf("could not query select community invite: %v", err)
+	}
+
+	role, err := communityRole(ctx, tx, communityID, uid)
+	if err != nil {
+		return c, err
+	}
+
+	if role == "" {
+		if expired {
+			return c, ErrInviteExpired
+		}
+
+		if err = addCommunityMember(ctx, tx, communityID, uid); err != nil {
+			return c, err
+		}
+
+		query = "UPDATE community_invites SET uses = uses + 1 WHERE code = $1"
+		if _, err = tx.Exec(ctx, query, code); err != nil {
+			return c, fmt.Errorf("could not update community invite uses: %v", err)
+		}
+	}
+
+	query = "SELECT " + communityColumns + " FROM communities" + communityMemberJoin + " WHERE communities.id = $2"
+	if err = tx.QueryRow(ctx, query, uid, communityID).Scan(c.scanDest()...); err != nil {
+		return c, fmt.Errorf("could not query select invite community: %v", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return c, fmt.Errorf("could not commit community invite acceptance: %v", err)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SortHot           = "hot"
+	SortTop           = "top"
+	SortNew           = "new"
+	SortControversial = "controversial"
+)
+
+var (
+	ErrInvalidSort   = errors.New("invalid sort")
+	ErrInvalidPeriod = errors.New("invalid time period")
+)
+
+// feedPeriods maps the "t" parameter to how far back top and
+// controversial feeds look. Zero means no limit.
+var feedPeriods = map[string]time.Duration{
+	"day":  time.Hour * 24,
+	"week": time.Hour * 24 * 7,
+	"all":  0,
+}
+
+type feedRank struct {
+	// expr is the SQL expression posts are ordered by
+	expr string
+	// windowed ranks only consider posts inside the requested period
+	windowed bool
+	// integer ranks are compared against an integer cursor to keep the index usable
+	integer bool
+}
+
+var feedRanks = map[string]feedRank{
+	SortHot:           {expr: "hot_score"},
+	SortTop:           {expr: "(upvotes - downvotes)", windowed: true, integer: true},
+	SortControversial: {expr: "controversy_score", windowed: true},
+	SortNew:           {expr: "created_at"},
+}
+
+// Feed gets a page of all posts ranked by sort within the period,
+// or only the posts of a community when its name is given.
+func (s *Service) Feed(
+	ctx context.Context,
+	community string,
+	sort string,
+	period string,
+	after string,
+	first int,
+) (Page[Post], error) {
+	var page Page[Post]
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return page, ErrUnauthenticated
+	}
+
+	if sort == "" {
+		sort = SortHot
+	}
+
+	rank, ok := feedRanks[sort]
+	if !ok {
+		return page, ErrInvalidSort
+	}
+
+	if period == "" {
+		period = "day"
+	}
+
+	window, ok := feedPeriods[period]
+	if !ok {
+		return page, ErrInvalidPeriod
+	}
+
+	first = normalizePageSize(first)
+
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	where = append(where, visiblePostCondition("posts", arg(uid)))
+
+	if community != "" {
+		id, err := communityID(ctx, s.Db, community, "")
+		if err != nil {
+			return page, err
+		}
+
+		where = append(where, "community_id = "+arg(id))
+	}
+
+	if rank.windowed && window != 0 {
+		where = append(where, "created_at > "+arg(time.Now().Add(-window)))
+	}
+
+	if after != "" {
+		cond, err := feedCursorCondition(rank, after, arg)
+		if err != nil {
+			return page, err
+		}
+
+		where = append(where, cond)
+	}
+
+	query := "SELECT " + postColumns + ", "
+	if sort == SortNew {
+		query += "0::DOUBLE PRECISION"
+	} else {
+		query += rank.expr + "::DOUBLE PRECISION"
+	}
+
+	query += " FROM posts WHERE " + strings.Join(where, " AND ")
+
+	query += " ORDER BY " + rank.expr + " DESC, id DESC LIMIT " + strconv.Itoa(first+1)
+
+	rows, err := s.Db.Query(ctx, query, args...)
+	if err != nil {
+		return page, fmt.Errorf("could not sql query feed: %v", err)
+	}
+
+	defer rows.Close()
+
+	type rankedPost struct {
+		Post
+		rank float64
+	}
+
+	var ranked []rankedPost
+	for rows.Next() {
+		var rp rankedPost
+		if err := rows.Scan(append(rp.scanDest(), &rp.rank)...); err != nil {
+			return page, fmt.Errorf("could not iterate over feed: %v", err)
+		}
+
+		ranked = append(ranked, rp)
+	}
+
+	if err = rows.Err(); err != nil {
+		return page, fmt.Errorf("could not iterate over feed: %v", err)
+	}
+
+	rp := newPage(ranked, first, func(rp rankedPost) string {
+		if sort == SortNew {
+			return encodeTimeCursor(rp.CreatedAt, rp.ID)
+		}
+		return encodeCursor(strconv.FormatFloat(rp.rank, 'g', -1, 64), rp.ID)
+	})
+
+	page.NextCursor = rp.NextCursor
+	page.Items = make([]Post, 0, len(rp.Items))
+	for _, p := range rp.Items {
+		page.Items = append(page.Items, p.Post)
+	}
+
+	if err = s.hydratePosts(ctx, uid, postPtrs(page.Items)...); err != nil {
+		return page, err
+	}
+
+	return page, nil
+}
+
+func feedCursorCondition(rank feedRank, after string, arg func(interface{}) string) (string, error) {
+	if rank.expr == "created_at" {
+		createdAt, id, err := decodeTimeCursor(after)
+		if err != nil {
+			return "", err
+		}
+
+		return "(created_at, id) < (" + arg(createdAt) + ", " + arg(id) + ")", nil
+	}
+
+	key, id, err := decodeCursor(after)
+	if err != nil {
+		return "", err
+	}
+
+	var v interface{}
+	if rank.integer {
+		v, err = strconv.ParseInt(key, 10, 64)
+	} else {
+		v, err = strconv.ParseFloat(key, 64)
+	}
+
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+
+	return "(" + rank.expr + ", id) < (" + arg(v) + ", " + arg(id) + ")", nil
+}
+
+// RefreshPostScores recomputes the hot and controversy scores
+// of every post whose votes changed since the last refresh.
+func (s *Service) RefreshPostScores(ctx context.Context) error {
+	query := `UPDATE posts SET
+		hot_score = post_hot_score(upvotes, downvotes, created_at),
+		controversy_score = post_controversy_score(upvotes, downvotes)
+		WHERE hot_score <> post_hot_score(upvotes, downvotes, created_at)
+		OR controversy_score <> post_controversy_score(upvotes, downvotes)`
+	if _, err := s.Db.Exec(ctx, query); err != nil {
+		return fmt.Errorf("could not refresh post scores: %v", err)
+	}
+
+	return nil
+}
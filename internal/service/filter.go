@@ -0,0 +1,531 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	FilterWord   = "word"
+	FilterRegex  = "regex"
+	FilterDomain = "domain"
+
+	// content matching a reject filter is not saved
+	FilterReject = "reject"
+	// content matching a hold filter is only visible to its author until a moderator dismisses the case
+	FilterHold = "hold"
+	// content matching a hide filter is only ever visible to its author
+	FilterHide = "hide"
+
+	TargetContentFilter = "content_filter"
+
+	ModActionCreateFilter = "create_filter"
+	ModActionDeleteFilter = "delete_filter"
+
+	maxFilterPatternLen = 500
+	filterHitExcerptLen = 500
+)
+
+var (
+	ErrContentRejected = errors.New("content rejected by filters")
+	ErrInvalidFilter   = errors.New("invalid content filter")
+	ErrFilterNotFound  = errors.New("content filter not found")
+)
+
+var (
+	filterKinds   = map[string]bool{FilterWord: true, FilterRegex: true, FilterDomain: true}
+	filterActions = map[string]int{FilterHide: 1, FilterHold: 2, FilterReject: 3}
+
+	rxLinkInText = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"']+`)
+	rxDomain     = regexp.MustCompile(`^[a-z0-9-]+(\.[a-z0-9-]+)+$`)
+)
+
+// ContentFilter is an admin rule checked against posts and comments when written.
+type ContentFilter struct {
+	ID        int64     `json:"id"`
+	Kind      string    `json:"kind"`
+	Pattern   string    `json:"pattern"`
+	Action    string    `json:"action"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ContentFilterHit is a record of content matching a filter.
+// TargetID is nil for rejected content, which is never saved.
+type ContentFilterHit struct {
+	ID         int64     `json:"id"`
+	FilterID   *int64    `json:"filter_id,omitempty"`
+	Pattern    string    `json:"pattern"`
+	Action     string    `json:"action"`
+	UserID     int64     `json:"user_id"`
+	TargetType string    `json:"target_type"`
+	TargetID   *int64    `json:"target_id,omitempty"`
+	Excerpt    string    `json:"excerpt"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type compiledFilter struct {
+	ContentFilter
+	rx     *regexp.Regexp
+	domain string
+}
+
+// filterSet is the immutable set of filters in effect, swapped as a whole on reload.
+type filterSet struct {
+	filters []compiledFilter
+}
+
+// filterResult is the outcome of checking content against the filters.
+type filterResult struct {
+	// the most severe action of the matching filters, empty if none matched
+	action  string
+	matched []compiledFilter
+	text    string
+}
+
+// hides reports whether the content is only visible to its author.
+func (r filterResult) hides() bool {
+	return r.action == FilterHold || r.action == FilterHide
+}
+
+func compileFilter(f ContentFilter) (compiledFilter, error) {
+	cf := compiledFilter{ContentFilter: f}
+	var err error
+	switch f.Kind {
+	case FilterWord:
+		cf.rx, err = regexp.Compile(`(?i)(^|\W)` + regexp.QuoteMeta(f.Pattern) + `($|\W)`)
+	case FilterRegex:
+		cf.rx, err = regexp.Compile(f.Pattern)
+	case FilterDomain:
+		cf.domain = strings.TrimPrefix(strings.ToLower(f.Pattern), ".")
+		if !rxDomain.MatchString(cf.domain) {
+			err = ErrInvalidFilter
+		}
+	default:
+		err = ErrInvalidFilter
+	}
+
+	return cf, err
+}
+
+// linkHosts gets the lowercased hosts of the links in text and of links.
+func linkHosts(text string, links ...string) []string {
+	var hosts []string
+	for _, l := range append(rxLinkInText.FindAllString(text, -1), links...) {
+		if u, err := url.Parse(l); err == nil && u.Hostname() != "" {
+			hosts = append(hosts, strings.ToLower(u.Hostname()))
+		}
+	}
+
+	return hosts
+}
+
+func (fs *filterSet) check(text string, links ...string) filterResult {
+	r := filterResult{text: text}
+	if fs == nil || len(fs.filters) == 0 {
+		return r
+	}
+
+	hosts := linkHosts(text, links...)
+	for _, f := range fs.filters {
+		matched := false
+		if f.rx != nil {
+			matched = f.rx.MatchString(text)
+		} else {
+			for _, h := range hosts {
+				if h == f.domain || strings.HasSuffix(h, "."+f.domain) {
+					matched = true
+					break
+				}
+			}
+		}
+
+		if !matched {
+			continue
+		}
+
+		r.matched = append(r.matched, f)
+		if filterActions[f.Action] > filterActions[r.action] {
+			r.action = f.Action
+		}
+	}
+
+	return r
+}
+
+// ReloadContentFilters loads the filters into memory.
+// Run periodically so changes made through other instances apply without restart.
+func (s *Service) ReloadContentFilters(ctx context.Context) error {
+	query := "SELECT id, kind, pattern, action, created_at FROM content_filters ORDER BY id"
+	rows, err := s.Db.Query(ctx, query)
+	if err != nil {
+		return fmt.Errorf("could not sql query content filters: %v", err)
+	}
+
+	defer rows.Close()
+
+	fs := &filterSet{}
+	for rows.Next() {
+		var f ContentFilter
+		if err = rows.Scan(&f.ID, &f.Kind, &f.Pattern, &f.Action, &f.CreatedAt); err != nil {
+			return fmt.Errorf("could not iterate over content filters: %v", err)
+		}
+
+		cf, err := compileFilter(f)
+		if err != nil {
+			log.Printf("skipping invalid content filter %d: %v\n", f.ID, err)
+			continue
+		}
+
+		fs.filters = append(fs.filters, cf)
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate over content filters: %v", err)
+	}
+
+	s.contentFilters.Store(fs)
+
+	return nil
+}
+
+// checkContent checks text and links written by the user uid against the filters.
+// Rejected content is recorded as a hit right away and returns ErrContentRejected,
+// other hits are recorded by applyContentFilters along with the content.
+func (s *Service) checkContent(ctx context.Context, uid int64, targetType, text string, links ...string) (filterResult, error) {
+	fs := s.contentFilters.Load()
+	if fs == nil {
+		if err := s.ReloadContentFilters(ctx); err != nil {
+			return filterResult{}, err
+		}
+
+		fs = s.contentFilters.Load()
+	}
+
+	r := fs.check(text, links...)
+	if r.action != FilterReject {
+		return r, nil
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return r, fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	if err = recordFilterHits(ctx, tx, uid, r, targetType, nil); err != nil {
+		return r, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return r, fmt.Errorf("could not commit content filter hits: %v", err)
+	}
+
+	return r, ErrContentRejected
+}
+
+func recordFilterHits(ctx context.Context, tx pgx.Tx, uid int64, r filterResult, targetType string, targetID *int64) error {
+	excerpt := r.text
+	if runes := []rune(excerpt); len(runes) > filterHitExcerptLen {
+		excerpt = string(runes[:filterHitExcerptLen])
+	}
+
+	query := `INSERT INTO content_filter_hits (filter_id, pattern, action, user_id, target_type, target_id, excerpt)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	for _, f := range r.matched {
+		if _, err := tx.Exec(ctx, query, f.ID, f.Pattern, f.Action, uid, targetType, targetID, excerpt); err != nil {
+			return fmt.Errorf("could not insert content filter hit: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// applyContentFilters records the hits of the saved post or comment targetID
+// and holds or hides it as the filters say. Held content opens a moderation case.
+func applyContentFilters(ctx context.Context, tx pgx.Tx, uid int64, r filterResult, targetType string, targetID int64) error {
+	if len(r.matched) == 0 {
+		return nil
+	}
+
+	if err := recordFilterHits(ctx, tx, uid, r, targetType, &targetID); err != nil {
+		return err
+	}
+
+	if !r.hides() {
+		return nil
+	}
+
+	table := "posts"
+	if targetType == TargetComment {
+		table = "post_comments"
+	}
+
+	query := "UPDATE " + table + " SET filter_action = $2 WHERE id = $1"
+	if _, err := tx.Exec(ctx, query, targetID, r.action); err != nil {
+		return fmt.Errorf("could not update filter action: %v", err)
+	}
+
+	if r.action != FilterHold {
+		return nil
+	}
+
+	_, communityID, err := contentSnapshot(ctx, tx, targetType, targetID)
+	if err != nil {
+		return err
+	}
+
+	query = `INSERT INTO moderation_cases (target_type, target_id, community_id, snapshot)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (target_type, target_id) WHERE status <> 'resolved'
+		DO UPDATE SET updated_at = NOW()`
+	if _, err = tx.Exec(ctx, query, targetType, targetID, communityID, r.text); err != nil {
+		return fmt.Errorf("could not upsert held content moderation case: %v", err)
+	}
+
+	return nil
+}
+
+// releaseHeldContent makes the held target of a dismissed case visible.
+func releaseHeldContent(ctx context.Context, tx pgx.Tx, c ModerationCase) error {
+	var table string
+	switch c.TargetType {
+	case TargetPost:
+		table = "posts"
+	case TargetComment:
+		table = "post_comments"
+	default:
+		return nil
+	}
+
+	query := "UPDATE " + table + " SET filter_action = NULL WHERE id = $1 AND filter_action = $2"
+	if _, err := tx.Exec(ctx, query, c.TargetID, FilterHold); err != nil {
+		return fmt.Errorf("could not release held content: %v", err)
+	}
+
+	return nil
+}
+
+// ContentFilters gets every filter. Only admins can.
+func (s *Service) ContentFilters(ctx context.Context) ([]ContentFilter, error) {
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return nil, ErrUnauthenticated
+	}
+
+	role, err := userRole(ctx, s.Db, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if role != RoleAdmin {
+		return nil, ErrForbidden
+	}
+
+	query := "SELECT id, kind, pattern, action, created_at FROM content_filters ORDER BY id"
+	rows, err := s.Db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("could not sql query content filters: %v", err)
+	}
+
+	defer rows.Close()
+
+	ff := []ContentFilter{}
+	for rows.Next() {
+		var f ContentFilter
+		if err = rows.Scan(&f.ID, &f.Kind, &f.Pattern, &f.Action, &f.CreatedAt); err != nil {
+			return nil, fmt.Errorf("could not iterate over content filters: %v", err)
+		}
+
+		ff = append(ff, f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over content filters: %v", err)
+	}
+
+	return ff, nil
+}
+
+// CreateContentFilter adds a filter, in effect right away. Only admins can.
+func (s *Service) CreateContentFilter(ctx context.Context, kind, pattern, action string) (ContentFilter, error) {
+	f := ContentFilter{Kind: kind, Pattern: strings.TrimSpace(pattern), Action: action}
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return f, ErrUnauthenticated
+	}
+
+	if f.Pattern == "" || len([]rune(f.Pattern)) > maxFilterPatternLen || !filterKinds[kind] || filterActions[action] == 0 {
+		return f, ErrInvalidFilter
+	}
+
+	if _, err := compileFilter(f); err != nil {
+		return f, ErrInvalidFilter
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return f, fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	role, err := userRole(ctx, tx, uid)
+	if err != nil {
+		return f, err
+	}
+
+	if role != RoleAdmin {
+		return f, ErrForbidden
+	}
+
+	query := "INSERT INTO content_filters (kind, pattern, action, created_by) VALUES ($1, $2, $3, $4) RETURNING id, created_at"
+	if err = tx.QueryRow(ctx, query, f.Kind, f.Pattern, f.Action, uid).Scan(&f.ID, &f.CreatedAt); err != nil {
+		return f, fmt.Errorf("could not insert content filter: %v", err)
+	}
+
+	err = logModAction(ctx, tx, modAction{
+		actorID:    uid,
+		action:     ModActionCreateFilter,
+		targetType: TargetContentFilter,
+		targetID:   f.ID,
+		after:      f,
+	})
+	if err != nil {
+		return f, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return f, fmt.Errorf("could not commit content filter: %v", err)
+	}
+
+	if err = s.ReloadContentFilters(ctx); err != nil {
+		return f, err
+	}
+
+	return f, nil
+}
+
+// DeleteContentFilter removes a filter, keeping its hits. Only admins can.
+func (s *Service) DeleteContentFilter(ctx context.Context, filterID int64) error {
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return ErrUnauthenticated
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	role, err := userRole(ctx, tx, uid)
+	if err != nil {
+		return err
+	}
+
+	if role != RoleAdmin {
+		return ErrForbidden
+	}
+
+	var f ContentFilter
+	query := "DELETE FROM content_filters WHERE id = $1 RETURNING id, kind, pattern, action, created_at"
+	err = tx.QueryRow(ctx, query, filterID).Scan(&f.ID, &f.Kind, &f.Pattern, &f.Action, &f.CreatedAt)
+	if err == pgx.ErrNoRows {
+		return ErrFilterNotFound
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not delete content filter: %v", err)
+	}
+
+	err = logModAction(ctx, tx, modAction{
+		actorID:    uid,
+		action:     ModActionDeleteFilter,
+		targetType: TargetContentFilter,
+		targetID:   f.ID,
+		before:     f,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("could not commit content filter deletion: %v", err)
+	}
+
+	return s.ReloadContentFilters(ctx)
+}
+
+// ContentFilterHits gets a page of the filter hits, most recent first. Only site moderators can.
+func (s *Service) ContentFilterHits(ctx context.Context, after string, first int) (Page[ContentFilterHit], error) {
+	var page Page[ContentFilterHit]
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return page, ErrUnauthenticated
+	}
+
+	role, err := userRole(ctx, s.Db, uid)
+	if err != nil {
+		return page, err
+	}
+
+	if !canModerate(role) {
+		return page, ErrForbidden
+	}
+
+	first = normalizePageSize(first)
+	args := []interface{}{first + 1}
+	query := `SELECT id, filter_id, pattern, action, user_id, target_type, target_id, excerpt, created_at
+		FROM content_filter_hits`
+	if after != "" {
+		createdAt, id, err := decodeTimeCursor(after)
+		if err != nil {
+			return page, err
+		}
+
+		args = append(args, createdAt, id)
+		query += " WHERE (created_at, id) < ($2, $3)"
+	}
+
+	query += " ORDER BY created_at DESC, id DESC LIMIT $1"
+
+	rows, err := s.Db.Query(ctx, query, args...)
+	if err != nil {
+		return page, fmt.Errorf("could not sql query content filter hits: %v", err)
+	}
+
+	defer rows.Close()
+
+	hh := []ContentFilterHit{}
+	for rows.Next() {
+		var h ContentFilterHit
+		err = rows.Scan(&h.ID, &h.FilterID, &h.Pattern, &h.Action, &h.UserID, &h.TargetType, &h.TargetID, &h.Excerpt, &h.CreatedAt)
+		if err != nil {
+			return page, fmt.Errorf("could not iterate over content filter hits: %v", err)
+		}
+
+		hh = append(hh, h)
+	}
+
+	if err = rows.Err(); err != nil {
+		return page, fmt.Errorf("could not iterate over content filter hits: %v", err)
+	}
+
+	page = newPage(hh, first, func(h ContentFilterHit) string {
+		return encodeTimeCursor(h.CreatedAt, h.ID)
+	})
+
+	return page, nil
+}
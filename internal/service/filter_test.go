@@ -0,0 +1,53 @@
+package service
+
+import "testing"
+
+func TestFilterSetCheck(t *testing.T) {
+	var fs filterSet
+	for i, f := range []ContentFilter{
+		{Kind: FilterWord, Pattern: "spam", Action: FilterHide},
+		{Kind: FilterRegex, Pattern: `(?i)buy\s+now`, Action: FilterHold},
+		{Kind: FilterDomain, Pattern: "bad.example", Action: FilterReject},
+	} {
+		f.ID = int64(i + 1)
+		cf, err := compileFilter(f)
+		if err != nil {
+			t.Fatalf("compileFilter(%+v) failed: %v", f, err)
+		}
+
+		fs.filters = append(fs.filters, cf)
+	}
+
+	tests := []struct {
+		text   string
+		link   string
+		action string
+	}{
+		{"nothing to see", "", ""},
+		{"such SPAM!", "", FilterHide},
+		{"spammer", "", ""},
+		{"Buy  now, spam", "", FilterHold},
+		{"see http://www.bad.example/x", "", FilterReject},
+		{"clean text", "https://bad.example", FilterReject},
+		{"notbad.example", "https://notbad.example", ""},
+	}
+
+	for _, tt := range tests {
+		r := fs.check(tt.text, tt.link)
+		if r.action != tt.action {
+			t.Errorf("check(%q, %q) action = %q, expected %q", tt.text, tt.link, r.action, tt.action)
+		}
+	}
+}
+
+func TestCompileFilterInvalid(t *testing.T) {
+	for _, f := range []ContentFilter{
+		{Kind: FilterRegex, Pattern: "("},
+		{Kind: FilterDomain, Pattern: "not a domain"},
+		{Kind: "other", Pattern: "x"},
+	} {
+		if _, err := compileFilter(f); err == nil {
+			t.Errorf("compileFilter(%+v) expected an error", f)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	// ErrForbiddenFollow used when users try to follow themselves
+	ErrForbiddenFollow = errors.New("cannot follow yourself")
+)
+
+// ToggleFollowOutput response
+type ToggleFollowOutput struct {
+	Following      bool  `json:"following"`
+	FollowersCount int64 `json:"followers_count"`
+}
+
+// ToggleFollow follows the user with the given username,
+// or unfollows them if the authenticated user already does.
+func (s *Service) ToggleFollow(ctx context.Context, username string) (ToggleFollowOutput, error) {
+	var out ToggleFollowOutput
+
+	followerID, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return out, ErrUnauthenticated
+	}
+
+	username = strings.TrimSpace(username)
+	if !rxUsername.MatchString(username) {
+		return out, ErrInvalidUsername
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return out, fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	var followeeID int64
+	query := "SELECT id FROM users WHERE username = $1 FOR UPDATE"
+	err = tx.QueryRow(ctx, query, username).Scan(&followeeID)
+	if err == pgx.ErrNoRows {
+		return out, ErrUserNotFound
+	}
+
+	if err != nil {
+		return out, fmt.Errorf("could not query select user id from followee username: %v", err)
+	}
+
+	if followeeID == followerID {
+		return out, ErrForbiddenFollow
+	}
+
+	query = "SELECT EXISTS (SELECT 1 FROM user_follows WHERE follower_id = $1 AND followee_id = $2)"
+	if err = tx.QueryRow(ctx, query, followerID, followeeID).Scan(&out.Following); err != nil {
+		return out, fmt.Errorf("could not query select existence of follow: %v", err)
+	}
+
+	delta := 1
+	if out.Following {
+		delta = -1
+		query = "DELETE FROM user_follows WHERE follower_id = $1 AND followee_id = $2"
+	} else {
+		query = "INSERT INTO user_follows (follower_id, followee_id) VALUES ($1, $2)"
+	}
+
+	if _, err = tx.Exec(ctx, query, followerID, followeeID); err != nil {
+		return out, fmt.Errorf("could not write follow: %v", err)
+	}
+
+	query = "UPDATE users SET followees_count = followees_count + $2 WHERE id = $1"
+	if _, err = tx.Exec(ctx, query, followerID, delta); err != nil {
+		return out, fmt.Errorf("could not update follower followees count: %v", err)
+	}
+
+	query = "UPDATE users SET followers_count = followers_count + $2 WHERE id = $1 RETURNING followers_count"
+	if err = tx.QueryRow(ctx, query, followeeID, delta).Scan(&out.FollowersCount); err != nil {
+		return out, fmt.Errorf("could not update followee followers count: %v", err)
+	}
+
+	out.Following = !out.Following
+
+	var n *Notification
+	if out.Following {
+		if n, err = notify(ctx, tx, followeeID, followerID, NotificationFollow, nil, nil); err != nil {
+			return out, err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return out, fmt.Errorf("could not commit toggle follow: %v", err)
+	}
+
+	s.broadcastNotifications(n)
+
+	return out, nil
+}
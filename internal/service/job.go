@@ -0,0 +1,25 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RunPeriodically runs job every interval until ctx is done.
+// Errors are logged and do not stop the loop.
+func (s *Service) RunPeriodically(ctx context.Context, interval time.Duration, job func(context.Context) error) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := job(ctx); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
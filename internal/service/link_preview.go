@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	LinkPreviewPending = "pending"
+	LinkPreviewReady   = "ready"
+	LinkPreviewFailed  = "failed"
+	// cached previews older than this are fetched again when linked anew
+	linkPreviewTTL = time.Hour * 24 * 7
+	// how many pending links an UnfurlPendingLinks run fetches
+	unfurlBatch = 10
+)
+
+// LinkPreview model
+type LinkPreview struct {
+	URL          string `json:"url"`
+	Title        string `json:"title,omitempty"`
+	Description  string `json:"description,omitempty"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	SiteName     string `json:"site_name,omitempty"`
+}
+
+func validLink(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// queueLinkPreview schedules link to be unfurled inside tx,
+// unless it has a fresh enough cached preview.
+func queueLinkPreview(ctx context.Context, tx pgx.Tx, link string) error {
+	query := `INSERT INTO link_previews (url) VALUES ($1)
+		ON CONFLICT (url) DO UPDATE SET status = $2
+		WHERE link_previews.status <> $2 AND link_previews.fetched_at < $3`
+	if _, err := tx.Exec(ctx, query, link, LinkPreviewPending, time.Now().Add(-linkPreviewTTL)); err != nil {
+		return fmt.Errorf("could not queue link preview: %v", err)
+	}
+
+	return nil
+}
+
+// linkPreviews loads the ready previews of links keyed by URL.
+func linkPreviews(ctx context.Context, db querier, links []string) (map[string]*LinkPreview, error) {
+	lp := map[string]*LinkPreview{}
+	if len(links) == 0 {
+		return lp, nil
+	}
+
+	query := `SELECT url, title, description, image_url, site_name
+		FROM link_previews
+		WHERE url = ANY($1) AND status = $2`
+	rows, err := db.Query(ctx, query, links, LinkPreviewReady)
+	if err != nil {
+		return nil, fmt.Errorf("could not sql query link previews: %v", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var p LinkPreview
+		if err := rows.Scan(&p.URL, &p.Title, &p.Description, &p.ThumbnailURL, &p.SiteName); err != nil {
+			return nil, fmt.Errorf("could not iterate over link previews: %v", err)
+		}
+
+		lp[p.URL] = &p
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over link previews: %v", err)
+	}
+
+	return lp, nil
+}
+
+// UnfurlPendingLinks fetches the previews of newly linked URLs.
+func (s *Service) UnfurlPendingLinks(ctx context.Context) error {
+	if s.Unfurler == nil {
+		return nil
+	}
+
+	query := "SELECT url FROM link_previews WHERE status = $1 LIMIT $2"
+	rows, err := s.Db.Query(ctx, query, LinkPreviewPending, unfurlBatch)
+	if err != nil {
+		return fmt.Errorf("could not sql query pending links: %v", err)
+	}
+
+	var links []string
+	for rows.Next() {
+		var link string
+		if err := rows.Scan(&link); err != nil {
+			rows.Close()
+			return fmt.Errorf("could not iterate over pending links: %v", err)
+		}
+
+		links = append(links, link)
+	}
+
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate over pending links: %v", err)
+	}
+
+	for _, link := range links {
+		p, err := s.Unfurler.Fetch(ctx, link)
+		if err != nil {
+			log.Printf("could not unfurl %s: %v\n", link, err)
+
+			query = "UPDATE link_previews SET status = $2, fetched_at = NOW() WHERE url = $1"
+			if _, err = s.Db.Exec(ctx, query, link, LinkPreviewFailed); err != nil {
+				return fmt.Errorf("could not mark link preview as failed: %v", err)
+			}
+			continue
+		}
+
+		query = `UPDATE link_previews
+			SET status = $2, title = $3, description = $4, image_url = $5, site_name = $6, fetched_at = NOW()
+			WHERE url = $1`
+		if _, err = s.Db.Exec(ctx, query, link, LinkPreviewReady, p.Title, p.Description, p.ImageURL, p.SiteName); err != nil {
+			return fmt.Errorf("could not update link preview: %v", err)
+		}
+	}
+
+	return nil
+}
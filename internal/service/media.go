@@ -0,0 +1,319 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/dhruvsingh510/bond_social_api/internal/storage"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	// MaxMediaSize is the largest upload accepted, in bytes
+	MaxMediaSize = 10 << 20
+	maxAlbumSize = 20
+	// raw uploads live under this key prefix until processed
+	rawMediaPrefix = "raw/"
+)
+
+var (
+	ErrInvalidMedia         = errors.New("invalid media")
+	ErrMediaTooLarge        = errors.New("media too large")
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	ErrMediaNotFound        = errors.New("media not found")
+	ErrInvalidAlbum         = errors.New("invalid album")
+)
+
+// mediaExtensions maps the accepted upload types to the extension of their blob key
+var mediaExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+const (
+	MediaProcessing = "processing"
+	MediaReady      = "ready"
+	MediaFailed     = "failed"
+)
+
+// Media model
+// URL and the variants are only set once the media is processed.
+type Media struct {
+	ID          int64          `json:"id"`
+	ContentType string         `json:"content_type"`
+	Size        int64          `json:"size"`
+	Status      string         `json:"status"`
+	URL         string         `json:"url,omitempty"`
+	Width       int            `json:"width,omitempty"`
+	Height      int            `json:"height,omitempty"`
+	Blurhash    string         `json:"blurhash,omitempty"`
+	Variants    []MediaVariant `json:"variants,omitempty"`
+	CreatedAt   time.Time      `json:"created_at"`
+}
+
+// MediaVariant model
+type MediaVariant struct {
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Size        int64  `json:"size"`
+}
+
+func mediaURL(key string) string {
+	return "/media/" + key
+}
+
+// UploadMedia stores an uploaded file for the authenticated user.
+// Blobs are addressed by content hash, so identical files are stored once,
+// and uploading the same file twice returns the same media.
+// The raw upload is kept private until ProcessPendingMedia strips its metadata.
+func (s *Service) UploadMedia(ctx context.Context, data []byte) (Media, error) {
+	var m Media
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return m, ErrUnauthenticated
+	}
+
+	if len(data) == 0 {
+		return m, ErrInvalidMedia
+	}
+
+	if len(data) > MaxMediaSize {
+		return m, ErrMediaTooLarge
+	}
+
+	m.ContentType = http.DetectContentType(data)
+	ext, ok := mediaExtensions[m.ContentType]
+	if !ok {
+		return m, ErrUnsupportedMediaType
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	key := rawMediaPrefix + hash[:2] + "/" + hash + ext
+
+	exists, err := s.Blobs.Exists(ctx, key)
+	if err != nil {
+		return m, fmt.Errorf("could not check media blob: %v", err)
+	}
+
+	if !exists {
+		if err = s.Blobs.Put(ctx, key, data, m.ContentType); err != nil {
+			return m, fmt.Errorf("could not store media blob: %v", err)
+		}
+	}
+
+	query := `INSERT INTO media (user_id, hash, blob_key, content_type, size) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, hash) DO UPDATE SET hash = EXCLUDED.hash
+		RETURNING id, status, created_at`
+	if err = s.Db.QueryRow(ctx, query, uid, hash, key, m.ContentType, len(data)).Scan(&m.ID, &m.Status, &m.CreatedAt); err != nil {
+		return m, fmt.Errorf("could not insert media: %v", err)
+	}
+
+	m.Size = int64(len(data))
+
+	if m.Status == MediaReady {
+		mm := []*Media{&m}
+		if err = loadMediaVariants(ctx, s.Db, mm); err != nil {
+			return m, err
+		}
+	}
+
+	return m, nil
+}
+
+// MediaContent opens the blob under key along with its content type.
+// Raw uploads are never served.
+func (s *Service) MediaContent(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	if strings.HasPrefix(key, rawMediaPrefix) {
+		return nil, "", ErrMediaNotFound
+	}
+
+	var contentType string
+	for ct, ext := range mediaExtensions {
+		if path.Ext(key) == ext {
+			contentType = ct
+		}
+	}
+
+	if contentType == "" {
+		return nil, "", ErrMediaNotFound
+	}
+
+	rc, err := s.Blobs.Get(ctx, key)
+	if err == storage.ErrNotFound || err == storage.ErrInvalidKey {
+		return nil, "", ErrMediaNotFound
+	}
+
+	if err != nil {
+		return nil, "", fmt.Errorf("could not get media blob: %v", err)
+	}
+
+	return rc, contentType, nil
+}
+
+func validateAlbum(album []int64) error {
+	if len(album) > maxAlbumSize || len(uniqueInt64s(album)) != len(album) {
+		return ErrInvalidAlbum
+	}
+
+	return nil
+}
+
+// attachAlbum links the media of album to a post inside tx,
+// checking all of it was uploaded by uid.
+func attachAlbum(ctx context.Context, tx pgx.Tx, uid, postID int64, album []int64) ([]Media, error) {
+	query := `INSERT INTO post_media (post_id, media_id, position)
+		SELECT $1, media.id, a.position - 1
+		FROM UNNEST($3::INT[]) WITH ORDINALITY AS a(media_id, position)
+		INNER JOIN media ON media.id = a.media_id AND media.user_id = $2 AND media.status <> 'failed'
+		RETURNING media_id`
+	rows, err := tx.Query(ctx, query, postID, uid, album)
+	if err != nil {
+		return nil, fmt.Errorf("could not insert post media: %v", err)
+	}
+
+	var n int
+	for rows.Next() {
+		n++
+	}
+
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not insert post media: %v", err)
+	}
+
+	if n != len(album) {
+		return nil, ErrInvalidAlbum
+	}
+
+	mm, err := albums(ctx, tx, []int64{postID})
+	if err != nil {
+		return nil, err
+	}
+
+	return mm[postID], nil
+}
+
+// albums loads the album media of the given posts keyed by post id.
+func albums(ctx context.Context, db querier, postIDs []int64) (map[int64][]Media, error) {
+	query := `SELECT post_media.post_id, media.id, media.content_type, media.size, media.status,
+		COALESCE(media.width, 0), COALESCE(media.height, 0), COALESCE(media.blurhash, ''), media.created_at
+		FROM post_media
+		INNER JOIN media ON post_media.media_id = media.id
+		WHERE post_media.post_id = ANY($1)
+		ORDER BY post_media.post_id, post_media.position`
+	rows, err := db.Query(ctx, query, postIDs)
+	if err != nil {
+		return nil, fmt.Errorf("could not sql query post albums: %v", err)
+	}
+
+	defer rows.Close()
+
+	mm := map[int64][]Media{}
+	var order []int64
+	for rows.Next() {
+		var postID int64
+		var m Media
+		if err := rows.Scan(
+			&postID,
+			&m.ID,
+			&m.ContentType,
+			&m.Size,
+			&m.Status,
+			&m.Width,
+			&m.Height,
+			&m.Blurhash,
+			&m.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("could not iterate over post albums: %v", err)
+		}
+
+		if _, ok := mm[postID]; !ok {
+			order = append(order, postID)
+		}
+
+		mm[postID] = append(mm[postID], m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over post albums: %v", err)
+	}
+
+	var all []*Media
+	for _, postID := range order {
+		for i := range mm[postID] {
+			all = append(all, &mm[postID][i])
+		}
+	}
+
+	if err = loadMediaVariants(ctx, db, all); err != nil {
+		return nil, err
+	}
+
+	return mm, nil
+}
+
+// loadMediaVariants fills the variants and URL of each media.
+func loadMediaVariants(ctx context.Context, db querier, mm []*Media) error {
+	if len(mm) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(mm))
+	for i, m := range mm {
+		ids[i] = m.ID
+	}
+
+	query := `SELECT media_id, name, blob_key, content_type, width, height, size
+		FROM media_variants
+		WHERE media_id = ANY($1)
+		ORDER BY media_id, width DESC`
+	rows, err := db.Query(ctx, query, ids)
+	if err != nil {
+		return fmt.Errorf("could not sql query media variants: %v", err)
+	}
+
+	defer rows.Close()
+
+	variants := map[int64][]MediaVariant{}
+	for rows.Next() {
+		var mediaID int64
+		var key string
+		var v MediaVariant
+		if err := rows.Scan(&mediaID, &v.Name, &key, &v.ContentType, &v.Width, &v.Height, &v.Size); err != nil {
+			return fmt.Errorf("could not iterate over media variants: %v", err)
+		}
+
+		v.URL = mediaURL(key)
+		variants[mediaID] = append(variants[mediaID], v)
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate over media variants: %v", err)
+	}
+
+	for _, m := range mm {
+		m.Variants = variants[m.ID]
+		for _, v := range m.Variants {
+			if v.Name == originalVariant {
+				m.URL = v.URL
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,249 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"log"
+
+	"github.com/dhruvsingh510/bond_social_api/internal/imaging"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	originalVariant   = "original"
+	webpVariantSuffix = "_webp"
+	// larger images are rejected before decoding to avoid decompression bombs
+	maxMediaPixels = 50_000_000
+	// how many pending uploads a ProcessPendingMedia run handles
+	mediaProcessBatch = 10
+)
+
+// thumbnailSizes are the variants generated for every image, by the longest
+// side in pixels, each in JPEG and in lossless WebP under the name suffixed
+// with webpVariantSuffix. Sizes larger than the image are skipped.
+var thumbnailSizes = []struct {
+	name string
+	max  int
+}{
+	{"small", 320},
+	{"medium", 640},
+	{"large", 1280},
+}
+
+type pendingMedia struct {
+	id          int64
+	hash        string
+	key         string
+	contentType string
+}
+
+type encodedVariant struct {
+	MediaVariant
+	key  string
+	data []byte
+}
+
+// ProcessPendingMedia runs the image pipeline on uploads not processed yet:
+// metadata stripping, upright orientation, thumbnails and a blurhash.
+func (s *Service) ProcessPendingMedia(ctx context.Context) error {
+	query := "SELECT id, hash, blob_key, content_type FROM media WHERE status = $1 ORDER BY id LIMIT $2"
+	rows, err := s.Db.Query(ctx, query, MediaProcessing, mediaProcessBatch)
+	if err != nil {
+		return fmt.Errorf("could not sql query pending media: %v", err)
+	}
+
+	var pending []pendingMedia
+	for rows.Next() {
+		var pm pendingMedia
+		if err := rows.Scan(&pm.id, &pm.hash, &pm.key, &pm.contentType); err != nil {
+			rows.Close()
+			return fmt.Errorf("could not iterate over pending media: %v", err)
+		}
+
+		pending = append(pending, pm)
+	}
+
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate over pending media: %v", err)
+	}
+
+	for _, pm := range pending {
+		if err := s.processMedia(ctx, pm); err != nil {
+			log.Printf("could not process media %d: %v\n", pm.id, err)
+
+			query = "UPDATE media SET status = $2 WHERE id = $1"
+			if _, err = s.Db.Exec(ctx, query, pm.id, MediaFailed); err != nil {
+				return fmt.Errorf("could not mark media as failed: %v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) processMedia(ctx context.Context, pm pendingMedia) error {
+	rc, err := s.Blobs.Get(ctx, pm.key)
+	if err != nil {
+		return fmt.Errorf("could not get raw blob: %v", err)
+	}
+
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(rc)
+	rc.Close()
+	if err != nil {
+		return fmt.Errorf("could not read raw blob: %v", err)
+	}
+
+	raw := buf.Bytes()
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return fmt.Errorf("could not decode image config: %v", err)
+	}
+
+	if cfg.Width*cfg.Height > maxMediaPixels {
+		return fmt.Errorf("image too large: %dx%d", cfg.Width, cfg.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return fmt.Errorf("could not decode image: %v", err)
+	}
+
+	orientation := 1
+	if pm.contentType == "image/jpeg" {
+		orientation = imaging.Orientation(raw)
+	}
+
+	img = imaging.Orient(img, orientation)
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	// Stripping keeps the original encoding untouched. A rotated JPEG
+	// has to be re-encoded since its orientation tag goes away with the EXIF.
+	var original []byte
+	if orientation != 1 {
+		original, err = encodeJPEG(img, 90)
+	} else {
+		original, err = imaging.StripMetadata(raw, pm.contentType)
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not strip image metadata: %v", err)
+	}
+
+	prefix := pm.hash[:2] + "/" + pm.hash + "/"
+	variants := []encodedVariant{{
+		MediaVariant: MediaVariant{
+			Name:        originalVariant,
+			ContentType: pm.contentType,
+			Width:       width,
+			Height:      height,
+		},
+		key:  prefix + originalVariant + mediaExtensions[pm.contentType],
+		data: original,
+	}}
+
+	for _, size := range thumbnailSizes {
+		if width <= size.max && height <= size.max {
+			continue
+		}
+
+		thumb := imaging.Fit(img, size.max)
+		data, err := encodeJPEG(thumb, 82)
+		if err != nil {
+			return fmt.Errorf("could not encode %s thumbnail: %v", size.name, err)
+		}
+
+		variants = append(variants, encodedVariant{
+			MediaVariant: MediaVariant{
+				Name:        size.name,
+				ContentType: "image/jpeg",
+				Width:       thumb.Bounds().Dx(),
+				Height:      thumb.Bounds().Dy(),
+			},
+			key:  prefix + size.name + ".jpg",
+			data: data,
+		})
+
+		if data, err = encodeWebP(thumb); err != nil {
+			return fmt.Errorf("could not encode %s webp thumbnail: %v", size.name, err)
+		}
+
+		variants = append(variants, encodedVariant{
+			MediaVariant: MediaVariant{
+				Name:        size.name + webpVariantSuffix,
+				ContentType: "image/webp",
+				Width:       thumb.Bounds().Dx(),
+				Height:      thumb.Bounds().Dy(),
+			},
+			key:  prefix + size.name + ".webp",
+			data: data,
+		})
+	}
+
+	blurhash := imaging.Blurhash(imaging.Fit(img, 32), 4, 3)
+
+	for _, v := range variants {
+		exists, err := s.Blobs.Exists(ctx, v.key)
+		if err != nil {
+			return fmt.Errorf("could not check variant blob: %v", err)
+		}
+
+		if exists {
+			continue
+		}
+
+		if err = s.Blobs.Put(ctx, v.key, v.data, v.ContentType); err != nil {
+			return fmt.Errorf("could not store variant blob: %v", err)
+		}
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	query := `INSERT INTO media_variants (media_id, name, blob_key, content_type, width, height, size)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (media_id, name) DO NOTHING`
+	for _, v := range variants {
+		if _, err = tx.Exec(ctx, query, pm.id, v.Name, v.key, v.ContentType, v.Width, v.Height, len(v.data)); err != nil {
+			return fmt.Errorf("could not insert media variant: %v", err)
+		}
+	}
+
+	query = "UPDATE media SET status = $2, width = $3, height = $4, blurhash = $5, processed_at = NOW() WHERE id = $1"
+	if _, err = tx.Exec(ctx, query, pm.id, MediaReady, width, height, blurhash); err != nil {
+		return fmt.Errorf("could not update processed media: %v", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("could not commit processed media: %v", err)
+	}
+
+	return nil
+}
+
+func encodeJPEG(img image.Image, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeWebP(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := imaging.EncodeWebP(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
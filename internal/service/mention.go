@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// rxMention matches @username tokens not preceded by a word character,
+// so email addresses are not taken for mentions.
+var rxMention = regexp.MustCompile(`(?:^|[^\w@])@([a-zA-Z][a-zA-Z0-9_]{3,30})\b`)
+
+// extractMentions returns the distinct usernames mentioned in text, in order of appearance.
+func extractMentions(text string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, m := range rxMention.FindAllStringSubmatch(text, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			out = append(out, m[1])
+		}
+	}
+	return out
+}
+
+// saveMentions makes the mentions of target in table exactly the existing users
+// among usernames, and returns the ids of the users that were not mentioned before.
+func saveMentions(ctx context.Context, tx pgx.Tx, table, column string, target int64, usernames []string) ([]int64, error) {
+	query := "DELETE FROM " + table + " WHERE " + column + ` = $1
+		AND user_id NOT IN (SELECT id FROM users WHERE username = ANY($2))`
+	if _, err := tx.Exec(ctx, query, target, usernames); err != nil {
+		return nil, fmt.Errorf("could not delete mentions: %v", err)
+	}
+
+	if len(usernames) == 0 {
+		return nil, nil
+	}
+
+	query = "INSERT INTO " + table + " (" + column + `, user_id)
+		SELECT $1, id FROM users WHERE username = ANY($2)
+		ON CONFLICT DO NOTHING
+		RETURNING user_id`
+	rows, err := tx.Query(ctx, query, target, usernames)
+	if err != nil {
+		return nil, fmt.Errorf("could not insert mentions: %v", err)
+	}
+
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("could not iterate over inserted mentions: %v", err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over inserted mentions: %v", err)
+	}
+
+	return ids, nil
+}
+
+// notifyMentions notifies each newly mentioned user in mentionedIDs
+// that actorID mentioned them in a post, or a comment when commentID is not nil.
+func notifyMentions(
+	ctx context.Context,
+	tx pgx.Tx,
+	actorID int64,
+	mentionedIDs []int64,
+	postID int64,
+	commentID *int64,
+) ([]*Notification, error) {
+	var nn []*Notification
+	for _, id := range mentionedIDs {
+		n, err := notify(ctx, tx, id, actorID, NotificationMention, &postID, commentID)
+		if err != nil {
+			return nil, err
+		}
+
+		nn = append(nn, n)
+	}
+
+	return nn, nil
+}
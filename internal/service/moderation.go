@@ -0,0 +1,490 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	CaseOpen     = "open"
+	CaseClaimed  = "claimed"
+	CaseResolved = "resolved"
+
+	ActionDismiss       = "dismiss"
+	ActionRemoveContent = "remove_content"
+	ActionWarn          = "warn"
+	ActionSuspend       = "suspend"
+
+	maxResolutionNoteLen = 1000
+	maxSuspensionDays    = 365
+)
+
+var (
+	ErrModerationCaseNotFound  = errors.New("moderation case not found")
+	ErrModerationCaseClaimed   = errors.New("moderation case claimed by another moderator")
+	ErrModerationCaseResolved  = errors.New("moderation case already resolved")
+	ErrInvalidModerationAction = errors.New("invalid moderation action")
+	ErrInvalidModerationFilter = errors.New("invalid moderation queue filter")
+)
+
+var caseStatuses = map[string]bool{
+	CaseOpen:     true,
+	CaseClaimed:  true,
+	CaseResolved: true,
+}
+
+// ModerationCase groups the reports on a single target.
+// Snapshot is the reported content as it was when first reported.
+type ModerationCase struct {
+	ID             int64      `json:"id"`
+	TargetType     string     `json:"target_type"`
+	TargetID       int64      `json:"target_id"`
+	CommunityID    *int64     `json:"community_id,omitempty"`
+	Snapshot       string     `json:"snapshot"`
+	Status         string     `json:"status"`
+	ReportsCount   int        `json:"reports_count"`
+	Reasons        []string   `json:"reasons"`
+	ClaimedBy      *int64     `json:"claimed_by,omitempty"`
+	Resolution     *string    `json:"resolution,omitempty"`
+	ResolutionNote *string    `json:"resolution_note,omitempty"`
+	ResolvedBy     *int64     `json:"resolved_by,omitempty"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// moderationCaseColumns lists the moderation_cases columns read by ModerationCase.scanDest, in order.
+const moderationCaseColumns = `moderation_cases.id, moderation_cases.target_type, moderation_cases.target_id,
+	moderation_cases.community_id, moderation_cases.snapshot, moderation_cases.status, moderation_cases.reports_count,
+	ARRAY(SELECT DISTINCT reason FROM reports WHERE reports.case_id = moderation_cases.id ORDER BY reason),
+	moderation_cases.claimed_by, moderation_cases.resolution, moderation_cases.resolution_note,
+	moderation_cases.resolved_by, moderation_cases.resolved_at, moderation_cases.created_at`
+
+func (c *ModerationCase) scanDest() []interface{} {
+	return []interface{}{
+		&c.ID,
+		&c.TargetType,
+		&c.TargetID,
+		&c.CommunityID,
+		&c.Snapshot,
+		&c.Status,
+		&c.ReportsCount,
+		&c.Reasons,
+		&c.ClaimedBy,
+		&c.Resolution,
+		&c.ResolutionNote,
+		&c.ResolvedBy,
+		&c.ResolvedAt,
+		&c.CreatedAt,
+	}
+}
+
+// ModerationQueueFilter narrows the moderation queue. Status defaults to open.
+type ModerationQueueFilter struct {
+	Status     string
+	TargetType string
+	Reason     string
+	Community  string
+}
+
+// ResolveInput is how a moderator settles a moderation case.
+// SuspendDays is required by the suspend action.
+type ResolveInput struct {
+	Action      string
+	Note        string
+	SuspendDays int
+}
+
+// canModerateCase reports whether the user can handle cases about content
+// of the community, nil for content outside of communities and users.
+// Site moderators handle every case, community moderators those of their communities.
+func canModerateCase(ctx context.Context, db querier, uid int64, communityID *int64) (bool, error) {
+	if communityID != nil {
+		return canModerateCommunity(ctx, db, *communityID, uid)
+	}
+
+	role, err := userRole(ctx, db, uid)
+	if err != nil {
+		return false, err
+	}
+
+	return canModerate(role), nil
+}
+
+// ModerationQueue gets a page of the moderation cases the authenticated
+// user can handle, oldest first.
+func (s *Service) ModerationQueue(
+	ctx context.Context,
+	f ModerationQueueFilter,
+	after string,
+	first int,
+) (Page[ModerationCase], error) {
+	var page Page[ModerationCase]
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return page, ErrUnauthenticated
+	}
+
+	if f.Status == "" {
+		f.Status = CaseOpen
+	}
+
+	if !caseStatuses[f.Status] {
+		return page, ErrInvalidModerationFilter
+	}
+
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	where = append(where, "moderation_cases.status = "+arg(f.Status))
+
+	role, err := userRole(ctx, s.Db, uid)
+	if err != nil {
+		return page, err
+	}
+
+	if !canModerate(role) {
+		var moderator bool
+		query := "SELECT EXISTS (SELECT 1 FROM community_members WHERE user_id = $1 AND role = 'moderator')"
+		if err = s.Db.QueryRow(ctx, query, uid).Scan(&moderator); err != nil {
+			return page, fmt.Errorf("could not query select community moderator existence: %v", err)
+		}
+
+		if !moderator {
+			return page, ErrForbidden
+		}
+
+		where = append(where, `moderation_cases.community_id IN (
+			SELECT community_id FROM community_members WHERE user_id = `+arg(uid)+" AND role = 'moderator')")
+	}
+
+	if f.TargetType != "" {
+		if f.TargetType != TargetPost && f.TargetType != TargetComment && f.TargetType != TargetUser {
+			return page, ErrInvalidModerationFilter
+		}
+
+		where = append(where, "moderation_cases.target_type = "+arg(f.TargetType))
+	}
+
+	if f.Reason != "" {
+		if !reportReasons[f.Reason] {
+			return page, ErrInvalidModerationFilter
+		}
+
+		where = append(where, "EXISTS (SELECT 1 FROM reports WHERE reports.case_id = moderation_cases.id AND reports.reason = "+
+			arg(f.Reason)+")")
+	}
+
+	if f.Community != "" {
+		id, err := communityID(ctx, s.Db, f.Community, "")
+		if err != nil {
+			return page, err
+		}
+
+		where = append(where, "moderation_cases.community_id = "+arg(id))
+	}
+
+	if after != "" {
+		createdAt, id, err := decodeTimeCursor(after)
+		if err != nil {
+			return page, err
+		}
+
+		where = append(where, "(moderation_cases.created_at, moderation_cases.id) > ("+arg(createdAt)+", "+arg(id)+")")
+	}
+
+	first = normalizePageSize(first)
+	query := "SELECT " + moderationCaseColumns + " FROM moderation_cases WHERE " + strings.Join(where, " AND ") +
+		" ORDER BY moderation_cases.created_at, moderation_cases.id LIMIT " + strconv.Itoa(first+1)
+
+	rows, err := s.Db.Query(ctx, query, args...)
+	if err != nil {
+		return page, fmt.Errorf("could not sql query moderation queue: %v", err)
+	}
+
+	defer rows.Close()
+
+	cc := []ModerationCase{}
+	for rows.Next() {
+		var c ModerationCase
+		if err := rows.Scan(c.scanDest()...); err != nil {
+			return page, fmt.Errorf("could not iterate over moderation queue: %v", err)
+		}
+
+		cc = append(cc, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return page, fmt.Errorf("could not iterate over moderation queue: %v", err)
+	}
+
+	page = newPage(cc, first, func(c ModerationCase) string {
+		return encodeTimeCursor(c.CreatedAt, c.ID)
+	})
+
+	return page, nil
+}
+
+// lockModerationCase gets a case for update inside tx and checks the user uid
+// can handle it and it is not resolved nor claimed by someone else.
+func lockModerationCase(ctx context.Context, tx pgx.Tx, uid, caseID int64) (ModerationCase, error) {
+	var c ModerationCase
+
+	query := "SELECT " + moderationCaseColumns + " FROM moderation_cases WHERE id = $1 FOR UPDATE"
+	err := tx.QueryRow(ctx, query, caseID).Scan(c.scanDest()...)
+	if err == pgx.ErrNoRows {
+		return c, ErrModerationCaseNotFound
+	}
+
+	if err != nil {
+		return c, fmt.Errorf("could not query select moderation case: %v", err)
+	}
+
+	ok, err := canModerateCase(ctx, tx, uid, c.CommunityID)
+	if err != nil {
+		return c, err
+	}
+
+	if !ok {
+		return c, ErrForbidden
+	}
+
+	if c.Status == CaseResolved {
+		return c, ErrModerationCaseResolved
+	}
+
+	if c.ClaimedBy != nil && *c.ClaimedBy != uid {
+		return c, ErrModerationCaseClaimed
+	}
+
+	return c, nil
+}
+
+// ClaimModerationCase assigns an open case to the authenticated moderator,
+// so other moderators do not handle it at the same time.
+func (s *Service) ClaimModerationCase(ctx context.Context, caseID int64) (ModerationCase, error) {
+	var c ModerationCase
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return c, ErrUnauthenticated
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return c, fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	if c, err = lockModerationCase(ctx, tx, uid, caseID); err != nil {
+		return c, err
+	}
+
+	query := "UPDATE moderation_cases SET status = $2, claimed_by = $3, claimed_at = NOW() WHERE id = $1"
+	if _, err = tx.Exec(ctx, query, caseID, CaseClaimed, uid); err != nil {
+		return c, fmt.Errorf("could not update moderation case claim: %v", err)
+	}
+
+	err = logModAction(ctx, tx, modAction{
+		actorID:     uid,
+		action:      ModActionClaimCase,
+		targetType:  TargetModerationCase,
+		targetID:    caseID,
+		communityID: c.CommunityID,
+		before:      map[string]interface{}{"status": c.Status, "claimed_by": c.ClaimedBy},
+		after:       map[string]interface{}{"status": CaseClaimed, "claimed_by": uid},
+	})
+	if err != nil {
+		return c, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return c, fmt.Errorf("could not commit moderation case claim: %v", err)
+	}
+
+	c.Status = CaseClaimed
+	c.ClaimedBy = &uid
+
+	return c, nil
+}
+
+// ResolveModerationCase settles a case with one of the moderation actions:
+// dismiss the reports, releasing content held by the filters, remove the reported
+// post or comment, warn its author, or suspend them which only site moderators can.
+func (s *Service) ResolveModerationCase(ctx context.Context, caseID int64, in ResolveInput) (ModerationCase, error) {
+	var c ModerationCase
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return c, ErrUnauthenticated
+	}
+
+	in.Note = strings.TrimSpace(in.Note)
+	if len([]rune(in.Note)) > maxResolutionNoteLen {
+		return c, ErrInvalidModerationAction
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return c, fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	if c, err = lockModerationCase(ctx, tx, uid, caseID); err != nil {
+		return c, err
+	}
+
+	var n *Notification
+	switch in.Action {
+	case ActionDismiss:
+		if err = releaseHeldContent(ctx, tx, c); err != nil {
+			return c, err
+		}
+
+		err = logModAction(ctx, tx, modAction{
+			actorID:     uid,
+			action:      ModActionDismissReports,
+			targetType:  TargetModerationCase,
+			targetID:    c.ID,
+			communityID: c.CommunityID,
+			reason:      in.Note,
+		})
+	case ActionRemoveContent:
+		err = removeCaseTarget(ctx, tx, uid, c, in.Note)
+	case ActionWarn:
+		n, err = warnCaseTarget(ctx, tx, uid, c, in.Note)
+	case ActionSuspend:
+		err = suspendCaseTarget(ctx, tx, uid, c, in)
+	default:
+		err = ErrInvalidModerationAction
+	}
+
+	if err != nil {
+		return c, err
+	}
+
+	query := `UPDATE moderation_cases SET status = $2, resolution = $3, resolution_note = $4,
+		resolved_by = $5, resolved_at = NOW()
+		WHERE id = $1 RETURNING ` + moderationCaseColumns
+	err = tx.QueryRow(ctx, query, caseID, CaseResolved, in.Action, in.Note, uid).Scan(c.scanDest()...)
+	if err != nil {
+		return c, fmt.Errorf("could not update moderation case resolution: %v", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return c, fmt.Errorf("could not commit moderation case resolution: %v", err)
+	}
+
+	s.broadcastNotifications(n)
+
+	return c, nil
+}
+
+// caseTargetAuthor gets the author of the target of a case, the user itself for user cases,
+// along with the post of the target if any.
+func caseTargetAuthor(ctx context.Context, db querier, c ModerationCase) (int64, *int64, error) {
+	var authorID, postID *int64
+	var query string
+	switch c.TargetType {
+	case TargetPost:
+		query = "SELECT user_id, id FROM posts WHERE id = $1"
+	case TargetComment:
+		query = "SELECT user_id, post_id FROM post_comments WHERE id = $1"
+	default:
+		query = "SELECT id, NULL::INT FROM users WHERE id = $1"
+	}
+
+	err := db.QueryRow(ctx, query, c.TargetID).Scan(&authorID, &postID)
+	if err == pgx.ErrNoRows || err == nil && authorID == nil {
+		return 0, nil, ErrReportTargetNotFound
+	}
+
+	if err != nil {
+		return 0, nil, fmt.Errorf("could not query select moderation case target author: %v", err)
+	}
+
+	return *authorID, postID, nil
+}
+
+func removeCaseTarget(ctx context.Context, tx pgx.Tx, uid int64, c ModerationCase, reason string) error {
+	if c.TargetType != TargetPost && c.TargetType != TargetComment {
+		return ErrInvalidModerationAction
+	}
+
+	before, _, err := contentSnapshot(ctx, tx, c.TargetType, c.TargetID)
+	if err != nil {
+		return err
+	}
+
+	action := ModActionRemovePost
+	if c.TargetType == TargetPost {
+		query := "UPDATE posts SET deleted_at = NOW(), deleted_by = $2 WHERE id = $1 AND deleted_at IS NULL"
+		if _, err = tx.Exec(ctx, query, c.TargetID, uid); err != nil {
+			return fmt.Errorf("could not remove post: %v", err)
+		}
+	} else {
+		action = ModActionRemoveComment
+		if err = removeComment(ctx, tx, c.TargetID, uid); err != nil {
+			return err
+		}
+	}
+
+	return logContentAction(ctx, tx, uid, action, c.TargetType, c.TargetID, reason, before)
+}
+
+func warnCaseTarget(ctx context.Context, tx pgx.Tx, uid int64, c ModerationCase, reason string) (*Notification, error) {
+	authorID, postID, err := caseTargetAuthor(ctx, tx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	err = logModAction(ctx, tx, modAction{
+		actorID:     uid,
+		action:      ModActionWarnUser,
+		targetType:  TargetUser,
+		targetID:    authorID,
+		communityID: c.CommunityID,
+		reason:      reason,
+		after:       map[string]interface{}{"case_id": c.ID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var commentID *int64
+	if c.TargetType == TargetComment {
+		commentID = &c.TargetID
+	}
+
+	return notifySystem(ctx, tx, authorID, NotificationWarning, postID, commentID)
+}
+
+func suspendCaseTarget(ctx context.Context, tx pgx.Tx, uid int64, c ModerationCase, in ResolveInput) error {
+	if in.SuspendDays <= 0 || in.SuspendDays > maxSuspensionDays {
+		return ErrInvalidModerationAction
+	}
+
+	authorID, _, err := caseTargetAuthor(ctx, tx, c)
+	if err != nil {
+		return err
+	}
+
+	if err = checkSuspendable(ctx, tx, uid, authorID); err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().AddDate(0, 0, in.SuspendDays)
+	return suspendUser(ctx, tx, authorID, uid, in.Note, &expiresAt)
+}
@@ -0,0 +1,381 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	TargetCommunity      = "community"
+	TargetAppeal         = "appeal"
+	TargetModerationCase = "moderation_case"
+
+	ModActionRemovePost         = "remove_post"
+	ModActionRemoveComment      = "remove_comment"
+	ModActionEditComment        = "edit_comment"
+	ModActionDismissReports     = "dismiss_reports"
+	ModActionWarnUser           = "warn_user"
+	ModActionSuspendUser        = "suspend_user"
+	ModActionBanUser            = "ban_user"
+	ModActionLiftSuspension     = "lift_suspension"
+	ModActionResolveAppeal      = "resolve_appeal"
+	ModActionSetRole            = "set_role"
+	ModActionUpdateCommunity    = "update_community"
+	ModActionSetCommunityRole   = "set_community_role"
+	ModActionResolveJoinRequest = "resolve_join_request"
+	ModActionCreateInvite       = "create_invite"
+	ModActionClaimCase          = "claim_case"
+)
+
+var (
+	ErrInvalidRole         = errors.New("invalid role")
+	ErrInvalidModLogFilter = errors.New("invalid moderation log filter")
+)
+
+var roles = map[string]bool{
+	RoleUser:      true,
+	RoleModerator: true,
+	RoleAdmin:     true,
+}
+
+// ModLogEntry is a privileged action as recorded in the append-only moderation log.
+// Before and After are JSON snapshots of the target, when they apply.
+type ModLogEntry struct {
+	ID          int64           `json:"id"`
+	ActorID     int64           `json:"actor_id"`
+	Actor       string          `json:"actor,omitempty"`
+	Action      string          `json:"action"`
+	TargetType  string          `json:"target_type"`
+	TargetID    int64           `json:"target_id"`
+	CommunityID *int64          `json:"community_id,omitempty"`
+	Reason      string          `json:"reason,omitempty"`
+	Before      json.RawMessage `json:"before,omitempty"`
+	After       json.RawMessage `json:"after,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// ModLogFilter narrows the moderation log. Zero fields match everything.
+type ModLogFilter struct {
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   int64
+	Community  string
+	From       *time.Time
+	To         *time.Time
+}
+
+// modAction is a privileged action to log, done by actorID on a target.
+type modAction struct {
+	actorID     int64
+	action      string
+	targetType  string
+	targetID    int64
+	communityID *int64
+	reason      string
+	before      interface{}
+	after       interface{}
+}
+
+// logModAction appends an action to the moderation log,
+// inside the transaction doing it so neither happens without the other.
+func logModAction(ctx context.Context, tx pgx.Tx, a modAction) error {
+	before, err := modLogSnapshot(a.before)
+	if err != nil {
+		return err
+	}
+
+	after, err := modLogSnapshot(a.after)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO mod_log (actor_id, action, target_type, target_id, community_id, reason, before, after)
+		VALUES ($1, $2, $3, $4, $5, $6, $7::JSONB, $8::JSONB)`
+	_, err = tx.Exec(ctx, query, a.actorID, a.action, a.targetType, a.targetID, a.communityID, a.reason, before, after)
+	if err != nil {
+		return fmt.Errorf("could not insert moderation log entry: %v", err)
+	}
+
+	return nil
+}
+
+func modLogSnapshot(v interface{}) (*string, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal moderation log snapshot: %v", err)
+	}
+
+	s := string(b)
+	return &s, nil
+}
+
+// contentSnapshot gets the current state of a post or comment for the moderation log,
+// along with its community if any.
+func contentSnapshot(ctx context.Context, db querier, targetType string, targetID int64) (map[string]interface{}, *int64, error) {
+	var userID, communityID *int64
+	var deleted bool
+	switch targetType {
+	case TargetPost:
+		var title, body, link *string
+		query := "SELECT user_id, community_id, title, body, link, deleted_at IS NOT NULL FROM posts WHERE id = $1"
+		err := db.QueryRow(ctx, query, targetID).Scan(&userID, &communityID, &title, &body, &link, &deleted)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not query select post snapshot: %v", err)
+		}
+
+		return map[string]interface{}{
+			"user_id": userID,
+			"title":   title,
+			"body":    body,
+			"link":    link,
+			"deleted": deleted,
+		}, communityID, nil
+	case TargetComment:
+		var content string
+		query := `SELECT c.user_id, posts.community_id, c.content, c.deleted_at IS NOT NULL
+			FROM post_comments c
+			INNER JOIN posts ON posts.id = c.post_id
+			WHERE c.id = $1`
+		err := db.QueryRow(ctx, query, targetID).Scan(&userID, &communityID, &content, &deleted)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not query select comment snapshot: %v", err)
+		}
+
+		return map[string]interface{}{
+			"user_id": userID,
+			"content": content,
+			"deleted": deleted,
+		}, communityID, nil
+	}
+
+	return nil, nil, nil
+}
+
+// logContentAction logs a moderator action changing a post or comment,
+// with before the snapshot taken ahead of the change.
+func logContentAction(
+	ctx context.Context,
+	tx pgx.Tx,
+	actorID int64,
+	action string,
+	targetType string,
+	targetID int64,
+	reason string,
+	before map[string]interface{},
+) error {
+	after, communityID, err := contentSnapshot(ctx, tx, targetType, targetID)
+	if err != nil {
+		return err
+	}
+
+	return logModAction(ctx, tx, modAction{
+		actorID:     actorID,
+		action:      action,
+		targetType:  targetType,
+		targetID:    targetID,
+		communityID: communityID,
+		reason:      reason,
+		before:      before,
+		after:       after,
+	})
+}
+
+// SetUserRole changes the site wide role of a user. Only admins can,
+// and they cannot change their own role.
+func (s *Service) SetUserRole(ctx context.Context, username, role string) error {
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return ErrUnauthenticated
+	}
+
+	if !roles[role] {
+		return ErrInvalidRole
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	actorRole, err := userRole(ctx, tx, uid)
+	if err != nil {
+		return err
+	}
+
+	if actorRole != RoleAdmin {
+		return ErrForbidden
+	}
+
+	var userID int64
+	var prev string
+	query := "SELECT id, role FROM users WHERE username = $1 FOR UPDATE"
+	err = tx.QueryRow(ctx, query, strings.TrimSpace(username)).Scan(&userID, &prev)
+	if err == pgx.ErrNoRows {
+		return ErrUserNotFound
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not query select user role: %v", err)
+	}
+
+	if userID == uid {
+		return ErrForbidden
+	}
+
+	if prev == role {
+		return nil
+	}
+
+	query = "UPDATE users SET role = $2 WHERE id = $1"
+	if _, err = tx.Exec(ctx, query, userID, role); err != nil {
+		return fmt.Errorf("could not update user role: %v", err)
+	}
+
+	err = logModAction(ctx, tx, modAction{
+		actorID:    uid,
+		action:     ModActionSetRole,
+		targetType: TargetUser,
+		targetID:   userID,
+		before:     map[string]string{"role": prev},
+		after:      map[string]string{"role": role},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("could not commit user role change: %v", err)
+	}
+
+	return nil
+}
+
+// ModLog gets a page of the moderation log, most recent first. Only admins can.
+func (s *Service) ModLog(ctx context.Context, f ModLogFilter, after string, first int) (Page[ModLogEntry], error) {
+	var page Page[ModLogEntry]
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return page, ErrUnauthenticated
+	}
+
+	role, err := userRole(ctx, s.Db, uid)
+	if err != nil {
+		return page, err
+	}
+
+	if role != RoleAdmin {
+		return page, ErrForbidden
+	}
+
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if actor := strings.TrimSpace(f.Actor); actor != "" {
+		where = append(where, "mod_log.actor_id = (SELECT id FROM users WHERE username = "+arg(actor)+")")
+	}
+
+	if f.Action != "" {
+		where = append(where, "mod_log.action = "+arg(f.Action))
+	}
+
+	if f.TargetType != "" {
+		where = append(where, "mod_log.target_type = "+arg(f.TargetType))
+	}
+
+	if f.TargetID != 0 {
+		if f.TargetType == "" {
+			return page, ErrInvalidModLogFilter
+		}
+
+		where = append(where, "mod_log.target_id = "+arg(f.TargetID))
+	}
+
+	if f.Community != "" {
+		id, err := communityID(ctx, s.Db, f.Community, "")
+		if err != nil {
+			return page, err
+		}
+
+		where = append(where, "mod_log.community_id = "+arg(id))
+	}
+
+	if f.From != nil {
+		where = append(where, "mod_log.created_at >= "+arg(*f.From))
+	}
+
+	if f.To != nil {
+		where = append(where, "mod_log.created_at < "+arg(*f.To))
+	}
+
+	if after != "" {
+		createdAt, id, err := decodeTimeCursor(after)
+		if err != nil {
+			return page, err
+		}
+
+		where = append(where, "(mod_log.created_at, mod_log.id) < ("+arg(createdAt)+", "+arg(id)+")")
+	}
+
+	first = normalizePageSize(first)
+	query := `SELECT mod_log.id, mod_log.actor_id, COALESCE(users.username, ''), mod_log.action,
+		mod_log.target_type, mod_log.target_id, mod_log.community_id, mod_log.reason,
+		mod_log.before, mod_log.after, mod_log.created_at
+		FROM mod_log
+		LEFT JOIN users ON users.id = mod_log.actor_id`
+	if len(where) != 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	query += " ORDER BY mod_log.created_at DESC, mod_log.id DESC LIMIT " + strconv.Itoa(first+1)
+
+	rows, err := s.Db.Query(ctx, query, args...)
+	if err != nil {
+		return page, fmt.Errorf("could not sql query moderation log: %v", err)
+	}
+
+	defer rows.Close()
+
+	ee := []ModLogEntry{}
+	for rows.Next() {
+		var e ModLogEntry
+		var before, after []byte
+		err = rows.Scan(&e.ID, &e.ActorID, &e.Actor, &e.Action, &e.TargetType, &e.TargetID,
+			&e.CommunityID, &e.Reason, &before, &after, &e.CreatedAt)
+		if err != nil {
+			return page, fmt.Errorf("could not iterate over moderation log: %v", err)
+		}
+
+		e.Before = before
+		e.After = after
+		ee = append(ee, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return page, fmt.Errorf("could not iterate over moderation log: %v", err)
+	}
+
+	page = newPage(ee, first, func(e ModLogEntry) string {
+		return encodeTimeCursor(e.CreatedAt, e.ID)
+	})
+
+	return page, nil
+}
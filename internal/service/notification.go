@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	NotificationPostComment  = "post_comment"
+	NotificationCommentReply = "comment_reply"
+	NotificationMention      = "mention"
+	NotificationFollow       = "follow"
+	NotificationPostUpvote   = "post_upvote"
+	NotificationCommentVote  = "comment_upvote"
+	NotificationRepost       = "repost"
+	NotificationQuote        = "quote"
+	// sent by moderators, without actors
+	NotificationWarning = "warning"
+)
+
+var (
+	ErrNotificationNotFound = errors.New("notification not found")
+)
+
+// notificationColumns lists the notifications columns read by Notification.scanDest, in order.
+// Only the usernames of the 3 latest actors are listed.
+const notificationColumns = `notifications.id, notifications.user_id, notifications.type,
+	notifications.post_id, notifications.comment_id,
+	ARRAY(
+		SELECT users.username FROM unnest(notifications.actors) WITH ORDINALITY AS a(id, n)
+		INNER JOIN users ON users.id = a.id
+		ORDER BY a.n DESC LIMIT 3
+	),
+	cardinality(notifications.actors), notifications.read_at IS NOT NULL, notifications.issued_at`
+
+// Notification model
+// Events of the same type on the same target are aggregated into a single
+// notification while it is unread, e.g. "alice and 4 others upvoted your post".
+type Notification struct {
+	ID          int64     `json:"id"`
+	UserID      int64     `json:"-"`
+	Type        string    `json:"type"`
+	PostID      *int64    `json:"post_id,omitempty"`
+	CommentID   *int64    `json:"comment_id,omitempty"`
+	Actors      []string  `json:"actors"`
+	ActorsCount int       `json:"actors_count"`
+	Read        bool      `json:"read"`
+	IssuedAt    time.Time `json:"issued_at"`
+}
+
+func (n *Notification) scanDest() []interface{} {
+	return []interface{}{
+		&n.ID,
+		&n.UserID,
+		&n.Type,
+		&n.PostID,
+		&n.CommentID,
+		&n.Actors,
+		&n.ActorsCount,
+		&n.Read,
+		&n.IssuedAt,
+	}
+}
+
+// NotificationPage is a page of notifications along with the unread count.
+type NotificationPage struct {
+	Page[Notification]
+	UnreadCount int64 `json:"unread_count"`
+}
+
+// notify records that actorID did an event of type typ concerning userID,
+// merging it into the unread notification of the same type and target if any.
+// It returns nil when users act on their own content or the actor is shadowbanned.
+// The notification must be broadcasted once the transaction of db commits.
+func notify(
+	ctx context.Context,
+	db querier,
+	userID int64,
+	actorID int64,
+	typ string,
+	postID *int64,
+	commentID *int64,
+) (*Notification, error) {
+	if userID == actorID {
+		return nil, nil
+	}
+
+	n := &Notification{}
+	query := `INSERT INTO notifications (user_id, type, post_id, comment_id, actors)
+		SELECT $1, $2, $3, $4, ARRAY[$5::INT]
+		WHERE NOT EXISTS (SELECT 1 FROM users WHERE id = $5 AND shadowbanned)
+		ON CONFLICT (user_id, type, COALESCE(post_id, 0), COALESCE(comment_id, 0)) WHERE read_at IS NULL
+		DO UPDATE SET
+			actors = array_append(array_remove(notifications.actors, $5::INT), $5::INT),
+			issued_at = NOW()
+		RETURNING ` + notificationColumns
+	err := db.QueryRow(ctx, query, userID, typ, postID, commentID, actorID).Scan(n.scanDest()...)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not upsert notification: %v", err)
+	}
+
+	return n, nil
+}
+
+// notifySystem records a notification with no actor, such as a moderator warning.
+func notifySystem(
+	ctx context.Context,
+	db querier,
+	userID int64,
+	typ string,
+	postID *int64,
+	commentID *int64,
+) (*Notification, error) {
+	n := &Notification{}
+	query := `INSERT INTO notifications (user_id, type, post_id, comment_id, actors)
+		VALUES ($1, $2, $3, $4, '{}')
+		ON CONFLICT (user_id, type, COALESCE(post_id, 0), COALESCE(comment_id, 0)) WHERE read_at IS NULL
+		DO UPDATE SET issued_at = NOW()
+		RETURNING ` + notificationColumns
+	err := db.QueryRow(ctx, query, userID, typ, postID, commentID).Scan(n.scanDest()...)
+	if err != nil {
+		return nil, fmt.Errorf("could not upsert system notification: %v", err)
+	}
+
+	return n, nil
+}
+
+// Notifications gets a page of the authenticated user notifications,
+// most recently updated first.
+func (s *Service) Notifications(ctx context.Context, after string, first int) (NotificationPage, error) {
+	var page NotificationPage
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return page, ErrUnauthenticated
+	}
+
+	first = normalizePageSize(first)
+
+	query := "SELECT " + notificationColumns + " FROM notifications WHERE user_id = $1"
+	args := []interface{}{uid}
+
+	if after != "" {
+		issuedAt, id, err := decodeTimeCursor(after)
+		if err != nil {
+			return page, err
+		}
+
+		query += " AND (issued_at, id) < ($2, $3)"
+		args = append(args, issuedAt, id)
+	}
+
+	query += " ORDER BY issued_at DESC, id DESC LIMIT " + strconv.Itoa(first+1)
+
+	rows, err := s.Db.Query(ctx, query, args...)
+	if err != nil {
+		return page, fmt.Errorf("could not sql query notifications: %v", err)
+	}
+
+	defer rows.Close()
+
+	nn := []Notification{}
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(n.scanDest()...); err != nil {
+			return page, fmt.Errorf("could not iterate over notifications: %v", err)
+		}
+
+		nn = append(nn, n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return page, fmt.Errorf("could not iterate over notifications: %v", err)
+	}
+
+	page.Page = newPage(nn, first, func(n Notification) string {
+		return encodeTimeCursor(n.IssuedAt, n.ID)
+	})
+
+	query = "SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL"
+	if err = s.Db.QueryRow(ctx, query, uid).Scan(&page.UnreadCount); err != nil {
+		return page, fmt.Errorf("could not sql query unread notifications count: %v", err)
+	}
+
+	return page, nil
+}
+
+// MarkNotificationAsRead marks a notification of the authenticated user as read.
+func (s *Service) MarkNotificationAsRead(ctx context.Context, notificationID int64) error {
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return ErrUnauthenticated
+	}
+
+	var id int64
+	query := `UPDATE notifications SET read_at = COALESCE(read_at, NOW())
+		WHERE id = $1 AND user_id = $2 RETURNING id`
+	err := s.Db.QueryRow(ctx, query, notificationID, uid).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return ErrNotificationNotFound
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not update notification read status: %v", err)
+	}
+
+	return nil
+}
+
+// MarkNotificationsAsRead marks every notification of the authenticated user as read.
+func (s *Service) MarkNotificationsAsRead(ctx context.Context) error {
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return ErrUnauthenticated
+	}
+
+	query := "UPDATE notifications SET read_at = NOW() WHERE user_id = $1 AND read_at IS NULL"
+	if _, err := s.Db.Exec(ctx, query, uid); err != nil {
+		return fmt.Errorf("could not update notifications read status: %v", err)
+	}
+
+	return nil
+}
+
+// broadcastNotifications sends committed notifications to their live subscribers.
+func (s *Service) broadcastNotifications(nn ...*Notification) {
+	for _, n := range nn {
+		if n != nil {
+			s.broadcast(n.UserID, LiveEvent{Type: LiveNotification, Notification: n})
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var (
+	// ErrInvalidCursor used when a pagination cursor cannot be decoded
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// Page is one slice of a cursor-paginated listing.
+// NextCursor is empty when there are no more items.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// encodeCursor builds an opaque cursor out of a sort key and a row id
+// used as tie breaker.
+func encodeCursor(key string, id int64) string {
+	s := key + "," + strconv.FormatInt(id, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func decodeCursor(s string) (string, int64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return "", 0, ErrInvalidCursor
+	}
+
+	i := strings.LastIndexByte(string(b), ',')
+	if i == -1 {
+		return "", 0, ErrInvalidCursor
+	}
+
+	id, err := strconv.ParseInt(string(b[i+1:]), 10, 64)
+	if err != nil {
+		return "", 0, ErrInvalidCursor
+	}
+
+	return string(b[:i]), id, nil
+}
+
+func encodeTimeCursor(t time.Time, id int64) string {
+	return encodeCursor(t.UTC().Format(time.RFC3339Nano), id)
+}
+
+func decodeTimeCursor(s string) (time.Time, int64, error) {
+	key, id, err := decodeCursor(s)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, key)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+
+	return t, id, nil
+}
+
+func normalizePageSize(first int) int {
+	if first <= 0 {
+		return defaultPageSize
+	}
+
+	if first > maxPageSize {
+		return maxPageSize
+	}
+
+	return first
+}
+
+// newPage trims the extra row fetched to detect a following page
+// and derives the next cursor from the last returned item.
+func newPage[T any](items []T, first int, cursorOf func(T) string) Page[T] {
+	p := Page[T]{Items: items}
+	if len(items) > first {
+		p.Items = items[:first]
+		p.NextCursor = cursorOf(p.Items[first-1])
+	}
+
+	return p
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeCursor(t *testing.T) {
+	t.Run("should round trip created_at and id", func(t *testing.T) {
+		createdAt := time.Date(2023, 1, 2, 3, 4, 5, 6, time.UTC)
+		gotTime, gotID, err := decodeTimeCursor(encodeTimeCursor(createdAt, 42))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !gotTime.Equal(createdAt) || gotID != 42 {
+			t.Errorf("expected (%v, 42), got (%v, %d)", createdAt, gotTime, gotID)
+		}
+	})
+
+	t.Run("should reject garbage", func(t *testing.T) {
+		if _, _, err := decodeTimeCursor("not a cursor"); err != ErrInvalidCursor {
+			t.Errorf("expected ErrInvalidCursor, got %v", err)
+		}
+	})
+}
+
+func TestNewPage(t *testing.T) {
+	t.Run("should set next cursor only when there is an extra item", func(t *testing.T) {
+		cursorOf := func(i int) string { return encodeCursor("", int64(i)) }
+
+		p := newPage([]int{1, 2, 3}, 2, cursorOf)
+		if len(p.Items) != 2 || p.NextCursor != cursorOf(2) {
+			t.Errorf("expected 2 items and a cursor at 2, got %v %q", p.Items, p.NextCursor)
+		}
+
+		p = newPage([]int{1, 2}, 2, cursorOf)
+		if len(p.Items) != 2 || p.NextCursor != "" {
+			t.Errorf("expected 2 items and no cursor, got %v %q", p.Items, p.NextCursor)
+		}
+	})
+}
@@ -0,0 +1,284 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	minPollOptions     = 2
+	maxPollOptions     = 10
+	maxPollQuestionLen = 300
+	maxPollOptionLen   = 100
+)
+
+var (
+	ErrInvalidPoll       = errors.New("invalid poll")
+	ErrPollNotFound      = errors.New("post has no poll")
+	ErrPollClosed        = errors.New("poll is closed")
+	ErrInvalidPollOption = errors.New("invalid poll option")
+	ErrAlreadyVoted      = errors.New("already voted on this poll")
+)
+
+// PollInput describes a poll to create along with a post
+type PollInput struct {
+	Question string
+	Options  []string
+	Multiple bool
+	ClosesAt *time.Time
+}
+
+// Poll model
+type Poll struct {
+	Question string       `json:"question"`
+	Options  []PollOption `json:"options"`
+	Multiple bool         `json:"multiple"`
+	ClosesAt *time.Time   `json:"closes_at,omitempty"`
+	Voters   int64        `json:"voters"`
+	// Voted holds the option ids chosen by the authenticated user
+	Voted []int64 `json:"voted"`
+}
+
+// PollOption model
+type PollOption struct {
+	ID    int64  `json:"id"`
+	Text  string `json:"text"`
+	Votes int64  `json:"votes"`
+}
+
+func (in *PollInput) validate() error {
+	in.Question = strings.TrimSpace(in.Question)
+	if in.Question == "" || len([]rune(in.Question)) > maxPollQuestionLen {
+		return ErrInvalidPoll
+	}
+
+	if len(in.Options) < minPollOptions || len(in.Options) > maxPollOptions {
+		return ErrInvalidPoll
+	}
+
+	seen := make(map[string]bool, len(in.Options))
+	for i, o := range in.Options {
+		o = strings.TrimSpace(o)
+		if o == "" || len([]rune(o)) > maxPollOptionLen {
+			return ErrInvalidPoll
+		}
+
+		k := strings.ToLower(o)
+		if seen[k] {
+			return ErrInvalidPoll
+		}
+
+		seen[k] = true
+		in.Options[i] = o
+	}
+
+	if in.ClosesAt != nil && !in.ClosesAt.After(time.Now()) {
+		return ErrInvalidPoll
+	}
+
+	return nil
+}
+
+// createPoll inserts an already validated poll for a post inside tx.
+func createPoll(ctx context.Context, tx pgx.Tx, postID int64, in PollInput) (*Poll, error) {
+	p := &Poll{
+		Question: in.Question,
+		Multiple: in.Multiple,
+		ClosesAt: in.ClosesAt,
+		Voted:    []int64{},
+	}
+
+	query := "INSERT INTO polls (post_id, question, multiple, closes_at) VALUES ($1, $2, $3, $4)"
+	if _, err := tx.Exec(ctx, query, postID, in.Question, in.Multiple, in.ClosesAt); err != nil {
+		return nil, fmt.Errorf("could not insert poll: %v", err)
+	}
+
+	query = "INSERT INTO poll_options (post_id, position, text) VALUES ($1, $2, $3) RETURNING id"
+	for i, text := range in.Options {
+		o := PollOption{Text: text}
+		if err := tx.QueryRow(ctx, query, postID, i, text).Scan(&o.ID); err != nil {
+			return nil, fmt.Errorf("could not insert poll option: %v", err)
+		}
+
+		p.Options = append(p.Options, o)
+	}
+
+	return p, nil
+}
+
+// polls loads the polls of the given posts with their tallies
+// and the options voted by uid, keyed by post id.
+func (s *Service) polls(ctx context.Context, uid int64, postIDs []int64) (map[int64]*Poll, error) {
+	pp := map[int64]*Poll{}
+
+	query := "SELECT post_id, question, multiple, closes_at, voters FROM polls WHERE post_id = ANY($1)"
+	rows, err := s.Db.Query(ctx, query, postIDs)
+	if err != nil {
+		return nil, fmt.Errorf("could not sql query polls: %v", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID int64
+		p := &Poll{Voted: []int64{}}
+		if err := rows.Scan(&postID, &p.Question, &p.Multiple, &p.ClosesAt, &p.Voters); err != nil {
+			return nil, fmt.Errorf("could not iterate over polls: %v", err)
+		}
+
+		pp[postID] = p
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over polls: %v", err)
+	}
+
+	if len(pp) == 0 {
+		return pp, nil
+	}
+
+	query = "SELECT post_id, id, text, votes FROM poll_options WHERE post_id = ANY($1) ORDER BY post_id, position"
+	rows, err = s.Db.Query(ctx, query, postIDs)
+	if err != nil {
+		return nil, fmt.Errorf("could not sql query poll options: %v", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID int64
+		var o PollOption
+		if err := rows.Scan(&postID, &o.ID, &o.Text, &o.Votes); err != nil {
+			return nil, fmt.Errorf("could not iterate over poll options: %v", err)
+		}
+
+		if p, ok := pp[postID]; ok {
+			p.Options = append(p.Options, o)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over poll options: %v", err)
+	}
+
+	query = "SELECT post_id, option_id FROM poll_votes WHERE user_id = $1 AND post_id = ANY($2)"
+	rows, err = s.Db.Query(ctx, query, uid, postIDs)
+	if err != nil {
+		return nil, fmt.Errorf("could not sql query poll votes: %v", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID, optionID int64
+		if err := rows.Scan(&postID, &optionID); err != nil {
+			return nil, fmt.Errorf("could not iterate over poll votes: %v", err)
+		}
+
+		if p, ok := pp[postID]; ok {
+			p.Voted = append(p.Voted, optionID)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over poll votes: %v", err)
+	}
+
+	return pp, nil
+}
+
+// VotePoll casts the authenticated user ballot on the poll of a post.
+// Single choice polls take exactly one option. A user votes only once.
+func (s *Service) VotePoll(ctx context.Context, postID int64, optionIDs []int64) (Poll, error) {
+	var p Poll
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return p, ErrUnauthenticated
+	}
+
+	optionIDs = uniqueInt64s(optionIDs)
+	if len(optionIDs) == 0 {
+		return p, ErrInvalidPollOption
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return p, fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	if err = canParticipateInPost(ctx, tx, uid, postID); err != nil {
+		if err == ErrInvalidPostID {
+			return p, ErrPollNotFound
+		}
+		return p, err
+	}
+
+	// Locking the poll makes the already voted check below race free.
+	query := "SELECT multiple, closes_at FROM polls WHERE post_id = $1 FOR UPDATE"
+	err = tx.QueryRow(ctx, query, postID).Scan(&p.Multiple, &p.ClosesAt)
+	if err == pgx.ErrNoRows {
+		return p, ErrPollNotFound
+	}
+
+	if err != nil {
+		return p, fmt.Errorf("could not query select poll: %v", err)
+	}
+
+	if p.ClosesAt != nil && !p.ClosesAt.After(time.Now()) {
+		return p, ErrPollClosed
+	}
+
+	if !p.Multiple && len(optionIDs) != 1 {
+		return p, ErrInvalidPollOption
+	}
+
+	var voted bool
+	query = "SELECT EXISTS (SELECT 1 FROM poll_votes WHERE user_id = $1 AND post_id = $2)"
+	if err = tx.QueryRow(ctx, query, uid, postID).Scan(&voted); err != nil {
+		return p, fmt.Errorf("could not query select poll vote existence: %v", err)
+	}
+
+	if voted {
+		return p, ErrAlreadyVoted
+	}
+
+	query = `INSERT INTO poll_votes (user_id, post_id, option_id)
+		SELECT $1, post_id, id FROM poll_options WHERE post_id = $2 AND id = ANY($3)`
+	tag, err := tx.Exec(ctx, query, uid, postID, optionIDs)
+	if err != nil {
+		return p, fmt.Errorf("could not insert poll votes: %v", err)
+	}
+
+	if tag.RowsAffected() != int64(len(optionIDs)) {
+		return p, ErrInvalidPollOption
+	}
+
+	query = "UPDATE poll_options SET votes = votes + 1 WHERE post_id = $1 AND id = ANY($2)"
+	if _, err = tx.Exec(ctx, query, postID, optionIDs); err != nil {
+		return p, fmt.Errorf("could not update poll option tallies: %v", err)
+	}
+
+	query = "UPDATE polls SET voters = voters + 1 WHERE post_id = $1"
+	if _, err = tx.Exec(ctx, query, postID); err != nil {
+		return p, fmt.Errorf("could not update poll voters: %v", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return p, fmt.Errorf("could not commit poll vote: %v", err)
+	}
+
+	pp, err := s.polls(ctx, uid, []int64{postID})
+	if err != nil {
+		return p, err
+	}
+
+	return *pp[postID], nil
+}
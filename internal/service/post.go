@@ -2,13 +2,13 @@ package service
 
 import (
 	"context"
-	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/jackc/pgx/v4"
 )
 
 var (
@@ -17,30 +17,164 @@ var (
 	ErrInvalidLink   = errors.New("invalid link")
 	ErrNoContent     = errors.New("error: no content to post")
 	ErrInvalidPostID = errors.New("error: no such post id exists")
+	// ErrInvalidQuotedPost used when quoting a post the user cannot see
+	ErrInvalidQuotedPost = errors.New("invalid quoted post")
 )
 
 // Post Model
 type Post struct {
 	ID        int64          `json:"id,omitempty"`
 	UserID    int64          `json:"user_id,omitempty"`
+	CommunityID *int64       `json:"community_id,omitempty"`
+	Community string         `json:"community,omitempty"`
 	Title     string         `json:"title,omitempty"`
 	Body      string         `json:"body,omitempty"`
 	Link      string         `json:"link,omitempty"`
-	Album     sql.NullString `json:"album,omitempty"`
-	Poll      sql.NullString `json:"poll,omitempty"`
+	Album     []Media        `json:"album,omitempty"`
+	Preview   *LinkPreview   `json:"link_preview,omitempty"`
+	Poll      *Poll          `json:"poll,omitempty"`
 	Upvotes   int64          `json:"upvotes,omitempty"`
 	Downvotes int64          `json:"downvotes,omitempty"`
 	CreatedAt time.Time      `json:"created_at,omitempty"`
 	User      *User          `json:"user,omitempty"`
+	// Saved is whether the authenticated user saved the post
+	Saved bool `json:"saved"`
+	// QuotedPost is nil when the quoted post got removed or cannot be seen by the user
+	QuotedPostID *int64 `json:"quoted_post_id,omitempty"`
+	QuotedPost   *Post  `json:"quoted_post,omitempty"`
+	RepostsCount int64  `json:"reposts_count"`
+	QuotesCount  int64  `json:"quotes_count"`
+	// Reposted is whether the authenticated user reposted the post
+	Reposted bool `json:"reposted"`
+}
+
+// postColumns lists the posts columns read by Post.scanDest, in order.
+const postColumns = "posts.id, posts.user_id, posts.community_id, posts.title, posts.body, posts.link, " +
+	"posts.upvotes, posts.downvotes, posts.created_at, posts.quoted_post_id, posts.reposts_count, posts.quotes_count"
+
+func (p *Post) scanDest() []interface{} {
+	return []interface{}{
+		&p.ID,
+		&p.UserID,
+		&p.CommunityID,
+		&p.Title,
+		&p.Body,
+		&p.Link,
+		&p.Upvotes,
+		&p.Downvotes,
+		&p.CreatedAt,
+		&p.QuotedPostID,
+		&p.RepostsCount,
+		&p.QuotesCount,
+	}
+}
+
+// hydratePosts loads the data of each post that lives outside the posts
+// table, as seen by the user uid, along with the posts they quote.
+func (s *Service) hydratePosts(ctx context.Context, uid int64, pp ...*Post) error {
+	if err := s.hydratePostData(ctx, uid, pp...); err != nil {
+		return err
+	}
+
+	return s.hydrateQuotedPosts(ctx, uid, pp...)
+}
+
+// hydratePostData loads the data of each post that lives outside the posts table,
+// without the posts they quote.
+func (s *Service) hydratePostData(ctx context.Context, uid int64, pp ...*Post) error {
+	if len(pp) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(pp))
+	for i, p := range pp {
+		ids[i] = p.ID
+	}
+
+	polls, err := s.polls(ctx, uid, ids)
+	if err != nil {
+		return err
+	}
+
+	albums, err := albums(ctx, s.Db, ids)
+	if err != nil {
+		return err
+	}
+
+	var links []string
+	for _, p := range pp {
+		if p.Link != "" {
+			links = append(links, p.Link)
+		}
+	}
+
+	previews, err := linkPreviews(ctx, s.Db, links)
+	if err != nil {
+		return err
+	}
+
+	var communityIDs []int64
+	for _, p := range pp {
+		if p.CommunityID != nil {
+			communityIDs = append(communityIDs, *p.CommunityID)
+		}
+	}
+
+	communities, err := communityNames(ctx, s.Db, uniqueInt64s(communityIDs))
+	if err != nil {
+		return err
+	}
+
+	votes, err := uncountedPostVotes(ctx, s.Db, uid, ids)
+	if err != nil {
+		return err
+	}
+
+	saved, err := savedPosts(ctx, s.Db, uid, ids)
+	if err != nil {
+		return err
+	}
+
+	reposted, err := repostedPosts(ctx, s.Db, uid, ids)
+	if err != nil {
+		return err
+	}
+
+	if err = visibleShareCounts(ctx, s.Db, uid, pp...); err != nil {
+		return err
+	}
+
+	for _, p := range pp {
+		p.Saved = saved[p.ID]
+		p.Reposted = reposted[p.ID]
+		if value, ok := votes[p.ID]; ok {
+			up, down := voteDeltas(0, value)
+			p.Upvotes += int64(up)
+			p.Downvotes += int64(down)
+		}
+		if p.CommunityID != nil {
+			p.Community = communities[*p.CommunityID]
+		}
+		p.Poll = polls[p.ID]
+		p.Album = albums[p.ID]
+		p.Preview = previews[p.Link]
+	}
+
+	return nil
 }
 
+// CreatePost posts in the community with the given name,
+// or outside of any community when empty.
+// A non nil quotedPostID makes it a quote of that post.
 func (s *Service) CreatePost(
 	ctx context.Context,
+	community string,
 	title string,
 	body string,
 	link string,
-	album string,
-	poll string,
+	album []int64,
+	poll *PollInput,
+	quotedPostID *int64,
 ) (TimelineItem, error) {
 	var ti TimelineItem
 
@@ -60,30 +194,26 @@ func (s *Service) CreatePost(
 	}
 
 	link = strings.TrimSpace(link)
-	if len([]rune(link)) > 480 {
+	if len([]rune(link)) > 480 || link != "" && !validLink(link) {
 		return ti, ErrInvalidLink
 	}
 
-	var albumJSONB json.RawMessage
-	if album == "" {
-		albumJSONB = nil
-	} else {
-		err := json.Unmarshal([]byte(album), &albumJSONB)
-		if err != nil {
-			return ti, fmt.Errorf("error converting album string to jsonb: %v", err)
-		}
+	if err := validateAlbum(album); err != nil {
+		return ti, err
 	}
 
-	var pollJSONB json.RawMessage
-	if poll == "" {
-		pollJSONB = nil
-	} else {
-		err := json.Unmarshal([]byte(poll), &pollJSONB)
-		if err != nil {
-			return ti, fmt.Errorf("error converting poll string to jsonb: %v", err)
+	if poll != nil {
+		if err := poll.validate(); err != nil {
+			return ti, err
 		}
 	}
 
+	text := title + "\n" + body
+	fr, err := s.checkContent(ctx, uid, TargetPost, text, link)
+	if err != nil {
+		return ti, err
+	}
+
 	tx, err := s.Db.Begin(ctx)
 	if err != nil {
 		return ti, fmt.Errorf("could not begin transaction: %v", err)
@@ -91,78 +221,165 @@ func (s *Service) CreatePost(
 
 	defer tx.Rollback(ctx)
 
-	var query string
-	if album == "" && poll == "" {
-		query = "INSERT INTO posts (user_id, title, body, link) VALUES ($1, $2, $3, $4) RETURNING id, created_at"
-		if err = tx.QueryRow(ctx, query, uid, title, body, link).Scan(&ti.Post.ID, &ti.Post.CreatedAt); err != nil {
-			return ti, fmt.Errorf("could not insert post: %v", err)
+	if community != "" {
+		id, err := communityID(ctx, tx, community, "FOR SHARE")
+		if err != nil {
+			return ti, err
 		}
-	} else if album == "" {
-		query = "INSERT INTO posts (user_id, title, body, link, poll) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at"
-		if err = tx.QueryRow(ctx, query, uid, title, body, link, pollJSONB).Scan(&ti.Post.ID, &ti.Post.CreatedAt); err != nil {
-			return ti, fmt.Errorf("could not insert post: %v", err)
+
+		if err = canPostInCommunity(ctx, tx, id, uid); err != nil {
+			return ti, err
 		}
-	} else if poll == "" {
-		query = "INSERT INTO posts (user_id, title, body, link, album) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at"
-		if err = tx.QueryRow(ctx, query, uid, title, body, link, albumJSONB).Scan(&ti.Post.ID, &ti.Post.CreatedAt); err != nil {
-			return ti, fmt.Errorf("could not insert post: %v", err)
+
+		names, err := communityNames(ctx, tx, []int64{id})
+		if err != nil {
+			return ti, err
 		}
-	} else {
-		query = "INSERT INTO posts (user_id, title, body, link, album, poll) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at"
-		if err = tx.QueryRow(ctx, query, uid, title, body, link, albumJSONB, pollJSONB).Scan(&ti.Post.ID, &ti.Post.CreatedAt); err != nil {
-			return ti, fmt.Errorf("could not insert post: %v", err)
+
+		ti.Post.CommunityID = &id
+		ti.Post.Community = names[id]
+	}
+
+	var quotedAuthorID int64
+	if quotedPostID != nil {
+		// locking the quoted post keeps its quotes count exact
+		query := "SELECT user_id FROM posts WHERE id = $1 AND " + visiblePostCondition("posts", "$2") + " FOR UPDATE OF posts"
+		err = tx.QueryRow(ctx, query, *quotedPostID, uid).Scan(&quotedAuthorID)
+		if err == pgx.ErrNoRows {
+			return ti, ErrInvalidQuotedPost
+		}
+
+		if err != nil {
+			return ti, fmt.Errorf("could not query select quoted post: %v", err)
+		}
+
+		query = "UPDATE posts SET quotes_count = quotes_count + 1 WHERE id = $1"
+		if _, err = tx.Exec(ctx, query, *quotedPostID); err != nil {
+			return ti, fmt.Errorf("could not update quoted post quotes count: %v", err)
 		}
 	}
 
+	query := "INSERT INTO posts (user_id, community_id, title, body, link, quoted_post_id) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at"
+	err = tx.QueryRow(ctx, query, uid, ti.Post.CommunityID, title, body, link, quotedPostID).Scan(&ti.Post.ID, &ti.Post.CreatedAt)
+	if err != nil {
+		return ti, fmt.Errorf("could not insert post: %v", err)
+	}
+
+	if len(album) != 0 {
+		if ti.Post.Album, err = attachAlbum(ctx, tx, uid, ti.Post.ID, album); err != nil {
+			return ti, err
+		}
+	}
+
+	if poll != nil {
+		if ti.Post.Poll, err = createPoll(ctx, tx, ti.Post.ID, *poll); err != nil {
+			return ti, err
+		}
+	}
+
+	if link != "" {
+		if err = queueLinkPreview(ctx, tx, link); err != nil {
+			return ti, err
+		}
+	}
+
+	if err = applyContentFilters(ctx, tx, uid, fr, TargetPost, ti.Post.ID); err != nil {
+		return ti, err
+	}
+
+	if err = saveTags(ctx, tx, "post_tags", "post_id", ti.Post.ID, extractTags(text)); err != nil {
+		return ti, err
+	}
+
+	mentioned, err := saveMentions(ctx, tx, "post_mentions", "post_id", ti.Post.ID, extractMentions(text))
+	if err != nil {
+		return ti, err
+	}
+
+	// nobody is notified of content only its author can see
+	if fr.hides() {
+		mentioned = nil
+	}
+
+	nn, err := notifyMentions(ctx, tx, uid, mentioned, ti.Post.ID, nil)
+	if err != nil {
+		return ti, err
+	}
+
+	if quotedPostID != nil && !fr.hides() {
+		n, err := notify(ctx, tx, quotedAuthorID, uid, NotificationQuote, quotedPostID, nil)
+		if err != nil {
+			return ti, err
+		}
+
+		nn = append(nn, n)
+	}
+
 	ti.Post.UserID = uid
+	ti.Post.QuotedPostID = quotedPostID
 	ti.Post.Title = title
 	ti.Post.Body = body
 	ti.Post.Link = link
 
-	ti.Post.Album.String = album
-	if album != "" {
-		ti.Post.Album.Valid = true
-	} 
-
-	ti.Post.Poll.String = poll
-	if poll != "" {
-		ti.Post.Poll.Valid = true
-	}
-
-	query = "INSERT INTO timeline (user_id, post_id) VALUES ($1, $2) RETURNING id"
-	if err = tx.QueryRow(ctx, query, uid, ti.Post.ID).Scan(&ti.ID); err != nil {
+	query = "INSERT INTO timeline (user_id, post_id) VALUES ($1, $2) RETURNING id, created_at"
+	if err = tx.QueryRow(ctx, query, uid, ti.Post.ID).Scan(&ti.ID, &ti.CreatedAt); err != nil {
 		return ti, fmt.Errorf("could not insert into timeline: %v", err)
 	}
 
 	ti.UserID = uid
 	ti.PostID = ti.Post.ID
 
-	tx.Commit(ctx)
+	if err = tx.Commit(ctx); err != nil {
+		return ti, fmt.Errorf("could not commit post: %v", err)
+	}
+
+	s.broadcastNotifications(nn...)
 
 	return ti, nil
 }
 
-// Gets all posts of a user
+// Gets a page of posts of a user, newest first
 func (s *Service) Posts(
 	ctx context.Context,
 	username string,
-) ([]Post, error) {
+	after string,
+	first int,
+) (Page[Post], error) {
+	var page Page[Post]
 
 	username = strings.TrimSpace(username)
 	if !rxUsername.MatchString(username) {
-		return nil, ErrInvalidUsername
+		return page, ErrInvalidUsername
 	}
 
 	uid, auth := ctx.Value(KeyAuthUserID).(int64)
 	if !auth {
-		return nil, ErrUnauthenticated
+		return page, ErrUnauthenticated
 	}
 
-	query := "SELECT title, body, link, album, poll FROM posts WHERE user_id = $1 ORDER BY created_at DESC"
+	first = normalizePageSize(first)
 
-	rows, err := s.Db.Query(ctx, query, uid)
+	query := "SELECT " + postColumns + `
+		FROM posts
+		INNER JOIN users ON posts.user_id = users.id
+		WHERE users.username = $1 AND ` + visiblePostCondition("posts", "$2")
+	args := []interface{}{username, uid}
+
+	if after != "" {
+		createdAt, id, err := decodeTimeCursor(after)
+		if err != nil {
+			return page, err
+		}
+
+		query += " AND (posts.created_at, posts.id) < ($3, $4)"
+		args = append(args, createdAt, id)
+	}
+
+	query += " ORDER BY posts.created_at DESC, posts.id DESC LIMIT " + strconv.Itoa(first+1)
+
+	rows, err := s.Db.Query(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("could not sql query user posts: %v", err)
+		return page, fmt.Errorf("could not sql query user posts: %v", err)
 	}
 
 	defer rows.Close()
@@ -170,14 +387,26 @@ func (s *Service) Posts(
 	posts := []Post{}
 	for rows.Next() {
 		var post Post
-		if err := rows.Scan(&post.Title, &post.Body, &post.Link, &post.Album, &post.Poll); err != nil {
-			return nil, fmt.Errorf("could not iterate over user posts: %v", err)
+		if err := rows.Scan(post.scanDest()...); err != nil {
+			return page, fmt.Errorf("could not iterate over user posts: %v", err)
 		}
 
 		posts = append(posts, post)
 	}
 
-	return posts, nil
+	if err = rows.Err(); err != nil {
+		return page, fmt.Errorf("could not iterate over user posts: %v", err)
+	}
+
+	page = newPage(posts, first, func(p Post) string {
+		return encodeTimeCursor(p.CreatedAt, p.ID)
+	})
+
+	if err = s.hydratePosts(ctx, uid, postPtrs(page.Items)...); err != nil {
+		return page, err
+	}
+
+	return page, nil
 }
 
 // Gets a particular post
@@ -187,9 +416,9 @@ func (s *Service) Post(
 ) (Post, error) {
 	var p Post
 
-	p_id, err := strconv.Atoi(postID)
+	p_id, err := strconv.ParseInt(postID, 10, 64)
 	if err != nil {
-		return p, fmt.Errorf("could not convert post string to int: %v", err)
+		return p, ErrInvalidPostID
 	}
 
 	uid, auth := ctx.Value(KeyAuthUserID).(int64)
@@ -197,108 +426,169 @@ func (s *Service) Post(
 		return p, ErrUnauthenticated
 	}
 
-	_ = uid
-
-	query := "SELECT title, body, link, album, poll FROM posts WHERE id = $1"
+	query := "SELECT " + postColumns + " FROM posts WHERE id = $1 AND " + visiblePostCondition("posts", "$2")
 
-	err = s.Db.QueryRow(ctx, query, p_id).Scan(&p.Title, &p.Body, &p.Link, &p.Album, &p.Poll)
-	if err == sql.ErrNoRows {
-		return p, fmt.Errorf("could not sql query user post: %v", err)
+	err = s.Db.QueryRow(ctx, query, p_id, uid).Scan(p.scanDest()...)
+	if err == pgx.ErrNoRows {
+		return p, ErrInvalidPostID
 	}
 
-	if p.Album.String == "null" {
-		p.Album.String = ""
-		p.Album.Valid = false
+	if err != nil {
+		return p, fmt.Errorf("could not sql query user post: %v", err)
 	}
 
-	if p.Poll.String == "null" {
-		p.Poll.String = ""
-		p.Poll.Valid = false
+	if err = s.hydratePosts(ctx, uid, &p); err != nil {
+		return p, err
 	}
 
 	return p, nil
 }
 
-func (s *Service) PostVote(
+func postPtrs(pp []Post) []*Post {
+	out := make([]*Post, len(pp))
+	for i := range pp {
+		out[i] = &pp[i]
+	}
+	return out
+}
+
+// PostComment comments on a post, or replies to the comment parentCommentID
+// of the same post when not 0.
+func (s *Service) PostComment(
 	ctx context.Context,
 	postID int64,
-	action string,
-) error {
+	parentCommentID int64,
+	content string,
+) (Comment, error) {
+	var c Comment
 
 	uid, auth := ctx.Value(KeyAuthUserID).(int64)
 	if !auth {
-		return ErrUnauthenticated
+		return c, ErrUnauthenticated
+	}
+
+	content = strings.TrimSpace(content)
+	if content == "" || len([]rune(content)) > maxCommentLen {
+		return c, ErrInvalidComment
 	}
 
-	var query string 
-	switch action {
-	case "removeUpvote":
-		query = "UPDATE posts SET upvotes = GREATEST(0, upvotes - 1) WHERE id = $1"
-	case "removeDownvote":
-		query = "UPDATE posts SET downvotes = GREATEST(0, downvotes - 1) WHERE id = $1"
-	case "upvote":
-		query = "UPDATE posts SET upvotes = upvotes + 1 WHERE id = $1"
-	case "downvote":
-		query = "UPDATE posts SET downvotes = downvotes + 1 WHERE id = $1"
+	fr, err := s.checkContent(ctx, uid, TargetComment, content)
+	if err != nil {
+		return c, err
 	}
 
 	tx, err := s.Db.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("could not begin transaction: %v", err)
+		return c, fmt.Errorf("could not begin transaction: %v", err)
 	}
 
 	defer tx.Rollback(ctx)
 
-	_, err = tx.Exec(ctx, query, postID)
-	if err != nil {
-		return fmt.Errorf("unable to perform the query update action on post: %v", err)
+	if err = canParticipateInPost(ctx, tx, uid, postID); err != nil {
+		return c, err
 	}
 
-	if action == "removeUpvote" || action == "removeDownvote" {
-		query = "DELETE FROM post_votes WHERE user_id = $1 AND post_id = $2"
-		_, err = tx.Exec(ctx, query, uid, postID)
+	var commentID int64
+	// the author of the post or parent comment gets notified
+	var notifiedID *int64
+	if parentCommentID != 0 {
+		// locking the parent keeps its replies count exact
+		var parentPostID int64
+		var level int
+		query := "SELECT post_id, user_id, nlevel(path) FROM post_comments WHERE id = $1 AND " +
+			visibleCommentCondition("post_comments", "$2") + " FOR UPDATE"
+		err = tx.QueryRow(ctx, query, parentCommentID, uid).Scan(&parentPostID, &notifiedID, &level)
+		if err == pgx.ErrNoRows || err == nil && parentPostID != postID {
+			return c, ErrInvalidParentComment
+		}
+
 		if err != nil {
-			return fmt.Errorf("unable to delete post vote: %v", err)
+			return c, fmt.Errorf("could not query select parent comment: %v", err)
+		}
+
+		if level >= maxThreadDepth {
+			return c, ErrThreadTooDeep
+		}
+
+		query = "INSERT INTO post_comments (post_id, parent_id, user_id, content) VALUES ($1, $2, $3, $4) RETURNING id"
+		err = tx.QueryRow(ctx, query, postID, parentCommentID, uid, content).Scan(&commentID)
+	} else {
+		var authorID int64
+		query := "SELECT user_id FROM posts WHERE id = $1"
+		err = tx.QueryRow(ctx, query, postID).Scan(&authorID)
+		if err == pgx.ErrNoRows {
+			return c, ErrInvalidPostID
 		}
-	} else if action == "upvote" || action == "downvote" {
-		query = "INSERT INTO post_votes (user_id, post_id, vote_type) VALUES ($1, $2, $3)"
-		_, err = tx.Exec(ctx, query, uid, postID, action)
+
 		if err != nil {
-			return fmt.Errorf("unable to insert post vote: %v", err)
+			return c, fmt.Errorf("could not query select post author: %v", err)
 		}
-	}
 
-	tx.Commit(ctx)
+		notifiedID = &authorID
 
-	return nil
-}
+		query = "INSERT INTO post_comments (post_id, user_id, content) VALUES ($1, $2, $3) RETURNING id"
+		err = tx.QueryRow(ctx, query, postID, uid, content).Scan(&commentID)
+	}
 
-func (s *Service) PostComment (
-	ctx context.Context,
-	postID int64,
-	parentCommentID int64,
-	comment string,
-) (error) {
-	uid, auth := ctx.Value(KeyAuthUserID).(int64)
-	if !auth {
-		return ErrUnauthenticated
+	// the post can still be deleted concurrently
+	if isForeignKeyViolation(err) {
+		return c, ErrInvalidPostID
 	}
 
-	_ = uid
+	if err != nil {
+		return c, fmt.Errorf("could not insert comment: %v", err)
+	}
 
-	var query string
-	var err error
 	if parentCommentID != 0 {
-		query = "INSERT INTO post_comments (post_id, parent_id, content) VALUES ($1, $2, $3)"
-		_, err = s.Db.Exec(ctx, query, postID, parentCommentID, comment)
-	} else {
-		query = "INSERT INTO post_comments (post_id, content) VALUES ($1, $2)"
-		_, err = s.Db.Exec(ctx, query, postID, comment)
+		query := "UPDATE post_comments SET replies_count = replies_count + 1 WHERE id = $1"
+		if _, err = tx.Exec(ctx, query, parentCommentID); err != nil {
+			return c, fmt.Errorf("could not update parent comment replies count: %v", err)
+		}
+	}
+
+	if err = applyContentFilters(ctx, tx, uid, fr, TargetComment, commentID); err != nil {
+		return c, err
+	}
+
+	if c, err = comment(ctx, tx, commentID); err != nil {
+		return c, err
 	}
-	
+
+	mentioned, err := saveCommentEntities(ctx, tx, c)
 	if err != nil {
-		return fmt.Errorf("unable to insert comment: %v", err)
+		return c, err
 	}
 
-	return nil
-}
\ No newline at end of file
+	// nobody is notified of content only its author can see
+	if fr.hides() {
+		mentioned, notifiedID = nil, nil
+	}
+
+	nn, err := notifyMentions(ctx, tx, uid, mentioned, postID, &commentID)
+	if err != nil {
+		return c, err
+	}
+
+	if notifiedID != nil {
+		var n *Notification
+		if parentCommentID != 0 {
+			n, err = notify(ctx, tx, *notifiedID, uid, NotificationCommentReply, &postID, &parentCommentID)
+		} else {
+			n, err = notify(ctx, tx, *notifiedID, uid, NotificationPostComment, &postID, nil)
+		}
+
+		if err != nil {
+			return c, err
+		}
+
+		nn = append(nn, n)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return c, fmt.Errorf("could not commit comment: %v", err)
+	}
+
+	s.broadcastNotifications(nn...)
+
+	return c, nil
+}
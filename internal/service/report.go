@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	TargetPost    = "post"
+	TargetComment = "comment"
+	TargetUser    = "user"
+
+	maxReportDetailsLen = 1000
+)
+
+var (
+	ErrInvalidReport        = errors.New("invalid report")
+	ErrInvalidReportReason  = errors.New("invalid report reason")
+	ErrInvalidReportTarget  = errors.New("invalid report target type")
+	ErrReportTargetNotFound = errors.New("report target not found")
+)
+
+// reportReasons is the taxonomy of reasons content and users can be reported for
+var reportReasons = map[string]bool{
+	"spam":           true,
+	"harassment":     true,
+	"hate":           true,
+	"violence":       true,
+	"sexual":         true,
+	"self_harm":      true,
+	"misinformation": true,
+	"impersonation":  true,
+	"other":          true,
+}
+
+// ReportInput is what a user reports, with a reason from the taxonomy
+// and optional details. TargetID is a post, comment or user id.
+type ReportInput struct {
+	TargetType string
+	TargetID   int64
+	Reason     string
+	Details    string
+}
+
+// reportTarget is what the moderators need to know about a reported target
+type reportTarget struct {
+	authorID    *int64
+	communityID *int64
+	snapshot    string
+}
+
+// loadReportTarget gets a target the user uid can see.
+func loadReportTarget(ctx context.Context, db querier, uid int64, targetType string, targetID int64) (reportTarget, error) {
+	var t reportTarget
+	var query string
+	args := []interface{}{targetID, uid}
+	switch targetType {
+	case TargetPost:
+		query = `SELECT posts.user_id, posts.community_id,
+			CONCAT_WS(E'\n\n', NULLIF(posts.title, ''), NULLIF(posts.body, ''), NULLIF(posts.link, ''))
+			FROM posts WHERE posts.id = $1 AND ` + visiblePostCondition("posts", "$2")
+	case TargetComment:
+		query = `SELECT c.user_id, posts.community_id, c.content
+			FROM post_comments c
+			INNER JOIN posts ON posts.id = c.post_id
+			WHERE c.id = $1 AND c.deleted_at IS NULL AND ` + visibleCommentCondition("c", "$2") + `
+			AND ` + visiblePostCondition("posts", "$2")
+	case TargetUser:
+		query = "SELECT id, NULL::INT, username FROM users WHERE id = $1"
+		args = args[:1]
+	default:
+		return t, ErrInvalidReportTarget
+	}
+
+	err := db.QueryRow(ctx, query, args...).Scan(&t.authorID, &t.communityID, &t.snapshot)
+	if err == pgx.ErrNoRows {
+		return t, ErrReportTargetNotFound
+	}
+
+	if err != nil {
+		return t, fmt.Errorf("could not query select report target: %v", err)
+	}
+
+	return t, nil
+}
+
+// CreateReport reports a post, comment or user to the moderators.
+// Reports on the same target are grouped into a single open moderation case,
+// and reporting the same case twice is a no-op.
+func (s *Service) CreateReport(ctx context.Context, in ReportInput) error {
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return ErrUnauthenticated
+	}
+
+	if !reportReasons[in.Reason] {
+		return ErrInvalidReportReason
+	}
+
+	in.Details = strings.TrimSpace(in.Details)
+	if len([]rune(in.Details)) > maxReportDetailsLen {
+		return ErrInvalidReport
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	t, err := loadReportTarget(ctx, tx, uid, in.TargetType, in.TargetID)
+	if err != nil {
+		return err
+	}
+
+	if t.authorID != nil && *t.authorID == uid {
+		return ErrInvalidReport
+	}
+
+	var caseID int64
+	query := `INSERT INTO moderation_cases (target_type, target_id, community_id, snapshot)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (target_type, target_id) WHERE status <> 'resolved'
+		DO UPDATE SET updated_at = NOW()
+		RETURNING id`
+	err = tx.QueryRow(ctx, query, in.TargetType, in.TargetID, t.communityID, t.snapshot).Scan(&caseID)
+	if err != nil {
+		return fmt.Errorf("could not upsert moderation case: %v", err)
+	}
+
+	query = `INSERT INTO reports (case_id, reporter_id, reason, details)
+		VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`
+	tag, err := tx.Exec(ctx, query, caseID, uid, in.Reason, in.Details)
+	if err != nil {
+		return fmt.Errorf("could not insert report: %v", err)
+	}
+
+	if tag.RowsAffected() != 0 {
+		query = "UPDATE moderation_cases SET reports_count = reports_count + 1 WHERE id = $1"
+		if _, err = tx.Exec(ctx, query, caseID); err != nil {
+			return fmt.Errorf("could not update moderation case reports count: %v", err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("could not commit report: %v", err)
+	}
+
+	return nil
+}
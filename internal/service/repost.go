@@ -0,0 +1,295 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// RepostOutput response
+type RepostOutput struct {
+	Reposted     bool  `json:"reposted"`
+	RepostsCount int64 `json:"reposts_count"`
+}
+
+// Repost shares a post to the timelines of the authenticated user and their followers.
+// Reposting a post twice is a no-op.
+func (s *Service) Repost(ctx context.Context, postID int64) (RepostOutput, error) {
+	var out RepostOutput
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return out, ErrUnauthenticated
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return out, fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	// locking the post keeps its reposts count exact
+	var authorID int64
+	query := "SELECT user_id FROM posts WHERE id = $1 AND " +
+		visiblePostCondition("posts", "$2") + " FOR UPDATE OF posts"
+	err = tx.QueryRow(ctx, query, postID, uid).Scan(&authorID)
+	if err == pgx.ErrNoRows {
+		return out, ErrInvalidPostID
+	}
+
+	if err != nil {
+		return out, fmt.Errorf("could not query select reposted post: %v", err)
+	}
+
+	out.Reposted = true
+
+	var repostID int64
+	query = "INSERT INTO reposts (user_id, post_id) VALUES ($1, $2) ON CONFLICT DO NOTHING RETURNING id"
+	err = tx.QueryRow(ctx, query, uid, postID).Scan(&repostID)
+	if err == pgx.ErrNoRows {
+		out.RepostsCount, err = visibleRepostsCount(ctx, tx, uid, postID)
+		return out, err
+	}
+
+	if err != nil {
+		return out, fmt.Errorf("could not insert repost: %v", err)
+	}
+
+	query = "UPDATE posts SET reposts_count = reposts_count + 1 WHERE id = $1"
+	if _, err = tx.Exec(ctx, query, postID); err != nil {
+		return out, fmt.Errorf("could not update post reposts count: %v", err)
+	}
+
+	if out.RepostsCount, err = visibleRepostsCount(ctx, tx, uid, postID); err != nil {
+		return out, err
+	}
+
+	// timelines already holding the post keep their item
+	query = `INSERT INTO timeline (user_id, post_id, repost_id)
+		SELECT follower_id, $2::INT, $3::INT FROM user_follows WHERE followee_id = $1
+		UNION SELECT $1::INT, $2::INT, $3::INT
+		ON CONFLICT (user_id, post_id) DO NOTHING`
+	if _, err = tx.Exec(ctx, query, uid, postID, repostID); err != nil {
+		return out, fmt.Errorf("could not insert repost into timelines: %v", err)
+	}
+
+	n, err := notify(ctx, tx, authorID, uid, NotificationRepost, &postID, nil)
+	if err != nil {
+		return out, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return out, fmt.Errorf("could not commit repost: %v", err)
+	}
+
+	s.broadcastNotifications(n)
+
+	return out, nil
+}
+
+// UndoRepost undoes the repost of a post by the authenticated user,
+// taking it out of the timelines it was shared to.
+// Undoing a repost that does not exist is a no-op.
+func (s *Service) UndoRepost(ctx context.Context, postID int64) (RepostOutput, error) {
+	var out RepostOutput
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return out, ErrUnauthenticated
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return out, fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	// reposts of removed posts can still be undone
+	query := "SELECT id FROM posts WHERE id = $1 FOR UPDATE"
+	err = tx.QueryRow(ctx, query, postID).Scan(&postID)
+	if err == pgx.ErrNoRows {
+		return out, ErrInvalidPostID
+	}
+
+	if err != nil {
+		return out, fmt.Errorf("could not query select reposted post: %v", err)
+	}
+
+	// the timeline items of the repost cascade
+	query = "DELETE FROM reposts WHERE user_id = $1 AND post_id = $2"
+	tag, err := tx.Exec(ctx, query, uid, postID)
+	if err != nil {
+		return out, fmt.Errorf("could not delete repost: %v", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		out.RepostsCount, err = visibleRepostsCount(ctx, tx, uid, postID)
+		return out, err
+	}
+
+	query = "UPDATE posts SET reposts_count = reposts_count - 1 WHERE id = $1"
+	if _, err = tx.Exec(ctx, query, postID); err != nil {
+		return out, fmt.Errorf("could not update post reposts count: %v", err)
+	}
+
+	if out.RepostsCount, err = visibleRepostsCount(ctx, tx, uid, postID); err != nil {
+		return out, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return out, fmt.Errorf("could not commit repost undo: %v", err)
+	}
+
+	return out, nil
+}
+
+// visibleRepostCondition matches the reposts in table the user uid can see,
+// those of shadowbanned users being hidden from everyone else.
+func visibleRepostCondition(table, uid string) string {
+	return "(" + table + ".user_id = " + uid + " OR NOT EXISTS (" +
+		"SELECT 1 FROM users reposters WHERE reposters.id = " + table + ".user_id AND reposters.shadowbanned))"
+}
+
+// visibleRepostsCount counts the reposts of a post the user uid can see.
+func visibleRepostsCount(ctx context.Context, db querier, uid, postID int64) (int64, error) {
+	var count int64
+	query := "SELECT COUNT(*) FROM reposts WHERE post_id = $1 AND " + visibleRepostCondition("reposts", "$2")
+	if err := db.QueryRow(ctx, query, postID, uid).Scan(&count); err != nil {
+		return 0, fmt.Errorf("could not query select reposts count: %v", err)
+	}
+
+	return count, nil
+}
+
+// visibleShareCounts sets the reposts and quotes counts of each post to the ones
+// the user uid can see. The stored counts include reposts of shadowbanned users
+// and quotes which got removed, held or are otherwise hidden from the user.
+func visibleShareCounts(ctx context.Context, db querier, uid int64, pp ...*Post) error {
+	byID := map[int64]*Post{}
+	var ids []int64
+	for _, p := range pp {
+		// the stored counts are upper bounds
+		if p.RepostsCount != 0 || p.QuotesCount != 0 {
+			byID[p.ID] = p
+			ids = append(ids, p.ID)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query := `SELECT p.id,
+		(SELECT COUNT(*) FROM reposts WHERE reposts.post_id = p.id AND ` + visibleRepostCondition("reposts", "$2") + `),
+		(SELECT COUNT(*) FROM posts q WHERE q.quoted_post_id = p.id AND ` + visiblePostCondition("q", "$2") + `)
+		FROM UNNEST($1::BIGINT[]) AS p(id)`
+	rows, err := db.Query(ctx, query, ids, uid)
+	if err != nil {
+		return fmt.Errorf("could not sql query visible share counts: %v", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var reposts, quotes int64
+		if err := rows.Scan(&id, &reposts, &quotes); err != nil {
+			return fmt.Errorf("could not iterate over visible share counts: %v", err)
+		}
+
+		byID[id].RepostsCount = reposts
+		byID[id].QuotesCount = quotes
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate over visible share counts: %v", err)
+	}
+
+	return nil
+}
+
+// repostedPosts returns which of the given posts the user uid reposted.
+func repostedPosts(ctx context.Context, db querier, uid int64, postIDs []int64) (map[int64]bool, error) {
+	reposted := map[int64]bool{}
+	if uid == 0 {
+		return reposted, nil
+	}
+
+	query := "SELECT post_id FROM reposts WHERE user_id = $1 AND post_id = ANY($2)"
+	rows, err := db.Query(ctx, query, uid, postIDs)
+	if err != nil {
+		return nil, fmt.Errorf("could not sql query reposted posts: %v", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID int64
+		if err := rows.Scan(&postID); err != nil {
+			return nil, fmt.Errorf("could not iterate over reposted posts: %v", err)
+		}
+
+		reposted[postID] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over reposted posts: %v", err)
+	}
+
+	return reposted, nil
+}
+
+// hydrateQuotedPosts loads the posts quoted by the given posts, as seen by the user uid.
+// Quoted posts which got removed or the user cannot see are left nil.
+// Only one level is loaded: quotes of quotes carry their quoted post id alone.
+func (s *Service) hydrateQuotedPosts(ctx context.Context, uid int64, pp ...*Post) error {
+	var ids []int64
+	for _, p := range pp {
+		if p.QuotedPostID != nil {
+			ids = append(ids, *p.QuotedPostID)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query := "SELECT " + postColumns + " FROM posts WHERE id = ANY($1) AND " + visiblePostCondition("posts", "$2")
+	rows, err := s.Db.Query(ctx, query, uniqueInt64s(ids), uid)
+	if err != nil {
+		return fmt.Errorf("could not sql query quoted posts: %v", err)
+	}
+
+	defer rows.Close()
+
+	quoted := map[int64]*Post{}
+	var qq []*Post
+	for rows.Next() {
+		q := &Post{}
+		if err := rows.Scan(q.scanDest()...); err != nil {
+			return fmt.Errorf("could not iterate over quoted posts: %v", err)
+		}
+
+		quoted[q.ID] = q
+		qq = append(qq, q)
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate over quoted posts: %v", err)
+	}
+
+	if err = s.hydratePostData(ctx, uid, qq...); err != nil {
+		return err
+	}
+
+	for _, p := range pp {
+		if p.QuotedPostID != nil {
+			p.QuotedPost = quoted[*p.QuotedPostID]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,382 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const maxCollectionLen = 64
+
+var (
+	ErrInvalidCollection = errors.New("invalid collection")
+)
+
+// SavedItem is a post or comment the user saved for later,
+// optionally in a named collection.
+type SavedItem struct {
+	ID         int64     `json:"id"`
+	Collection string    `json:"collection,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	Post       *Post     `json:"post,omitempty"`
+	Comment    *Comment  `json:"comment,omitempty"`
+
+	postID    *int64
+	commentID *int64
+}
+
+// SavedCollection is a named collection of saved items.
+type SavedCollection struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func normalizeCollection(collection string) (string, error) {
+	collection = strings.TrimSpace(collection)
+	if len([]rune(collection)) > maxCollectionLen {
+		return "", ErrInvalidCollection
+	}
+
+	return collection, nil
+}
+
+// SavePost saves a post for the authenticated user in the given collection,
+// none when empty. Saving an already saved post moves it to the collection.
+func (s *Service) SavePost(ctx context.Context, postID int64, collection string) error {
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return ErrUnauthenticated
+	}
+
+	collection, err := normalizeCollection(collection)
+	if err != nil {
+		return err
+	}
+
+	if err = postVisible(ctx, s.Db, uid, postID); err != nil {
+		return err
+	}
+
+	return s.save(ctx, uid, "post_id", postID, collection, ErrInvalidPostID)
+}
+
+// SaveComment saves a comment for the authenticated user,
+// with the same semantics as SavePost.
+func (s *Service) SaveComment(ctx context.Context, commentID int64, collection string) error {
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return ErrUnauthenticated
+	}
+
+	collection, err := normalizeCollection(collection)
+	if err != nil {
+		return err
+	}
+
+	var postID int64
+	query := "SELECT post_id FROM post_comments WHERE id = $1 AND deleted_at IS NULL AND " +
+		visibleCommentCondition("post_comments", "$2")
+	err = s.Db.QueryRow(ctx, query, commentID, uid).Scan(&postID)
+	if err == pgx.ErrNoRows {
+		return ErrCommentNotFound
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not query select saved comment: %v", err)
+	}
+
+	if err = postVisible(ctx, s.Db, uid, postID); err == ErrInvalidPostID {
+		return ErrCommentNotFound
+	} else if err != nil {
+		return err
+	}
+
+	return s.save(ctx, uid, "comment_id", commentID, collection, ErrCommentNotFound)
+}
+
+// save upserts the saved item of the user uid on target of column,
+// notFound being returned when the target gets deleted concurrently.
+func (s *Service) save(ctx context.Context, uid int64, column string, target int64, collection string, notFound error) error {
+	query := "INSERT INTO saved_items (user_id, " + column + ", collection) VALUES ($1, $2, $3) " +
+		"ON CONFLICT (user_id, " + column + ") DO UPDATE SET collection = EXCLUDED.collection"
+	_, err := s.Db.Exec(ctx, query, uid, target, collection)
+	if isForeignKeyViolation(err) {
+		return notFound
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not insert saved item: %v", err)
+	}
+
+	return nil
+}
+
+// UnsavePost removes a post from the saved items of the authenticated user.
+// Unsaving a post not saved is a no-op.
+func (s *Service) UnsavePost(ctx context.Context, postID int64) error {
+	return s.unsave(ctx, "post_id", postID)
+}
+
+// UnsaveComment removes a comment from the saved items of the authenticated user.
+func (s *Service) UnsaveComment(ctx context.Context, commentID int64) error {
+	return s.unsave(ctx, "comment_id", commentID)
+}
+
+func (s *Service) unsave(ctx context.Context, column string, target int64) error {
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return ErrUnauthenticated
+	}
+
+	query := "DELETE FROM saved_items WHERE user_id = $1 AND " + column + " = $2"
+	if _, err := s.Db.Exec(ctx, query, uid, target); err != nil {
+		return fmt.Errorf("could not delete saved item: %v", err)
+	}
+
+	return nil
+}
+
+// Saved gets a page of the saved items of the authenticated user, most recently saved first.
+// A non nil collection only lists the items in it, the empty one being the items in none.
+// Items the user can no longer see are left out.
+func (s *Service) Saved(ctx context.Context, collection *string, after string, first int) (Page[SavedItem], error) {
+	var page Page[SavedItem]
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return page, ErrUnauthenticated
+	}
+
+	first = normalizePageSize(first)
+
+	query := `SELECT s.id, s.collection, s.created_at, s.post_id, s.comment_id
+		FROM saved_items s
+		WHERE s.user_id = $1
+		AND (s.post_id IS NULL OR EXISTS (
+			SELECT 1 FROM posts WHERE posts.id = s.post_id AND ` + visiblePostCondition("posts", "$1") + `
+		))
+		AND (s.comment_id IS NULL OR EXISTS (
+			SELECT 1 FROM post_comments c INNER JOIN posts ON posts.id = c.post_id
+			WHERE c.id = s.comment_id AND c.deleted_at IS NULL
+			AND ` + visibleCommentCondition("c", "$1") + ` AND ` + visiblePostCondition("posts", "$1") + `
+		))`
+	args := []interface{}{uid}
+
+	if collection != nil {
+		c, err := normalizeCollection(*collection)
+		if err != nil {
+			return page, err
+		}
+
+		args = append(args, c)
+		query += " AND s.collection = $" + strconv.Itoa(len(args))
+	}
+
+	if after != "" {
+		createdAt, id, err := decodeTimeCursor(after)
+		if err != nil {
+			return page, err
+		}
+
+		args = append(args, createdAt, id)
+		query += " AND (s.created_at, s.id) < ($" + strconv.Itoa(len(args)-1) + ", $" + strconv.Itoa(len(args)) + ")"
+	}
+
+	query += " ORDER BY s.created_at DESC, s.id DESC LIMIT " + strconv.Itoa(first+1)
+
+	rows, err := s.Db.Query(ctx, query, args...)
+	if err != nil {
+		return page, fmt.Errorf("could not sql query saved items: %v", err)
+	}
+
+	defer rows.Close()
+
+	ii := []SavedItem{}
+	for rows.Next() {
+		var i SavedItem
+		if err := rows.Scan(&i.ID, &i.Collection, &i.CreatedAt, &i.postID, &i.commentID); err != nil {
+			return page, fmt.Errorf("could not iterate over saved items: %v", err)
+		}
+
+		ii = append(ii, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return page, fmt.Errorf("could not iterate over saved items: %v", err)
+	}
+
+	page = newPage(ii, first, func(i SavedItem) string {
+		return encodeTimeCursor(i.CreatedAt, i.ID)
+	})
+
+	if err = s.loadSavedItems(ctx, uid, page.Items); err != nil {
+		return page, err
+	}
+
+	return page, nil
+}
+
+// loadSavedItems fills the post or comment of each saved item, as seen by the user uid.
+func (s *Service) loadSavedItems(ctx context.Context, uid int64, ii []SavedItem) error {
+	var postIDs, commentIDs []int64
+	for _, i := range ii {
+		if i.postID != nil {
+			postIDs = append(postIDs, *i.postID)
+		}
+		if i.commentID != nil {
+			commentIDs = append(commentIDs, *i.commentID)
+		}
+	}
+
+	posts := map[int64]*Post{}
+	if len(postIDs) != 0 {
+		query := "SELECT " + postColumns + " FROM posts WHERE id = ANY($1)"
+		rows, err := s.Db.Query(ctx, query, postIDs)
+		if err != nil {
+			return fmt.Errorf("could not sql query saved posts: %v", err)
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			p := &Post{}
+			if err := rows.Scan(p.scanDest()...); err != nil {
+				return fmt.Errorf("could not iterate over saved posts: %v", err)
+			}
+
+			posts[p.ID] = p
+		}
+
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("could not iterate over saved posts: %v", err)
+		}
+
+		pp := make([]*Post, 0, len(posts))
+		for _, p := range posts {
+			pp = append(pp, p)
+		}
+
+		if err = s.hydratePosts(ctx, uid, pp...); err != nil {
+			return err
+		}
+	}
+
+	comments := map[int64]*Comment{}
+	if len(commentIDs) != 0 {
+		query := "SELECT " + commentColumns + " FROM post_comments c" + commentAuthorJoin + " WHERE c.id = ANY($1)"
+		rows, err := s.Db.Query(ctx, query, commentIDs)
+		if err != nil {
+			return fmt.Errorf("could not sql query saved comments: %v", err)
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			c := &Comment{}
+			if err := rows.Scan(c.scanDest()...); err != nil {
+				return fmt.Errorf("could not iterate over saved comments: %v", err)
+			}
+
+			c.afterScan()
+			comments[c.ID] = c
+		}
+
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("could not iterate over saved comments: %v", err)
+		}
+
+		cc := make([]*Comment, 0, len(comments))
+		for _, c := range comments {
+			cc = append(cc, c)
+		}
+
+		if err = visibleRepliesCounts(ctx, s.Db, uid, cc...); err != nil {
+			return err
+		}
+
+		if err = commentVotes(ctx, s.Db, uid, cc...); err != nil {
+			return err
+		}
+	}
+
+	for i := range ii {
+		if ii[i].postID != nil {
+			ii[i].Post = posts[*ii[i].postID]
+		}
+		if ii[i].commentID != nil {
+			ii[i].Comment = comments[*ii[i].commentID]
+		}
+	}
+
+	return nil
+}
+
+// SavedCollections gets the named collections of the authenticated user, by name.
+func (s *Service) SavedCollections(ctx context.Context) ([]SavedCollection, error) {
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return nil, ErrUnauthenticated
+	}
+
+	query := `SELECT collection, COUNT(*) FROM saved_items
+		WHERE user_id = $1 AND collection <> ''
+		GROUP BY collection ORDER BY collection`
+	rows, err := s.Db.Query(ctx, query, uid)
+	if err != nil {
+		return nil, fmt.Errorf("could not sql query saved collections: %v", err)
+	}
+
+	defer rows.Close()
+
+	cc := []SavedCollection{}
+	for rows.Next() {
+		var c SavedCollection
+		if err := rows.Scan(&c.Name, &c.Count); err != nil {
+			return nil, fmt.Errorf("could not iterate over saved collections: %v", err)
+		}
+
+		cc = append(cc, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over saved collections: %v", err)
+	}
+
+	return cc, nil
+}
+
+// savedPosts returns which of the given posts the user uid saved.
+func savedPosts(ctx context.Context, db querier, uid int64, postIDs []int64) (map[int64]bool, error) {
+	saved := map[int64]bool{}
+	if uid == 0 {
+		return saved, nil
+	}
+
+	query := "SELECT post_id FROM saved_items WHERE user_id = $1 AND post_id = ANY($2)"
+	rows, err := db.Query(ctx, query, uid, postIDs)
+	if err != nil {
+		return nil, fmt.Errorf("could not sql query saved posts: %v", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID int64
+		if err := rows.Scan(&postID); err != nil {
+			return nil, fmt.Errorf("could not iterate over saved posts: %v", err)
+		}
+
+		saved[postID] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over saved posts: %v", err)
+	}
+
+	return saved, nil
+}
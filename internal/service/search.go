@@ -0,0 +1,359 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SearchPosts    = "posts"
+	SearchComments = "comments"
+	SearchUsers    = "users"
+
+	maxSearchQueryLen = 256
+
+	// ts_headline wraps matches between these private use characters,
+	// replaced by <mark> tags after escaping the snippet
+	snippetStart = "\uE000"
+	snippetStop  = "\uE001"
+)
+
+var (
+	ErrInvalidSearchQuery = errors.New("invalid search query")
+	ErrInvalidSearchType  = errors.New("invalid search type")
+)
+
+const headlineOptions = "StartSel=" + snippetStart + ", StopSel=" + snippetStop +
+	", MaxFragments=2, MaxWords=30, MinWords=10, FragmentDelimiter=\" … \""
+
+// SearchFilter narrows a search. Zero fields do not filter.
+type SearchFilter struct {
+	Query  string
+	Author string
+	Tag    string
+	From   *time.Time
+	To     *time.Time
+}
+
+// PostSearchResult is a matching post with the matches highlighted in Snippet.
+type PostSearchResult struct {
+	Post
+	Snippet string `json:"snippet"`
+}
+
+// CommentSearchResult is a matching comment with the matches highlighted in Snippet.
+type CommentSearchResult struct {
+	Comment
+	Snippet string `json:"snippet"`
+}
+
+// highlight escapes a ts_headline snippet as HTML
+// and marks its matches with <mark> tags.
+func highlight(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, snippetStart, "<mark>")
+	return strings.ReplaceAll(snippet, snippetStop, "</mark>")
+}
+
+// searchQuery builds the conditions shared by post and comment searches
+// on the rows of table, ranked against the parsed search query.
+type searchQuery struct {
+	where []string
+	args  []interface{}
+}
+
+func (q *searchQuery) arg(v interface{}) string {
+	q.args = append(q.args, v)
+	return "$" + strconv.Itoa(len(q.args))
+}
+
+func newSearchQuery(f SearchFilter, table, tagTable, tagColumn string, after string) (*searchQuery, error) {
+	f.Query = strings.TrimSpace(f.Query)
+	if f.Query == "" || len([]rune(f.Query)) > maxSearchQueryLen {
+		return nil, ErrInvalidSearchQuery
+	}
+
+	q := &searchQuery{}
+	// $1 is always the text search query
+	q.where = append(q.where, table+".search_vector @@ websearch_to_tsquery('english', "+q.arg(f.Query)+")")
+
+	if author := strings.TrimSpace(f.Author); author != "" {
+		q.where = append(q.where, table+".user_id = (SELECT id FROM users WHERE username = "+q.arg(author)+")")
+	}
+
+	if f.Tag != "" {
+		tag, ok := normalizeTag(f.Tag)
+		if !ok {
+			return nil, ErrInvalidTag
+		}
+
+		q.where = append(q.where, "EXISTS (SELECT 1 FROM "+tagTable+" WHERE "+tagTable+"."+tagColumn+
+			" = "+table+".id AND "+tagTable+".tag = "+q.arg(tag)+")")
+	}
+
+	if f.From != nil {
+		q.where = append(q.where, table+".created_at >= "+q.arg(*f.From))
+	}
+
+	if f.To != nil {
+		q.where = append(q.where, table+".created_at < "+q.arg(*f.To))
+	}
+
+	if after != "" {
+		key, id, err := decodeCursor(after)
+		if err != nil {
+			return nil, err
+		}
+
+		rank, err := strconv.ParseFloat(key, 64)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+
+		q.where = append(q.where, "("+q.rank(table)+", "+table+".id) < ("+q.arg(rank)+"::REAL, "+q.arg(id)+")")
+	}
+
+	return q, nil
+}
+
+func (q *searchQuery) rank(table string) string {
+	return "ts_rank_cd(" + table + ".search_vector, websearch_to_tsquery('english', $1))"
+}
+
+// SearchPosts gets a page of the posts matching the filter, most relevant first.
+func (s *Service) SearchPosts(ctx context.Context, f SearchFilter, after string, first int) (Page[PostSearchResult], error) {
+	var page Page[PostSearchResult]
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return page, ErrUnauthenticated
+	}
+
+	q, err := newSearchQuery(f, "posts", "post_tags", "post_id", after)
+	if err != nil {
+		return page, err
+	}
+
+	q.where = append(q.where, visiblePostCondition("posts", q.arg(uid)))
+
+	first = normalizePageSize(first)
+
+	query := "SELECT " + postColumns + ", " + q.rank("posts") + `,
+		ts_headline('english', COALESCE(NULLIF(posts.body, ''), posts.title, ''),
+			websearch_to_tsquery('english', $1), '` + headlineOptions + `')
+		FROM posts
+		WHERE ` + strings.Join(q.where, " AND ") + `
+		ORDER BY ` + q.rank("posts") + ` DESC, posts.id DESC
+		LIMIT ` + strconv.Itoa(first+1)
+
+	rows, err := s.Db.Query(ctx, query, q.args...)
+	if err != nil {
+		return page, fmt.Errorf("could not sql query post search: %v", err)
+	}
+
+	defer rows.Close()
+
+	type rankedResult struct {
+		PostSearchResult
+		rank float32
+	}
+
+	var rr []rankedResult
+	for rows.Next() {
+		var r rankedResult
+		if err := rows.Scan(append(r.scanDest(), &r.rank, &r.Snippet)...); err != nil {
+			return page, fmt.Errorf("could not iterate over post search: %v", err)
+		}
+
+		r.Snippet = highlight(r.Snippet)
+		rr = append(rr, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return page, fmt.Errorf("could not iterate over post search: %v", err)
+	}
+
+	rp := newPage(rr, first, func(r rankedResult) string {
+		return encodeCursor(strconv.FormatFloat(float64(r.rank), 'g', -1, 32), r.ID)
+	})
+
+	page.NextCursor = rp.NextCursor
+	page.Items = make([]PostSearchResult, 0, len(rp.Items))
+	pp := make([]*Post, 0, len(rp.Items))
+	for _, r := range rp.Items {
+		page.Items = append(page.Items, r.PostSearchResult)
+	}
+
+	for i := range page.Items {
+		pp = append(pp, &page.Items[i].Post)
+	}
+
+	if err = s.hydratePosts(ctx, uid, pp...); err != nil {
+		return page, err
+	}
+
+	return page, nil
+}
+
+// SearchComments gets a page of the comments matching the filter, most relevant first.
+func (s *Service) SearchComments(ctx context.Context, f SearchFilter, after string, first int) (Page[CommentSearchResult], error) {
+	var page Page[CommentSearchResult]
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return page, ErrUnauthenticated
+	}
+
+	q, err := newSearchQuery(f, "c", "comment_tags", "comment_id", after)
+	if err != nil {
+		return page, err
+	}
+
+	uidArg := q.arg(uid)
+	q.where = append(q.where, visibleCommentCondition("c", uidArg))
+	q.where = append(q.where, "EXISTS (SELECT 1 FROM posts WHERE posts.id = c.post_id AND "+
+		visiblePostCondition("posts", uidArg)+")")
+
+	first = normalizePageSize(first)
+
+	query := "SELECT " + commentColumns + ", " + q.rank("c") + `,
+		ts_headline('english', c.content, websearch_to_tsquery('english', $1), '` + headlineOptions + `')
+		FROM post_comments c` + commentAuthorJoin + `
+		WHERE c.deleted_at IS NULL AND ` + strings.Join(q.where, " AND ") + `
+		ORDER BY ` + q.rank("c") + ` DESC, c.id DESC
+		LIMIT ` + strconv.Itoa(first+1)
+
+	rows, err := s.Db.Query(ctx, query, q.args...)
+	if err != nil {
+		return page, fmt.Errorf("could not sql query comment search: %v", err)
+	}
+
+	defer rows.Close()
+
+	type rankedResult struct {
+		CommentSearchResult
+		rank float32
+	}
+
+	var rr []rankedResult
+	for rows.Next() {
+		var r rankedResult
+		if err := rows.Scan(append(r.scanDest(), &r.rank, &r.Snippet)...); err != nil {
+			return page, fmt.Errorf("could not iterate over comment search: %v", err)
+		}
+
+		r.afterScan()
+		r.Snippet = highlight(r.Snippet)
+		rr = append(rr, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return page, fmt.Errorf("could not iterate over comment search: %v", err)
+	}
+
+	rp := newPage(rr, first, func(r rankedResult) string {
+		return encodeCursor(strconv.FormatFloat(float64(r.rank), 'g', -1, 32), r.ID)
+	})
+
+	page.NextCursor = rp.NextCursor
+	page.Items = make([]CommentSearchResult, 0, len(rp.Items))
+	for _, r := range rp.Items {
+		page.Items = append(page.Items, r.CommentSearchResult)
+	}
+
+	cc := make([]*Comment, len(page.Items))
+	for i := range page.Items {
+		cc[i] = &page.Items[i].Comment
+	}
+
+	if err = visibleRepliesCounts(ctx, s.Db, uid, cc...); err != nil {
+		return page, err
+	}
+
+	if err = commentVotes(ctx, s.Db, uid, cc...); err != nil {
+		return page, err
+	}
+
+	return page, nil
+}
+
+// SearchUsers gets a page of the users whose username contains the query,
+// closest matches first. Only the query filter applies.
+func (s *Service) SearchUsers(ctx context.Context, f SearchFilter, after string, first int) (Page[User], error) {
+	var page Page[User]
+
+	if _, auth := ctx.Value(KeyAuthUserID).(int64); !auth {
+		return page, ErrUnauthenticated
+	}
+
+	f.Query = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(f.Query), "@"))
+	if f.Query == "" || len([]rune(f.Query)) > maxSearchQueryLen {
+		return page, ErrInvalidSearchQuery
+	}
+
+	first = normalizePageSize(first)
+
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(f.Query) + "%"
+	query := "SELECT id, username, similarity(username, $1) FROM users WHERE username ILIKE $2"
+	args := []interface{}{f.Query, pattern}
+
+	if after != "" {
+		key, id, err := decodeCursor(after)
+		if err != nil {
+			return page, err
+		}
+
+		rank, err := strconv.ParseFloat(key, 64)
+		if err != nil {
+			return page, ErrInvalidCursor
+		}
+
+		query += " AND (similarity(username, $1), id) < ($3::REAL, $4)"
+		args = append(args, rank, id)
+	}
+
+	query += " ORDER BY similarity(username, $1) DESC, id DESC LIMIT " + strconv.Itoa(first+1)
+
+	rows, err := s.Db.Query(ctx, query, args...)
+	if err != nil {
+		return page, fmt.Errorf("could not sql query user search: %v", err)
+	}
+
+	defer rows.Close()
+
+	type rankedUser struct {
+		User
+		rank float32
+	}
+
+	var uu []rankedUser
+	for rows.Next() {
+		var u rankedUser
+		if err := rows.Scan(&u.ID, &u.Username, &u.rank); err != nil {
+			return page, fmt.Errorf("could not iterate over user search: %v", err)
+		}
+
+		uu = append(uu, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return page, fmt.Errorf("could not iterate over user search: %v", err)
+	}
+
+	rp := newPage(uu, first, func(u rankedUser) string {
+		return encodeCursor(strconv.FormatFloat(float64(u.rank), 'g', -1, 32), u.ID)
+	})
+
+	page.NextCursor = rp.NextCursor
+	page.Items = make([]User, 0, len(rp.Items))
+	for _, u := range rp.Items {
+		page.Items = append(page.Items, u.User)
+	}
+
+	return page, nil
+}
@@ -0,0 +1,11 @@
+package service
+
+import "testing"
+
+func TestHighlight(t *testing.T) {
+	in := "a <b>" + snippetStart + "go" + snippetStop + "</b> & more"
+	want := "a &lt;b&gt;<mark>go</mark>&lt;/b&gt; &amp; more"
+	if got := highlight(in); got != want {
+		t.Errorf("highlight(%q) = %q, expected %q", in, got, want)
+	}
+}
@@ -1,15 +1,30 @@
 package service
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
 
+	"github.com/dhruvsingh510/bond_social_api/internal/storage"
+	"github.com/dhruvsingh510/bond_social_api/internal/unfurl"
 	"github.com/hako/branca"
 	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 // Service contains the main logic.
 type Service struct {
-	Db *pgx.Conn
+	Db *pgxpool.Pool
 	Codec *branca.Branca
-	timelineItemClients sync.Map
+	Blobs storage.BlobStore
+	Unfurler *unfurl.Fetcher
+	liveClients sync.Map
+	contentFilters atomic.Pointer[filterSet]
+}
+
+// querier is implemented by both the pool and transactions,
+// for reads that happen either inside or outside a transaction.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
 }
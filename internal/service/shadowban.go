@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	ModActionShadowbanUser   = "shadowban_user"
+	ModActionUnshadowbanUser = "unshadowban_user"
+)
+
+// SetShadowban shadowbans or lifts the shadowban of a user.
+// The posts, comments and votes of shadowbanned users look normal to them
+// but are hidden from everyone else, and their votes do not count,
+// including those cast before the shadowban until it is lifted.
+// Only site moderators can, with the same restrictions as suspensions.
+func (s *Service) SetShadowban(ctx context.Context, username string, shadowbanned bool) error {
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return ErrUnauthenticated
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	var userID int64
+	var prev bool
+	query := "SELECT id, shadowbanned FROM users WHERE username = $1 FOR UPDATE"
+	err = tx.QueryRow(ctx, query, strings.TrimSpace(username)).Scan(&userID, &prev)
+	if err == pgx.ErrNoRows {
+		return ErrUserNotFound
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not query select shadowbanned user: %v", err)
+	}
+
+	if err = checkSuspendable(ctx, tx, uid, userID); err != nil {
+		return err
+	}
+
+	if prev == shadowbanned {
+		return nil
+	}
+
+	query = "UPDATE users SET shadowbanned = $2 WHERE id = $1"
+	if _, err = tx.Exec(ctx, query, userID, shadowbanned); err != nil {
+		return fmt.Errorf("could not update user shadowban: %v", err)
+	}
+
+	if err = withholdVotes(ctx, tx, userID, shadowbanned); err != nil {
+		return err
+	}
+
+	action := ModActionShadowbanUser
+	if !shadowbanned {
+		action = ModActionUnshadowbanUser
+	}
+
+	err = logModAction(ctx, tx, modAction{
+		actorID:    uid,
+		action:     action,
+		targetType: TargetUser,
+		targetID:   userID,
+		before:     map[string]bool{"shadowbanned": prev},
+		after:      map[string]bool{"shadowbanned": shadowbanned},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("could not commit user shadowban: %v", err)
+	}
+
+	return nil
+}
+
+// withholdVotes takes the counted votes of a user out of the counters and karma
+// when withhold, marking them withheld, or puts the withheld ones back otherwise.
+func withholdVotes(ctx context.Context, tx pgx.Tx, userID int64, withhold bool) error {
+	// votes matching cond flip, moving counters and karma by sign
+	cond, sign := "withheld", 1
+	if withhold {
+		cond, sign = "counted", -1
+	}
+
+	query := `WITH v AS (
+			UPDATE post_votes SET counted = NOT $2, withheld = $2 WHERE user_id = $1 AND ` + cond + `
+			RETURNING post_id, value
+		), d AS (
+			SELECT post_id, COUNT(*) FILTER (WHERE value = 1)::INT AS up, COUNT(*) FILTER (WHERE value = -1)::INT AS down
+			FROM v GROUP BY post_id
+		), p AS (
+			UPDATE posts SET upvotes = upvotes + $3 * d.up, downvotes = downvotes + $3 * d.down
+			FROM d WHERE posts.id = d.post_id
+			RETURNING posts.user_id, d.up - d.down AS karma
+		)
+		UPDATE users SET post_karma = post_karma + $3 * k.karma
+		FROM (SELECT user_id, SUM(karma)::INT AS karma FROM p GROUP BY user_id) k
+		WHERE users.id = k.user_id`
+	if _, err := tx.Exec(ctx, query, userID, withhold, sign); err != nil {
+		return fmt.Errorf("could not update withheld post votes: %v", err)
+	}
+
+	query = `WITH v AS (
+			UPDATE comment_votes SET counted = NOT $2, withheld = $2 WHERE user_id = $1 AND ` + cond + `
+			RETURNING comment_id, value
+		), d AS (
+			SELECT comment_id, COUNT(*) FILTER (WHERE value = 1)::INT AS up, COUNT(*) FILTER (WHERE value = -1)::INT AS down
+			FROM v GROUP BY comment_id
+		), c AS (
+			UPDATE post_comments SET
+				upvotes = upvotes + $3 * d.up,
+				downvotes = downvotes + $3 * d.down,
+				score = comment_wilson_score(upvotes + $3 * d.up, downvotes + $3 * d.down)
+			FROM d WHERE post_comments.id = d.comment_id
+			RETURNING post_comments.user_id, d.up - d.down AS karma
+		)
+		UPDATE users SET comment_karma = comment_karma + $3 * k.karma
+		FROM (SELECT user_id, SUM(karma)::INT AS karma FROM c GROUP BY user_id) k
+		WHERE users.id = k.user_id`
+	if _, err := tx.Exec(ctx, query, userID, withhold, sign); err != nil {
+		return fmt.Errorf("could not update withheld comment votes: %v", err)
+	}
+
+	return nil
+}
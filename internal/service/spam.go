@@ -0,0 +1,411 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	// KeyClient to use in context, for the Client of the request
+	KeyClient key = "client"
+
+	SignalNewAccount     = "new_account"
+	SignalVoteVelocity   = "vote_velocity"
+	SignalSharedClient   = "shared_client"
+	SignalSharedIP       = "shared_ip"
+	SignalAccountCluster = "account_cluster"
+	SignalFlaggedAccount = "flagged_account"
+	SignalVotingRing     = "voting_ring"
+
+	ModActionClearFlag = "clear_flag"
+
+	// votes scoring this much or more do not count
+	suspiciousVoteScore = 0.5
+	// how far back votes are looked at for velocity
+	voteVelocityWindow = time.Minute
+	// how far back clients are looked at for account clusters and votes for rings
+	clusterWindow = time.Hour * 24 * 30
+	// mutual upvotes in clusterWindow for two accounts to be considered a ring
+	votingRingMinVotes = 10
+	maxDeviceIDLen     = 128
+)
+
+var (
+	ErrFlagNotFound = errors.New("flagged account not found")
+)
+
+// Client identifies where a request comes from.
+// Device is an opaque id the app sends, empty when unknown.
+type Client struct {
+	IP     string
+	Device string
+}
+
+// clientFromContext gets the client of the request,
+// with nil values for the parts that are unknown.
+func clientFromContext(ctx context.Context) (*string, *string) {
+	c, ok := ctx.Value(KeyClient).(Client)
+	if !ok {
+		return nil, nil
+	}
+
+	var ip, device *string
+	if net.ParseIP(c.IP) != nil {
+		ip = &c.IP
+	}
+
+	if c.Device != "" && len(c.Device) <= maxDeviceIDLen {
+		device = &c.Device
+	}
+
+	return ip, device
+}
+
+// voteSignals are what is known of a voter when they vote.
+type voteSignals struct {
+	accountAge time.Duration
+	// votes cast in the last voteVelocityWindow
+	recentVotes int
+	// other accounts which voted the same target from the same device
+	sharedDeviceVoters int
+	// other accounts which voted the same target from the same IP only,
+	// common behind NATs and proxies so weak on its own
+	sharedIPVoters int
+	// other accounts seen on the same IP or device lately
+	clusterAccounts int
+	flagged         bool
+}
+
+// score tells how suspicious a vote is, from 0, along with the signals that contributed.
+func (v voteSignals) score() (float64, []string) {
+	var score float64
+	var reasons []string
+	add := func(s float64, reason string) {
+		score += s
+		reasons = append(reasons, reason)
+	}
+
+	switch {
+	case v.accountAge < time.Hour:
+		add(0.4, SignalNewAccount)
+	case v.accountAge < time.Hour*24:
+		add(0.2, SignalNewAccount)
+	}
+
+	switch {
+	case v.recentVotes >= 20:
+		add(0.5, SignalVoteVelocity)
+	case v.recentVotes >= 10:
+		add(0.2, SignalVoteVelocity)
+	}
+
+	if v.sharedDeviceVoters > 0 {
+		add(0.5, SignalSharedClient)
+	} else if v.sharedIPVoters > 0 {
+		add(0.2, SignalSharedIP)
+	}
+
+	if v.clusterAccounts >= 3 {
+		add(0.3, SignalAccountCluster)
+	}
+
+	// a pending flag makes other signals weigh more but does not silence the account
+	if v.flagged {
+		add(0.3, SignalFlaggedAccount)
+	}
+
+	return score, reasons
+}
+
+// flagWorthy reports whether the signals of a suspicious vote point at
+// the account itself rather than just at it being new.
+func flagWorthy(reasons []string) bool {
+	for _, r := range reasons {
+		if r == SignalVoteVelocity || r == SignalSharedClient || r == SignalSharedIP || r == SignalAccountCluster {
+			return true
+		}
+	}
+
+	return false
+}
+
+// assessVote scores the vote of the user uid on target of the votes table,
+// flagging the user when the vote is suspicious. It reports whether the vote counts,
+// and whether it is withheld: votes of shadowbanned users never count,
+// but those not suspicious are withheld to count again once the shadowban is lifted.
+func assessVote(ctx context.Context, tx pgx.Tx, table, column string, uid, target int64, ip, device *string) (bool, bool, error) {
+	if _, err := tx.Exec(ctx, recordClientQuery, uid, ip, device); err != nil {
+		return false, false, fmt.Errorf("could not upsert user client: %v", err)
+	}
+
+	var v voteSignals
+	var createdAt time.Time
+	var shadowbanned bool
+	query := `SELECT users.created_at, users.shadowbanned,
+		EXISTS (SELECT 1 FROM user_flags WHERE user_flags.user_id = users.id AND user_flags.cleared_at IS NULL),
+		(SELECT COUNT(*) FROM post_votes WHERE post_votes.user_id = users.id AND post_votes.created_at > $2) +
+		(SELECT COUNT(*) FROM comment_votes WHERE comment_votes.user_id = users.id AND comment_votes.created_at > $2),
+		(SELECT COUNT(DISTINCT other.user_id) FROM user_clients other
+			INNER JOIN user_clients own ON own.user_id = users.id
+				AND (own.ip = other.ip OR own.device <> '' AND own.device = other.device)
+			WHERE other.user_id <> users.id AND other.last_seen_at > $3)
+		FROM users WHERE users.id = $1`
+	err := tx.QueryRow(ctx, query, uid, time.Now().Add(-voteVelocityWindow), time.Now().Add(-clusterWindow)).
+		Scan(&createdAt, &shadowbanned, &v.flagged, &v.recentVotes, &v.clusterAccounts)
+	if err != nil {
+		return false, false, fmt.Errorf("could not query select voter signals: %v", err)
+	}
+
+	v.accountAge = time.Since(createdAt)
+
+	if ip != nil || device != nil {
+		query = `SELECT COUNT(DISTINCT user_id) FILTER (WHERE device = $4),
+			COUNT(DISTINCT user_id) FILTER (WHERE ip = $3::INET AND device IS DISTINCT FROM $4)
+			FROM ` + table + " WHERE " + column + ` = $1 AND user_id <> $2
+			AND (ip = $3::INET OR device = $4)`
+		err = tx.QueryRow(ctx, query, target, uid, ip, device).Scan(&v.sharedDeviceVoters, &v.sharedIPVoters)
+		if err != nil {
+			return false, false, fmt.Errorf("could not query select shared client voters: %v", err)
+		}
+	}
+
+	score, reasons := v.score()
+	suspicious := score >= suspiciousVoteScore
+	if suspicious && flagWorthy(reasons) {
+		if err = flagUser(ctx, tx, uid, reasons); err != nil {
+			return false, false, err
+		}
+	}
+
+	if shadowbanned {
+		return false, !suspicious, nil
+	}
+
+	return !suspicious, false, nil
+}
+
+// flagUser flags an account for moderators to review, merging the reasons
+// into its pending flag if any. A cleared flag is reopened with the new reasons only,
+// since clearing vouches for past activity and not for what comes next.
+func flagUser(ctx context.Context, tx pgx.Tx, uid int64, reasons []string) error {
+	query := `INSERT INTO user_flags (user_id, reasons) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET
+			reasons = CASE WHEN user_flags.cleared_at IS NULL
+				THEN ARRAY(SELECT DISTINCT unnest(user_flags.reasons || EXCLUDED.reasons) ORDER BY 1)
+				ELSE EXCLUDED.reasons END,
+			cleared_at = NULL,
+			cleared_by = NULL,
+			updated_at = NOW()`
+	if _, err := tx.Exec(ctx, query, uid, reasons); err != nil {
+		return fmt.Errorf("could not upsert user flag: %v", err)
+	}
+
+	return nil
+}
+
+// recordClientQuery remembers a user was seen on a client, from $1 user id, $2 IP and $3 device.
+const recordClientQuery = `INSERT INTO user_clients (user_id, ip, device) SELECT $1, $2::INET, COALESCE($3, '')
+	WHERE $2::INET IS NOT NULL
+	ON CONFLICT (user_id, ip, device) DO UPDATE SET last_seen_at = NOW()`
+
+// FlaggedAccount is an account suspected of spam or vote manipulation.
+type FlaggedAccount struct {
+	User      User      `json:"user"`
+	Reasons   []string  `json:"reasons"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// DetectVotingRings flags accounts upvoting each other a lot,
+// as votes from one person behind several accounts do.
+func (s *Service) DetectVotingRings(ctx context.Context) error {
+	query := `WITH ups AS (
+			SELECT post_votes.user_id AS voter, posts.user_id AS author
+			FROM post_votes INNER JOIN posts ON posts.id = post_votes.post_id
+			WHERE post_votes.value = 1 AND post_votes.created_at > $1
+			UNION ALL
+			SELECT comment_votes.user_id, post_comments.user_id
+			FROM comment_votes INNER JOIN post_comments ON post_comments.id = comment_votes.comment_id
+			WHERE comment_votes.value = 1 AND comment_votes.created_at > $1
+		), pairs AS (
+			SELECT voter, author FROM ups
+			WHERE author IS NOT NULL AND voter <> author
+			GROUP BY voter, author HAVING COUNT(*) >= $2
+		)
+		SELECT DISTINCT a.voter FROM pairs a
+		INNER JOIN pairs b ON b.voter = a.author AND b.author = a.voter`
+	rows, err := s.Db.Query(ctx, query, time.Now().Add(-clusterWindow), votingRingMinVotes)
+	if err != nil {
+		return fmt.Errorf("could not sql query voting rings: %v", err)
+	}
+
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return fmt.Errorf("could not iterate over voting rings: %v", err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate over voting rings: %v", err)
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	for _, id := range ids {
+		if err = flagUser(ctx, tx, id, []string{SignalVotingRing}); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("could not commit voting ring flags: %v", err)
+	}
+
+	log.Printf("flagged %d accounts in voting rings\n", len(ids))
+
+	return nil
+}
+
+// FlaggedAccounts gets a page of the flagged accounts pending review,
+// most recently flagged first. Only site moderators can.
+func (s *Service) FlaggedAccounts(ctx context.Context, after string, first int) (Page[FlaggedAccount], error) {
+	var page Page[FlaggedAccount]
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return page, ErrUnauthenticated
+	}
+
+	role, err := userRole(ctx, s.Db, uid)
+	if err != nil {
+		return page, err
+	}
+
+	if !canModerate(role) {
+		return page, ErrForbidden
+	}
+
+	first = normalizePageSize(first)
+	args := []interface{}{first + 1}
+	query := `SELECT users.id, users.username, user_flags.reasons, user_flags.created_at, user_flags.updated_at
+		FROM user_flags
+		INNER JOIN users ON users.id = user_flags.user_id
+		WHERE user_flags.cleared_at IS NULL`
+	if after != "" {
+		updatedAt, id, err := decodeTimeCursor(after)
+		if err != nil {
+			return page, err
+		}
+
+		args = append(args, updatedAt, id)
+		query += " AND (user_flags.updated_at, user_flags.user_id) < ($2, $3)"
+	}
+
+	query += " ORDER BY user_flags.updated_at DESC, user_flags.user_id DESC LIMIT $1"
+
+	rows, err := s.Db.Query(ctx, query, args...)
+	if err != nil {
+		return page, fmt.Errorf("could not sql query flagged accounts: %v", err)
+	}
+
+	defer rows.Close()
+
+	ff := []FlaggedAccount{}
+	for rows.Next() {
+		var f FlaggedAccount
+		if err = rows.Scan(&f.User.ID, &f.User.Username, &f.Reasons, &f.CreatedAt, &f.UpdatedAt); err != nil {
+			return page, fmt.Errorf("could not iterate over flagged accounts: %v", err)
+		}
+
+		ff = append(ff, f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return page, fmt.Errorf("could not iterate over flagged accounts: %v", err)
+	}
+
+	page = newPage(ff, first, func(f FlaggedAccount) string {
+		return encodeTimeCursor(f.UpdatedAt, f.User.ID)
+	})
+
+	return page, nil
+}
+
+// ClearFlaggedAccount marks a flagged account as legitimate after review,
+// so its votes are not held to a stricter score anymore.
+// Votes left uncounted while flagged stay so. Only site moderators can.
+func (s *Service) ClearFlaggedAccount(ctx context.Context, username string) error {
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return ErrUnauthenticated
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	role, err := userRole(ctx, tx, uid)
+	if err != nil {
+		return err
+	}
+
+	if !canModerate(role) {
+		return ErrForbidden
+	}
+
+	var userID int64
+	var reasons []string
+	query := `UPDATE user_flags SET cleared_at = NOW(), cleared_by = $2
+		WHERE cleared_at IS NULL AND user_id = (SELECT id FROM users WHERE username = $1)
+		RETURNING user_id, reasons`
+	err = tx.QueryRow(ctx, query, strings.TrimSpace(username), uid).Scan(&userID, &reasons)
+	if err == pgx.ErrNoRows {
+		return ErrFlagNotFound
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not update user flag: %v", err)
+	}
+
+	err = logModAction(ctx, tx, modAction{
+		actorID:    uid,
+		action:     ModActionClearFlag,
+		targetType: TargetUser,
+		targetID:   userID,
+		before:     map[string][]string{"reasons": reasons},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("could not commit user flag clearing: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVoteSignalsScore(t *testing.T) {
+	old := time.Hour * 24 * 30
+	tests := []struct {
+		name       string
+		v          voteSignals
+		suspicious bool
+	}{
+		{"regular", voteSignals{accountAge: old, recentVotes: 3}, false},
+		{"new account alone", voteSignals{accountAge: time.Minute}, false},
+		{"fast voter", voteSignals{accountAge: old, recentVotes: 25}, true},
+		{"new and busy", voteSignals{accountAge: time.Hour * 2, recentVotes: 12, clusterAccounts: 1}, false},
+		{"new in a cluster", voteSignals{accountAge: time.Minute, clusterAccounts: 4}, true},
+		{"sock puppet", voteSignals{accountAge: old, sharedDeviceVoters: 1}, true},
+		{"shared network", voteSignals{accountAge: old, sharedIPVoters: 3}, false},
+		{"new on a shared network", voteSignals{accountAge: time.Minute, sharedIPVoters: 1}, true},
+		{"flagged", voteSignals{accountAge: old, flagged: true}, false},
+		{"flagged on a shared network", voteSignals{accountAge: old, sharedIPVoters: 1, flagged: true}, true},
+	}
+
+	for _, tt := range tests {
+		score, reasons := tt.v.score()
+		if got := score >= suspiciousVoteScore; got != tt.suspicious {
+			t.Errorf("%s: score %v with %v, expected suspicious %v", tt.name, score, reasons, tt.suspicious)
+		}
+	}
+}
+
+func TestFlagWorthy(t *testing.T) {
+	if flagWorthy([]string{SignalNewAccount}) {
+		t.Error("new accounts alone should not be flagged")
+	}
+
+	if !flagWorthy([]string{SignalNewAccount, SignalSharedClient}) {
+		t.Error("shared clients should be flagged")
+	}
+}
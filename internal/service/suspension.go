@@ -0,0 +1,455 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	AppealPending  = "pending"
+	AppealApproved = "approved"
+	AppealRejected = "rejected"
+
+	maxSuspensionReasonLen = 1000
+	maxAppealLen           = 2000
+)
+
+var (
+	ErrUserSuspended       = errors.New("user suspended")
+	ErrUserNotSuspended    = errors.New("user not suspended")
+	ErrInvalidSuspension   = errors.New("invalid suspension")
+	ErrInvalidAppeal       = errors.New("invalid appeal")
+	ErrAppealExists        = errors.New("suspension already appealed")
+	ErrAppealNotFound      = errors.New("appeal not found")
+	ErrForbiddenSuspension = errors.New("cannot suspend this user")
+)
+
+// Suspension puts a user in read-only mode until it expires or is lifted.
+// A suspension without expiration is a ban.
+type Suspension struct {
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"user_id"`
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	Appeal    *Appeal    `json:"appeal,omitempty"`
+}
+
+// Appeal is the request of a suspended user to lift their suspension.
+type Appeal struct {
+	ID           int64     `json:"id"`
+	SuspensionID int64     `json:"suspension_id"`
+	UserID       int64     `json:"user_id"`
+	Message      string    `json:"message"`
+	Status       string    `json:"status"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// activeSuspensionCondition matches the suspensions in effect
+const activeSuspensionCondition = "user_suspensions.lifted_at IS NULL AND " +
+	"(user_suspensions.expires_at IS NULL OR user_suspensions.expires_at > NOW())"
+
+// activeSuspension gets the suspension in effect of a user, the longest one if many.
+// It returns nil when the user is not suspended.
+func activeSuspension(ctx context.Context, db querier, userID int64) (*Suspension, error) {
+	s := &Suspension{UserID: userID}
+	query := `SELECT id, reason, expires_at, created_at FROM user_suspensions
+		WHERE user_id = $1 AND ` + activeSuspensionCondition + `
+		ORDER BY expires_at DESC NULLS FIRST LIMIT 1`
+	err := db.QueryRow(ctx, query, userID).Scan(&s.ID, &s.Reason, &s.ExpiresAt, &s.CreatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not query select active suspension: %v", err)
+	}
+
+	return s, nil
+}
+
+// suspendUser suspends a user until expiresAt, or bans them when nil.
+// Banning invalidates the sessions of the user.
+func suspendUser(ctx context.Context, tx pgx.Tx, userID, by int64, reason string, expiresAt *time.Time) error {
+	var id int64
+	query := "INSERT INTO user_suspensions (user_id, reason, expires_at, created_by) VALUES ($1, $2, $3, $4) RETURNING id"
+	if err := tx.QueryRow(ctx, query, userID, reason, expiresAt, by).Scan(&id); err != nil {
+		return fmt.Errorf("could not insert user suspension: %v", err)
+	}
+
+	action := ModActionSuspendUser
+	if expiresAt == nil {
+		action = ModActionBanUser
+		query = "UPDATE users SET token_version = token_version + 1 WHERE id = $1"
+		if _, err := tx.Exec(ctx, query, userID); err != nil {
+			return fmt.Errorf("could not update user token version: %v", err)
+		}
+	}
+
+	return logModAction(ctx, tx, modAction{
+		actorID:    by,
+		action:     action,
+		targetType: TargetUser,
+		targetID:   userID,
+		reason:     reason,
+		after:      map[string]interface{}{"suspension_id": id, "expires_at": expiresAt},
+	})
+}
+
+// checkSuspendable checks the user uid can suspend the user userID.
+// Only site moderators suspend, and only admins suspend other moderators.
+func checkSuspendable(ctx context.Context, db querier, uid, userID int64) error {
+	if uid == userID {
+		return ErrForbiddenSuspension
+	}
+
+	role, err := userRole(ctx, db, uid)
+	if err != nil {
+		return err
+	}
+
+	if !canModerate(role) {
+		return ErrForbidden
+	}
+
+	targetRole, err := userRole(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+
+	if canModerate(targetRole) && role != RoleAdmin {
+		return ErrForbiddenSuspension
+	}
+
+	return nil
+}
+
+// ActiveSuspension gets the suspension in effect of the authenticated user, nil if none.
+func (s *Service) ActiveSuspension(ctx context.Context) (*Suspension, error) {
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return nil, ErrUnauthenticated
+	}
+
+	sp, err := activeSuspension(ctx, s.Db, uid)
+	if err != nil || sp == nil {
+		return sp, err
+	}
+
+	a := &Appeal{}
+	query := "SELECT id, suspension_id, user_id, message, status, created_at FROM suspension_appeals WHERE suspension_id = $1"
+	err = s.Db.QueryRow(ctx, query, sp.ID).Scan(&a.ID, &a.SuspensionID, &a.UserID, &a.Message, &a.Status, &a.CreatedAt)
+	if err == pgx.ErrNoRows {
+		return sp, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not query select suspension appeal: %v", err)
+	}
+
+	sp.Appeal = a
+
+	return sp, nil
+}
+
+// SuspendUser suspends a user for the given number of days, or bans them when zero.
+func (s *Service) SuspendUser(ctx context.Context, username, reason string, days int) (Suspension, error) {
+	var sp Suspension
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return sp, ErrUnauthenticated
+	}
+
+	reason = strings.TrimSpace(reason)
+	if reason == "" || len([]rune(reason)) > maxSuspensionReasonLen || days < 0 || days > maxSuspensionDays {
+		return sp, ErrInvalidSuspension
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return sp, fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	query := "SELECT id FROM users WHERE username = $1 FOR UPDATE"
+	err = tx.QueryRow(ctx, query, username).Scan(&sp.UserID)
+	if err == pgx.ErrNoRows {
+		return sp, ErrUserNotFound
+	}
+
+	if err != nil {
+		return sp, fmt.Errorf("could not query select suspended user: %v", err)
+	}
+
+	if err = checkSuspendable(ctx, tx, uid, sp.UserID); err != nil {
+		return sp, err
+	}
+
+	if days > 0 {
+		expiresAt := time.Now().AddDate(0, 0, days)
+		sp.ExpiresAt = &expiresAt
+	}
+
+	if err = suspendUser(ctx, tx, sp.UserID, uid, reason, sp.ExpiresAt); err != nil {
+		return sp, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return sp, fmt.Errorf("could not commit user suspension: %v", err)
+	}
+
+	active, err := activeSuspension(ctx, s.Db, sp.UserID)
+	if err != nil || active == nil {
+		return sp, err
+	}
+
+	return *active, nil
+}
+
+// LiftSuspension ends the suspensions in effect of a user.
+func (s *Service) LiftSuspension(ctx context.Context, username string) error {
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return ErrUnauthenticated
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	var userID int64
+	query := "SELECT id FROM users WHERE username = $1 FOR UPDATE"
+	err = tx.QueryRow(ctx, query, username).Scan(&userID)
+	if err == pgx.ErrNoRows {
+		return ErrUserNotFound
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not query select suspended user: %v", err)
+	}
+
+	if err = checkSuspendable(ctx, tx, uid, userID); err != nil {
+		return err
+	}
+
+	if err = liftSuspensions(ctx, tx, userID, uid, ""); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("could not commit suspension lift: %v", err)
+	}
+
+	return nil
+}
+
+func liftSuspensions(ctx context.Context, tx pgx.Tx, userID, by int64, reason string) error {
+	query := "UPDATE user_suspensions SET lifted_at = NOW(), lifted_by = $2 WHERE user_id = $1 AND " +
+		activeSuspensionCondition + " RETURNING id"
+	rows, err := tx.Query(ctx, query, userID, by)
+	if err != nil {
+		return fmt.Errorf("could not update user suspensions lift: %v", err)
+	}
+
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return fmt.Errorf("could not iterate over lifted suspensions: %v", err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate over lifted suspensions: %v", err)
+	}
+
+	if len(ids) == 0 {
+		return ErrUserNotSuspended
+	}
+
+	return logModAction(ctx, tx, modAction{
+		actorID:    by,
+		action:     ModActionLiftSuspension,
+		targetType: TargetUser,
+		targetID:   userID,
+		reason:     reason,
+		before:     map[string]interface{}{"suspension_ids": ids},
+	})
+}
+
+// AppealSuspension asks the moderators to lift the suspension in effect
+// of the authenticated user. A suspension can only be appealed once.
+func (s *Service) AppealSuspension(ctx context.Context, message string) (Appeal, error) {
+	var a Appeal
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return a, ErrUnauthenticated
+	}
+
+	message = strings.TrimSpace(message)
+	if message == "" || len([]rune(message)) > maxAppealLen {
+		return a, ErrInvalidAppeal
+	}
+
+	sp, err := activeSuspension(ctx, s.Db, uid)
+	if err != nil {
+		return a, err
+	}
+
+	if sp == nil {
+		return a, ErrUserNotSuspended
+	}
+
+	query := `INSERT INTO suspension_appeals (suspension_id, user_id, message) VALUES ($1, $2, $3)
+		RETURNING id, suspension_id, user_id, message, status, created_at`
+	err = s.Db.QueryRow(ctx, query, sp.ID, uid, message).
+		Scan(&a.ID, &a.SuspensionID, &a.UserID, &a.Message, &a.Status, &a.CreatedAt)
+	if isUniqueViolation(err) {
+		return a, ErrAppealExists
+	}
+
+	if err != nil {
+		return a, fmt.Errorf("could not insert suspension appeal: %v", err)
+	}
+
+	return a, nil
+}
+
+// Appeals gets a page of the pending appeals, oldest first. Only site moderators can.
+func (s *Service) Appeals(ctx context.Context, after string, first int) (Page[Appeal], error) {
+	var page Page[Appeal]
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return page, ErrUnauthenticated
+	}
+
+	role, err := userRole(ctx, s.Db, uid)
+	if err != nil {
+		return page, err
+	}
+
+	if !canModerate(role) {
+		return page, ErrForbidden
+	}
+
+	first = normalizePageSize(first)
+	args := []interface{}{AppealPending, first + 1}
+	query := "SELECT id, suspension_id, user_id, message, status, created_at FROM suspension_appeals WHERE status = $1"
+	if after != "" {
+		createdAt, id, err := decodeTimeCursor(after)
+		if err != nil {
+			return page, err
+		}
+
+		args = append(args, createdAt, id)
+		query += " AND (created_at, id) > ($3, $4)"
+	}
+
+	query += " ORDER BY created_at, id LIMIT $2"
+
+	rows, err := s.Db.Query(ctx, query, args...)
+	if err != nil {
+		return page, fmt.Errorf("could not sql query appeals: %v", err)
+	}
+
+	defer rows.Close()
+
+	aa := []Appeal{}
+	for rows.Next() {
+		var a Appeal
+		if err = rows.Scan(&a.ID, &a.SuspensionID, &a.UserID, &a.Message, &a.Status, &a.CreatedAt); err != nil {
+			return page, fmt.Errorf("could not iterate over appeals: %v", err)
+		}
+
+		aa = append(aa, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return page, fmt.Errorf("could not iterate over appeals: %v", err)
+	}
+
+	page = newPage(aa, first, func(a Appeal) string {
+		return encodeTimeCursor(a.CreatedAt, a.ID)
+	})
+
+	return page, nil
+}
+
+// ResolveAppeal approves or rejects a pending appeal.
+// Approving it lifts the suspensions in effect of the user.
+func (s *Service) ResolveAppeal(ctx context.Context, appealID int64, approve bool) error {
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return ErrUnauthenticated
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	var userID int64
+	query := "SELECT user_id FROM suspension_appeals WHERE id = $1 AND status = $2 FOR UPDATE"
+	err = tx.QueryRow(ctx, query, appealID, AppealPending).Scan(&userID)
+	if err == pgx.ErrNoRows {
+		return ErrAppealNotFound
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not query select appeal: %v", err)
+	}
+
+	if err = checkSuspendable(ctx, tx, uid, userID); err != nil {
+		return err
+	}
+
+	status := AppealRejected
+	if approve {
+		status = AppealApproved
+		// the suspension may have expired meanwhile
+		if err = liftSuspensions(ctx, tx, userID, uid, "appeal approved"); err != nil && err != ErrUserNotSuspended {
+			return err
+		}
+	}
+
+	query = "UPDATE suspension_appeals SET status = $2, resolved_by = $3, resolved_at = NOW() WHERE id = $1"
+	if _, err = tx.Exec(ctx, query, appealID, status, uid); err != nil {
+		return fmt.Errorf("could not update appeal: %v", err)
+	}
+
+	err = logModAction(ctx, tx, modAction{
+		actorID:    uid,
+		action:     ModActionResolveAppeal,
+		targetType: TargetAppeal,
+		targetID:   appealID,
+		before:     map[string]string{"status": AppealPending},
+		after:      map[string]string{"status": status},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("could not commit appeal resolution: %v", err)
+	}
+
+	return nil
+}
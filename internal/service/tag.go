@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const maxTagLen = 64
+
+var (
+	// rxTag matches #tag tokens with at least one letter, so "#1" is not a tag.
+	rxTag = regexp.MustCompile(`(?:^|[^\w&#])#([\p{L}\p{N}_]*\p{L}[\p{L}\p{N}_]*)`)
+
+	ErrInvalidTag = errors.New("invalid tag")
+)
+
+// normalizeTag lowercases a tag, with or without its leading #,
+// and reports whether it is valid.
+func normalizeTag(tag string) (string, bool) {
+	tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+	if tag == "" || len([]rune(tag)) > maxTagLen {
+		return "", false
+	}
+
+	m := rxTag.FindStringSubmatch("#" + tag)
+	return tag, m != nil && m[1] == tag
+}
+
+// extractTags returns the distinct lowercased tags in text, in order of appearance.
+func extractTags(text string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, m := range rxTag.FindAllStringSubmatch(text, -1) {
+		tag, ok := normalizeTag(m[1])
+		if ok && !seen[tag] {
+			seen[tag] = true
+			out = append(out, tag)
+		}
+	}
+	return out
+}
+
+// saveTags replaces the tags of target in table.
+func saveTags(ctx context.Context, tx pgx.Tx, table, column string, target int64, tags []string) error {
+	query := "DELETE FROM " + table + " WHERE " + column + " = $1"
+	if _, err := tx.Exec(ctx, query, target); err != nil {
+		return fmt.Errorf("could not delete tags: %v", err)
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	query = "INSERT INTO " + table + " (" + column + ", tag) SELECT $1, unnest($2::VARCHAR[])"
+	if _, err := tx.Exec(ctx, query, target, tags); err != nil {
+		return fmt.Errorf("could not insert tags: %v", err)
+	}
+
+	return nil
+}
+
+// TagPosts gets a page of the posts tagged with tag, newest first
+func (s *Service) TagPosts(ctx context.Context, tag string, after string, first int) (Page[Post], error) {
+	var page Page[Post]
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return page, ErrUnauthenticated
+	}
+
+	tag, ok := normalizeTag(tag)
+	if !ok {
+		return page, ErrInvalidTag
+	}
+
+	first = normalizePageSize(first)
+
+	query := "SELECT " + postColumns + `
+		FROM post_tags
+		INNER JOIN posts ON post_tags.post_id = posts.id
+		WHERE post_tags.tag = $1 AND ` + visiblePostCondition("posts", "$2")
+	args := []interface{}{tag, uid}
+
+	if after != "" {
+		createdAt, id, err := decodeTimeCursor(after)
+		if err != nil {
+			return page, err
+		}
+
+		query += " AND (posts.created_at, posts.id) < ($3, $4)"
+		args = append(args, createdAt, id)
+	}
+
+	query += " ORDER BY posts.created_at DESC, posts.id DESC LIMIT " + strconv.Itoa(first+1)
+
+	rows, err := s.Db.Query(ctx, query, args...)
+	if err != nil {
+		return page, fmt.Errorf("could not sql query tag posts: %v", err)
+	}
+
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		var post Post
+		if err := rows.Scan(post.scanDest()...); err != nil {
+			return page, fmt.Errorf("could not iterate over tag posts: %v", err)
+		}
+
+		posts = append(posts, post)
+	}
+
+	if err = rows.Err(); err != nil {
+		return page, fmt.Errorf("could not iterate over tag posts: %v", err)
+	}
+
+	page = newPage(posts, first, func(p Post) string {
+		return encodeTimeCursor(p.CreatedAt, p.ID)
+	})
+
+	if err = s.hydratePosts(ctx, uid, postPtrs(page.Items)...); err != nil {
+		return page, err
+	}
+
+	return page, nil
+}
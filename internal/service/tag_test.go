@@ -0,0 +1,42 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractTags(t *testing.T) {
+	tests := []struct {
+		text string
+		tags []string
+	}{
+		{"#Go and #golang", []string{"go", "golang"}},
+		{"#go #GO #Go", []string{"go"}},
+		{"issue #1 and #2nd", []string{"2nd"}},
+		{"a#b &#39; ##x", nil},
+		{"(#café)", []string{"café"}},
+	}
+
+	for _, tt := range tests {
+		if got := extractTags(tt.text); !reflect.DeepEqual(got, tt.tags) {
+			t.Errorf("extractTags(%q) = %v, expected %v", tt.text, got, tt.tags)
+		}
+	}
+}
+
+func TestExtractMentions(t *testing.T) {
+	tests := []struct {
+		text      string
+		usernames []string
+	}{
+		{"hi @alice and @bob_1,@alice", []string{"alice", "bob_1"}},
+		{"mail me at john@example.com", nil},
+		{"@abc is too short, @abcd is not", []string{"abcd"}},
+	}
+
+	for _, tt := range tests {
+		if got := extractMentions(tt.text); !reflect.DeepEqual(got, tt.usernames) {
+			t.Errorf("extractMentions(%q) = %v, expected %v", tt.text, got, tt.usernames)
+		}
+	}
+}
@@ -2,6 +2,10 @@ package service
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
 )
 
 // TImeline Model
@@ -10,39 +14,148 @@ type TimelineItem struct {
 	UserID int64 `json:"user_id"`
 	PostID int64 `json:"post_id"`
 	Post   Post  `json:"post,omitempty"`
+	// RepostedBy is set on items shared by a repost
+	RepostedBy *User     `json:"reposted_by,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
-type timelineItemClient struct {
-	timeline chan TimelineItem
+// Timeline gets a page of the authenticated user timeline, newest first.
+// Reposts show up at the time they were made, and are left out
+// when the post got removed or the reposter is shadowbanned.
+func (s *Service) Timeline(ctx context.Context, after string, first int) (Page[TimelineItem], error) {
+	var page Page[TimelineItem]
+
+	uid, ok := ctx.Value(KeyAuthUserID).(int64)
+	if !ok {
+		return page, ErrUnauthenticated
+	}
+
+	first = normalizePageSize(first)
+
+	query := "SELECT timeline.id, timeline.created_at, reposters.id, reposters.username, " + postColumns + `
+		FROM timeline
+		INNER JOIN posts ON timeline.post_id = posts.id
+		LEFT JOIN reposts ON timeline.repost_id = reposts.id
+		LEFT JOIN users reposters ON reposts.user_id = reposters.id
+		WHERE timeline.user_id = $1 AND ` + visiblePostCondition("posts", "$1") + `
+		AND (reposters.id IS NULL OR reposters.id = $1 OR NOT reposters.shadowbanned)`
+	args := []interface{}{uid}
+
+	if after != "" {
+		createdAt, id, err := decodeTimeCursor(after)
+		if err != nil {
+			return page, err
+		}
+
+		query += " AND (timeline.created_at, timeline.id) < ($2, $3)"
+		args = append(args, createdAt, id)
+	}
+
+	query += " ORDER BY timeline.created_at DESC, timeline.id DESC LIMIT " + strconv.Itoa(first+1)
+
+	rows, err := s.Db.Query(ctx, query, args...)
+	if err != nil {
+		return page, fmt.Errorf("could not sql query timeline: %v", err)
+	}
+
+	defer rows.Close()
+
+	tt := []TimelineItem{}
+	for rows.Next() {
+		var ti TimelineItem
+		var reposterID sql.NullInt64
+		var reposter sql.NullString
+		dest := append([]interface{}{&ti.ID, &ti.CreatedAt, &reposterID, &reposter}, ti.Post.scanDest()...)
+		if err := rows.Scan(dest...); err != nil {
+			return page, fmt.Errorf("could not iterate over timeline: %v", err)
+		}
+
+		if reposterID.Valid {
+			ti.RepostedBy = &User{ID: reposterID.Int64, Username: reposter.String}
+		}
+
+		ti.UserID = uid
+		ti.PostID = ti.Post.ID
+		tt = append(tt, ti)
+	}
+
+	if err = rows.Err(); err != nil {
+		return page, fmt.Errorf("could not iterate over timeline: %v", err)
+	}
+
+	page = newPage(tt, first, func(ti TimelineItem) string {
+		return encodeTimeCursor(ti.CreatedAt, ti.ID)
+	})
+
+	pp := make([]*Post, len(page.Items))
+	for i := range page.Items {
+		pp[i] = &page.Items[i].Post
+	}
+
+	if err = s.hydratePosts(ctx, uid, pp...); err != nil {
+		return page, err
+	}
+
+	return page, nil
+}
+
+const (
+	LiveTimelineItem = "timeline_item"
+	LiveNotification = "notification"
+
+	// buffered events of a live subscriber before new ones are dropped
+	liveBuffer = 16
+)
+
+// LiveEvent is an event delivered in realtime to a subscriber:
+// either a new item of their timeline or a new notification.
+type LiveEvent struct {
+	Type         string        `json:"type"`
+	TimelineItem *TimelineItem `json:"timeline_item,omitempty"`
+	Notification *Notification `json:"notification,omitempty"`
+}
+
+type liveClient struct {
+	events chan LiveEvent
 	userID int64
 }
 
-// SubscribeToTimeline to receive timeline items in realtime.
-func (s * Service) SubscribeToTimeline(ctx context.Context) (chan TimelineItem, error) {
+// SubscribeToTimeline to receive timeline items and notifications in realtime.
+// The channel is not closed; receivers stop when ctx is done.
+func (s *Service) SubscribeToTimeline(ctx context.Context) (chan LiveEvent, error) {
 	uid, ok := ctx.Value(KeyAuthUserID).(int64)
 	if !ok {
 		return nil, ErrUnauthenticated
 	}
 
-	tt := make(chan TimelineItem)
-	c := &timelineItemClient{timeline: tt, userID: uid}
-	s.timelineItemClients.Store(c, struct{}{})
+	ee := make(chan LiveEvent, liveBuffer)
+	c := &liveClient{events: ee, userID: uid}
+	s.liveClients.Store(c, struct{}{})
 
 	go func() {
 		<-ctx.Done()
-		s.timelineItemClients.Delete(c)
-		close(tt)
+		s.liveClients.Delete(c)
 	}()
 
-	return tt, nil
+	return ee, nil
 }
 
-func (s *Service) broadcastTimelineItem(ti TimelineItem) {
-	s.timelineItemClients.Range(func(key, value interface{}) bool {
-		c := key.(*timelineItemClient)
-		if c.userID == ti.UserID {
-			c.timeline <- ti
+// broadcast sends an event to the live subscribers of a user.
+// Slow subscribers miss events rather than block the sender,
+// they still get them from the next listing.
+func (s *Service) broadcast(userID int64, e LiveEvent) {
+	s.liveClients.Range(func(key, value interface{}) bool {
+		c := key.(*liveClient)
+		if c.userID == userID {
+			select {
+			case c.events <- e:
+			default:
+			}
 		}
 		return true
 	})
-}
\ No newline at end of file
+}
+
+func (s *Service) broadcastTimelineItem(ti TimelineItem) {
+	s.broadcast(ti.UserID, LiveEvent{Type: LiveTimelineItem, TimelineItem: &ti})
+}
@@ -2,11 +2,13 @@ package service
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
 	"regexp"
 	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
 )
 
 var (
@@ -19,6 +21,14 @@ var (
 	ErrEmailTaken      = errors.New("email taken")
 	ErrUsernameTaken   = errors.New("username taken")
 	ErrHashingPass     = errors.New("error hashing password")
+	// ErrForbidden used when the authenticated user lacks the rights for an action
+	ErrForbidden = errors.New("forbidden")
+)
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
 )
 
 // User model
@@ -33,6 +43,11 @@ type UserProfile struct {
 	User            `json:"user,omitempty"`
 	Email           string `json:"email,omitempty"`
 	Karma           int64 `json:"karma,omitempty"`
+	PostKarma       int64 `json:"post_karma"`
+	CommentKarma    int64 `json:"comment_karma"`
+	FollowersCount  int64 `json:"followers_count"`
+	FolloweesCount  int64 `json:"followees_count"`
+	Following       bool  `json:"following"`
 	UpvotedPosts	[]int64 `json:"upvoted_posts,omitempty"`
 	DownvotedPosts	[]int64 `json:"downvoted_posts,omitempty"`
 }
@@ -54,20 +69,41 @@ func (s *Service) CreateUser(ctx context.Context, email string, password string,
 		return ErrHashingPass
 	}
 
-	query := "INSERT INTO users (email, password, username) VALUES ($1, $2, $3)"
-	_, err := s.Db.Exec(ctx, query, email, hash, username)
-	unique := isUniqueViolation(err)
+	// the signup client is remembered to spot clusters of accounts
+	ip, device := clientFromContext(ctx)
+	query := `WITH u AS (INSERT INTO users (email, password, username) VALUES ($1, $2, $3) RETURNING id)
+		INSERT INTO user_clients (user_id, ip, device) SELECT id, $4::INET, COALESCE($5, '') FROM u WHERE $4::INET IS NOT NULL`
+	_, err := s.Db.Exec(ctx, query, email, hash, username, ip, device)
+	if taken := signupConflict(err); taken != nil {
+		return taken
+	}
 
-	if err != nil && !unique && strings.Contains(err.Error(), "email") {
-		return ErrEmailTaken
+	if err != nil {
+		return fmt.Errorf("could not insert user: %v", err)
 	}
 
-	if err != nil && !unique && strings.Contains(err.Error(), "username") {
-		return ErrUsernameTaken
+	return nil
+}
+
+// signupConflict maps a unique violation on the users table to
+// ErrEmailTaken or ErrUsernameTaken, nil for any other error.
+func signupConflict(err error) error {
+	var pgerr *pgconn.PgError
+	if !errors.As(err, &pgerr) || pgerr.Code != "23505" {
+		return nil
 	}
 
-	if err != nil {
-		return fmt.Errorf("could not insert user: %v", err)
+	// the constraint name is not reported by every server version
+	constraint := pgerr.ConstraintName
+	if constraint == "" {
+		constraint = pgerr.Message
+	}
+
+	switch {
+	case strings.Contains(constraint, "users_email"):
+		return ErrEmailTaken
+	case strings.Contains(constraint, "users_username"):
+		return ErrUsernameTaken
 	}
 
 	return nil
@@ -87,9 +123,19 @@ func (s *Service) User(ctx context.Context, username string) (UserProfile, error
 		return u, ErrUnauthenticated
 	}
 
-	query := "SELECT id, email, karma FROM users WHERE username = $1"
-	err := s.Db.QueryRow(ctx, query, username).Scan(&u.ID, &u.Email, &u.Karma)
-	if err == sql.ErrNoRows {
+	query := `SELECT id, email, post_karma, comment_karma, followers_count, followees_count,
+		EXISTS (SELECT 1 FROM user_follows WHERE follower_id = $2 AND followee_id = users.id)
+		FROM users WHERE username = $1`
+	err := s.Db.QueryRow(ctx, query, username, uid).Scan(
+		&u.ID,
+		&u.Email,
+		&u.PostKarma,
+		&u.CommentKarma,
+		&u.FollowersCount,
+		&u.FolloweesCount,
+		&u.Following,
+	)
+	if err == pgx.ErrNoRows {
 		return u, ErrUserNotFound
 	}
 
@@ -97,7 +143,9 @@ func (s *Service) User(ctx context.Context, username string) (UserProfile, error
 		return u, fmt.Errorf("could not query select user: %v", err)
 	}
 
-	query = "SELECT post_id, vote_type FROM post_votes WHERE user_id = $1"
+	u.Karma = u.PostKarma + u.CommentKarma
+
+	query = "SELECT post_id, value FROM post_votes WHERE user_id = $1"
 	rows, err := s.Db.Query(ctx, query, uid)
 	if err != nil {
 		return u, fmt.Errorf("could not sql query user upvoted posts: %v", err)
@@ -106,15 +154,15 @@ func (s *Service) User(ctx context.Context, username string) (UserProfile, error
 	defer rows.Close()
 
 	var postID int64
-	var voteType string
+	var value int
 	for rows.Next() {
-		if err := rows.Scan(&postID, &voteType); err != nil {
+		if err := rows.Scan(&postID, &value); err != nil {
 			return u, fmt.Errorf("could not iterate over user posts: %v", err)
 		}
 
-		if voteType == "upvote" {
+		if value == 1 {
 			u.UpvotedPosts = append(u.UpvotedPosts, postID)
-		} else if voteType == "downvote" {
+		} else if value == -1 {
 			u.DownvotedPosts = append(u.DownvotedPosts, postID)
 		}
 	}
@@ -122,5 +170,22 @@ func (s *Service) User(ctx context.Context, username string) (UserProfile, error
 	return u, nil
 }
 
+// userRole gets the site wide role of a user
+func userRole(ctx context.Context, db querier, uid int64) (string, error) {
+	var role string
+	query := "SELECT role FROM users WHERE id = $1"
+	err := db.QueryRow(ctx, query, uid).Scan(&role)
+	if err == pgx.ErrNoRows {
+		return "", ErrUserNotFound
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("could not query select user role: %v", err)
+	}
+
+	return role, nil
+}
 
- 
+func canModerate(role string) bool {
+	return role == RoleModerator || role == RoleAdmin
+}
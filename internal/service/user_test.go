@@ -0,0 +1,32 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgconn"
+)
+
+func TestSignupConflict(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"email", &pgconn.PgError{Code: "23505", ConstraintName: "users_email_key"}, ErrEmailTaken},
+		{"username", &pgconn.PgError{Code: "23505", ConstraintName: "users_username_key"}, ErrUsernameTaken},
+		{"message only", &pgconn.PgError{Code: "23505", Message: `duplicate key value violates unique constraint "users_email_key"`}, ErrEmailTaken},
+		{"wrapped", fmt.Errorf("insert: %w", &pgconn.PgError{Code: "23505", ConstraintName: "users_username_key"}), ErrUsernameTaken},
+		{"other constraint", &pgconn.PgError{Code: "23505", ConstraintName: "users_password_key"}, nil},
+		{"other code", &pgconn.PgError{Code: "23503", ConstraintName: "users_email_key"}, nil},
+		{"not a pg error", errors.New("users_email_key"), nil},
+		{"nil", nil, nil},
+	}
+
+	for _, tt := range tests {
+		if got := signupConflict(tt.err); got != tt.want {
+			t.Errorf("%s: signupConflict() = %v, expected %v", tt.name, got, tt.want)
+		}
+	}
+}
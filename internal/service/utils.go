@@ -1,20 +1,21 @@
 package service
 
 import (
-	"github.com/jackc/pgx"
-	"golang.org/x/crypto/bcrypt"
+	"errors"
 
+	"github.com/jackc/pgconn"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func isUniqueViolation(err error) bool {
-	pgerr, ok := err.(pgx.PgError)
-	return ok && pgerr.Code == "23505"
+	var pgerr *pgconn.PgError
+	return errors.As(err, &pgerr) && pgerr.Code == "23505"
 }
 
-// func isPresent(err error) bool {
-// 	pgerr, ok := err.(pgx.PgError)
-// 	return ok && pgerr.Code == "23503"
-// }
+func isForeignKeyViolation(err error) bool {
+	var pgerr *pgconn.PgError
+	return errors.As(err, &pgerr) && pgerr.Code == "23503"
+}
 
 func hashPassword(password string) (string, error) {
     bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
@@ -50,3 +51,15 @@ func checkPasswordHash(password string, hash string) bool {
 
 // 	*arr = append(*arr, val)
 // }
+
+func uniqueInt64s(ii []int64) []int64 {
+	seen := make(map[int64]bool, len(ii))
+	out := make([]int64, 0, len(ii))
+	for _, i := range ii {
+		if !seen[i] {
+			seen[i] = true
+			out = append(out, i)
+		}
+	}
+	return out
+}
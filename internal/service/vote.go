@@ -0,0 +1,364 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	// ErrInvalidVote used when a vote value is not one of -1, 0 or 1
+	ErrInvalidVote = errors.New("invalid vote value")
+)
+
+// VoteOutput response
+type VoteOutput struct {
+	Upvotes   int64 `json:"upvotes"`
+	Downvotes int64 `json:"downvotes"`
+	Vote      int   `json:"vote"`
+}
+
+// voteDeltas returns how much the up and down counters change
+// when a vote goes from prev to next.
+func voteDeltas(prev, next int) (int, int) {
+	var up, down int
+	switch prev {
+	case 1:
+		up--
+	case -1:
+		down--
+	}
+
+	switch next {
+	case 1:
+		up++
+	case -1:
+		down++
+	}
+
+	return up, down
+}
+
+// showOwnVote adds value to the counters when the voter's vote does not count,
+// counted being its counted value, so voters see their votes as counted.
+func (out *VoteOutput) showOwnVote(value, counted int) {
+	if value == 0 || counted != 0 {
+		return
+	}
+
+	up, down := voteDeltas(0, value)
+	out.Upvotes += int64(up)
+	out.Downvotes += int64(down)
+}
+
+// countedVote is the value a vote adds to counters and karma.
+func countedVote(value int, counted bool) int {
+	if !counted {
+		return 0
+	}
+
+	return value
+}
+
+// setVote moves the vote row of the user uid on target in table to value,
+// scoring it for manipulation, and returns the previous and new values
+// as they count toward counters and karma, 0 meaning no vote or one that does not count.
+// The returned changed is false when the vote stayed the same.
+func setVote(ctx context.Context, tx pgx.Tx, table, column string, uid, target int64, value int) (int, int, bool, error) {
+	var prev int
+	var prevCounted bool
+	query := "SELECT value, counted FROM " + table + " WHERE user_id = $1 AND " + column + " = $2"
+	err := tx.QueryRow(ctx, query, uid, target).Scan(&prev, &prevCounted)
+	if err != nil && err != pgx.ErrNoRows {
+		return 0, 0, false, fmt.Errorf("could not query select vote: %v", err)
+	}
+
+	if prev == value {
+		c := countedVote(prev, prevCounted)
+		return c, c, false, nil
+	}
+
+	counted := true
+	if value != 0 {
+		var withheld bool
+		ip, device := clientFromContext(ctx)
+		if counted, withheld, err = assessVote(ctx, tx, table, column, uid, target, ip, device); err != nil {
+			return 0, 0, false, err
+		}
+
+		switch prev {
+		case 0:
+			query = "INSERT INTO " + table + " (user_id, " + column + ", value, counted, ip, device, withheld) " +
+				"VALUES ($1, $2, $3, $4, $5::INET, $6, $7)"
+		default:
+			query = "UPDATE " + table + " SET value = $3, counted = $4, ip = $5::INET, device = $6, withheld = $7, " +
+				"created_at = NOW() WHERE user_id = $1 AND " + column + " = $2"
+		}
+
+		_, err = tx.Exec(ctx, query, uid, target, value, counted, ip, device, withheld)
+	} else {
+		query = "DELETE FROM " + table + " WHERE user_id = $1 AND " + column + " = $2"
+		_, err = tx.Exec(ctx, query, uid, target)
+	}
+
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("could not write vote: %v", err)
+	}
+
+	return countedVote(prev, prevCounted), countedVote(value, counted), true, nil
+}
+
+// uncountedPostVotes returns the votes of the user uid on the given posts
+// which do not count toward the counters, keyed by post id.
+func uncountedPostVotes(ctx context.Context, db querier, uid int64, postIDs []int64) (map[int64]int, error) {
+	votes := map[int64]int{}
+	if uid == 0 {
+		return votes, nil
+	}
+
+	query := "SELECT post_id, value FROM post_votes WHERE user_id = $1 AND post_id = ANY($2) AND NOT counted"
+	rows, err := db.Query(ctx, query, uid, postIDs)
+	if err != nil {
+		return nil, fmt.Errorf("could not sql query uncounted post votes: %v", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID int64
+		var value int
+		if err := rows.Scan(&postID, &value); err != nil {
+			return nil, fmt.Errorf("could not iterate over uncounted post votes: %v", err)
+		}
+
+		votes[postID] = value
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over uncounted post votes: %v", err)
+	}
+
+	return votes, nil
+}
+
+// PostVote sets the authenticated user vote on a post.
+// A value of 1 upvotes, -1 downvotes and 0 clears the vote.
+// Voting the same value twice is a no-op.
+func (s *Service) PostVote(ctx context.Context, postID int64, value int) (VoteOutput, error) {
+	var out VoteOutput
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return out, ErrUnauthenticated
+	}
+
+	if value < -1 || value > 1 {
+		return out, ErrInvalidVote
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return out, fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	// Locking the post serializes concurrent votes on it,
+	// so the previous vote read below cannot go stale.
+	var authorID int64
+	var communityID *int64
+	query := "SELECT user_id, community_id, upvotes, downvotes FROM posts WHERE id = $1 AND " +
+		visiblePostCondition("posts", "$2") + " FOR UPDATE OF posts"
+	err = tx.QueryRow(ctx, query, postID, uid).Scan(&authorID, &communityID, &out.Upvotes, &out.Downvotes)
+	if err == pgx.ErrNoRows {
+		return out, ErrInvalidPostID
+	}
+
+	if err != nil {
+		return out, fmt.Errorf("could not query select post votes: %v", err)
+	}
+
+	if communityID != nil {
+		if err = canPostInCommunity(ctx, tx, *communityID, uid); err != nil {
+			return out, err
+		}
+	}
+
+	out.Vote = value
+	prev, next, changed, err := setVote(ctx, tx, "post_votes", "post_id", uid, postID, value)
+	if err != nil {
+		return out, err
+	}
+
+	if !changed {
+		out.showOwnVote(value, next)
+		return out, nil
+	}
+
+	if prev != next {
+		up, down := voteDeltas(prev, next)
+		query = "UPDATE posts SET upvotes = upvotes + $2, downvotes = downvotes + $3 WHERE id = $1 RETURNING upvotes, downvotes"
+		if err = tx.QueryRow(ctx, query, postID, up, down).Scan(&out.Upvotes, &out.Downvotes); err != nil {
+			return out, fmt.Errorf("could not update post vote counters: %v", err)
+		}
+
+		query = "UPDATE users SET post_karma = post_karma + $2 WHERE id = $1"
+		if _, err = tx.Exec(ctx, query, authorID, next-prev); err != nil {
+			return out, fmt.Errorf("could not update author post karma: %v", err)
+		}
+	}
+
+	out.showOwnVote(value, next)
+
+	var n *Notification
+	if next == 1 {
+		if n, err = notify(ctx, tx, authorID, uid, NotificationPostUpvote, &postID, nil); err != nil {
+			return out, err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return out, fmt.Errorf("could not commit post vote: %v", err)
+	}
+
+	s.broadcastNotifications(n)
+
+	return out, nil
+}
+
+// CommentVote sets the authenticated user vote on a comment,
+// with the same semantics as PostVote.
+func (s *Service) CommentVote(ctx context.Context, commentID int64, value int) (VoteOutput, error) {
+	var out VoteOutput
+
+	uid, auth := ctx.Value(KeyAuthUserID).(int64)
+	if !auth {
+		return out, ErrUnauthenticated
+	}
+
+	if value < -1 || value > 1 {
+		return out, ErrInvalidVote
+	}
+
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return out, fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	var postID int64
+	var authorID *int64
+	var deleted bool
+	query := `SELECT post_id, user_id, upvotes, downvotes,
+		deleted_at IS NOT NULL OR NOT ` + visibleCommentCondition("post_comments", "$2") + `
+		FROM post_comments WHERE id = $1 FOR UPDATE`
+	err = tx.QueryRow(ctx, query, commentID, uid).Scan(&postID, &authorID, &out.Upvotes, &out.Downvotes, &deleted)
+	if err == pgx.ErrNoRows || err == nil && deleted {
+		return out, ErrCommentNotFound
+	}
+
+	if err != nil {
+		return out, fmt.Errorf("could not query select comment votes: %v", err)
+	}
+
+	if err = canParticipateInPost(ctx, tx, uid, postID); err == ErrInvalidPostID {
+		return out, ErrCommentNotFound
+	} else if err != nil {
+		return out, err
+	}
+
+	out.Vote = value
+	prev, next, changed, err := setVote(ctx, tx, "comment_votes", "comment_id", uid, commentID, value)
+	if err != nil {
+		return out, err
+	}
+
+	if !changed {
+		out.showOwnVote(value, next)
+		return out, nil
+	}
+
+	if prev != next {
+		up, down := voteDeltas(prev, next)
+		query = `UPDATE post_comments SET
+			upvotes = upvotes + $2,
+			downvotes = downvotes + $3,
+			score = comment_wilson_score(upvotes + $2, downvotes + $3)
+			WHERE id = $1 RETURNING upvotes, downvotes`
+		if err = tx.QueryRow(ctx, query, commentID, up, down).Scan(&out.Upvotes, &out.Downvotes); err != nil {
+			return out, fmt.Errorf("could not update comment vote counters: %v", err)
+		}
+	}
+
+	out.showOwnVote(value, next)
+
+	var n *Notification
+	if authorID != nil {
+		if prev != next {
+			query = "UPDATE users SET comment_karma = comment_karma + $2 WHERE id = $1"
+			if _, err = tx.Exec(ctx, query, *authorID, next-prev); err != nil {
+				return out, fmt.Errorf("could not update author comment karma: %v", err)
+			}
+		}
+
+		if next == 1 {
+			n, err = notify(ctx, tx, *authorID, uid, NotificationCommentVote, &postID, &commentID)
+			if err != nil {
+				return out, err
+			}
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return out, fmt.Errorf("could not commit comment vote: %v", err)
+	}
+
+	s.broadcastNotifications(n)
+
+	return out, nil
+}
+
+// ReconcileKarma recomputes every user karma from the stored votes that count
+// and fixes the ones that drifted from the incremental updates.
+func (s *Service) ReconcileKarma(ctx context.Context) error {
+	query := `UPDATE users SET post_karma = k.karma
+		FROM (
+			SELECT users.id, COALESCE(SUM(post_votes.value), 0) AS karma
+			FROM users
+			LEFT JOIN posts ON posts.user_id = users.id
+			LEFT JOIN post_votes ON post_votes.post_id = posts.id AND post_votes.counted
+			GROUP BY users.id
+		) k
+		WHERE users.id = k.id AND users.post_karma <> k.karma`
+	tag, err := s.Db.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("could not reconcile post karma: %v", err)
+	}
+
+	if n := tag.RowsAffected(); n != 0 {
+		log.Printf("fixed post karma drift of %d users\n", n)
+	}
+
+	query = `UPDATE users SET comment_karma = k.karma
+		FROM (
+			SELECT users.id, COALESCE(SUM(comment_votes.value), 0) AS karma
+			FROM users
+			LEFT JOIN post_comments ON post_comments.user_id = users.id
+			LEFT JOIN comment_votes ON comment_votes.comment_id = post_comments.id AND comment_votes.counted
+			GROUP BY users.id
+		) k
+		WHERE users.id = k.id AND users.comment_karma <> k.karma`
+	if tag, err = s.Db.Exec(ctx, query); err != nil {
+		return fmt.Errorf("could not reconcile comment karma: %v", err)
+	}
+
+	if n := tag.RowsAffected(); n != 0 {
+		log.Printf("fixed comment karma drift of %d users\n", n)
+	}
+
+	return nil
+}
@@ -0,0 +1,26 @@
+package service
+
+import "testing"
+
+func TestVoteDeltas(t *testing.T) {
+	tests := []struct {
+		prev, next int
+		up, down   int
+	}{
+		{0, 1, 1, 0},
+		{0, -1, 0, 1},
+		{1, -1, -1, 1},
+		{-1, 1, 1, -1},
+		{1, 0, -1, 0},
+		{-1, 0, 0, -1},
+		{1, 1, 0, 0},
+		{0, 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		up, down := voteDeltas(tt.prev, tt.next)
+		if up != tt.up || down != tt.down {
+			t.Errorf("voteDeltas(%d, %d) = (%d, %d), expected (%d, %d)", tt.prev, tt.next, up, down, tt.up, tt.down)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FSStore keeps blobs as files under a directory.
+type FSStore struct {
+	dir string
+}
+
+// NewFSStore creates a FSStore rooted at dir
+func NewFSStore(dir string) *FSStore {
+	return &FSStore{dir: dir}
+}
+
+func (s *FSStore) path(key string) (string, error) {
+	if !validKey(key) {
+		return "", ErrInvalidKey
+	}
+
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes data to a temporary file first and renames it in place,
+// so readers never see a partially written blob.
+func (s *FSStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("could not create blob dir: %v", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("could not create blob temp file: %v", err)
+	}
+
+	defer os.Remove(f.Name())
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("could not write blob: %v", err)
+	}
+
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("could not close blob: %v", err)
+	}
+
+	if err = os.Rename(f.Name(), p); err != nil {
+		return fmt.Errorf("could not move blob in place: %v", err)
+	}
+
+	return nil
+}
+
+// Get opens the blob file under key
+func (s *FSStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not open blob: %v", err)
+	}
+
+	return f, nil
+}
+
+// Exists checks for the blob file under key
+func (s *FSStore) Exists(ctx context.Context, key string) (bool, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = os.Stat(p)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("could not stat blob: %v", err)
+	}
+
+	return true, nil
+}
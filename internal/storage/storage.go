@@ -0,0 +1,40 @@
+// Package storage holds the blob stores used to keep uploaded media.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrNotFound used when there is no blob under a key
+	ErrNotFound = errors.New("blob not found")
+	// ErrInvalidKey used when a key could escape the store namespace
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// BlobStore keeps immutable blobs addressed by a slash separated key.
+type BlobStore interface {
+	// Put stores data under key, replacing any previous blob.
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get opens the blob under key. Callers must close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Exists reports whether there is a blob under key.
+	Exists(ctx context.Context, key string) (bool, error)
+}
+
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+
+	return true
+}
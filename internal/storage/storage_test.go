@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is a local stand-in for an S3 compatible service,
+// keeping objects in memory.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+		http.Error(w, "missing signature", http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		b, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-Amz-Content-Sha256") != sha256Hex(b) {
+			http.Error(w, "payload hash mismatch", http.StatusBadRequest)
+			return
+		}
+		f.objects[r.URL.Path] = b
+	case http.MethodGet, http.MethodHead:
+		b, ok := f.objects[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	}
+}
+
+func testBlobStore(t *testing.T, s BlobStore) {
+	ctx := context.Background()
+
+	if ok, err := s.Exists(ctx, "ab/cd.jpg"); err != nil || ok {
+		t.Fatalf("expected no blob, got %v %v", ok, err)
+	}
+
+	if _, err := s.Get(ctx, "ab/cd.jpg"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := s.Put(ctx, "ab/cd.jpg", []byte("hello"), "image/jpeg"); err != nil {
+		t.Fatalf("could not put blob: %v", err)
+	}
+
+	if ok, err := s.Exists(ctx, "ab/cd.jpg"); err != nil || !ok {
+		t.Fatalf("expected blob, got %v %v", ok, err)
+	}
+
+	rc, err := s.Get(ctx, "ab/cd.jpg")
+	if err != nil {
+		t.Fatalf("could not get blob: %v", err)
+	}
+
+	defer rc.Close()
+
+	if b, _ := io.ReadAll(rc); string(b) != "hello" {
+		t.Errorf("expected hello, got %q", b)
+	}
+
+	if err := s.Put(ctx, "../escape", []byte("x"), ""); err != ErrInvalidKey {
+		t.Errorf("expected ErrInvalidKey, got %v", err)
+	}
+}
+
+func TestFSStore(t *testing.T) {
+	testBlobStore(t, NewFSStore(t.TempDir()))
+}
+
+func TestS3Store(t *testing.T) {
+	srv := httptest.NewServer(&fakeS3{objects: map[string][]byte{}})
+	defer srv.Close()
+
+	testBlobStore(t, NewS3Store(srv.URL, "us-east-1", "bucket", "access", "secret"))
+}
@@ -0,0 +1,118 @@
+package unfurl
+
+import (
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Parse reads the head of an HTML document for preview metadata.
+// OpenGraph tags win over Twitter cards, which win over plain HTML.
+// It also returns the oEmbed discovery URL if the page advertises one.
+// Relative URLs are resolved against base.
+func Parse(r io.Reader, base *url.URL) (Preview, string) {
+	meta := map[string]string{}
+	var title, oembedURL string
+	var inTitle bool
+
+	z := html.NewTokenizer(r)
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+
+		if tt == html.TextToken && inTitle {
+			title += string(z.Text())
+			continue
+		}
+
+		name, hasAttr := z.TagName()
+		tag := string(name)
+
+		if tt == html.EndTagToken {
+			if tag == "title" {
+				inTitle = false
+			}
+			if tag == "head" {
+				break
+			}
+			continue
+		}
+
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		if tag == "body" {
+			break
+		}
+
+		if tag == "title" {
+			inTitle = true
+			continue
+		}
+
+		if !hasAttr || tag != "meta" && tag != "link" {
+			continue
+		}
+
+		attrs := map[string]string{}
+		for {
+			k, v, more := z.TagAttr()
+			attrs[string(k)] = string(v)
+			if !more {
+				break
+			}
+		}
+
+		switch tag {
+		case "meta":
+			key := attrs["property"]
+			if key == "" {
+				key = attrs["name"]
+			}
+			key = strings.ToLower(key)
+			if _, seen := meta[key]; key != "" && !seen {
+				meta[key] = attrs["content"]
+			}
+		case "link":
+			if strings.EqualFold(attrs["type"], "application/json+oembed") && oembedURL == "" {
+				oembedURL = resolve(base, attrs["href"])
+			}
+		}
+	}
+
+	p := Preview{
+		Title:       first(meta["og:title"], meta["twitter:title"], title),
+		Description: first(meta["og:description"], meta["twitter:description"], meta["description"]),
+		ImageURL:    resolve(base, first(meta["og:image"], meta["og:image:url"], meta["twitter:image"], meta["twitter:image:src"])),
+		SiteName:    first(meta["og:site_name"], meta["twitter:site"]),
+	}
+
+	return p, oembedURL
+}
+
+func first(ss ...string) string {
+	for _, s := range ss {
+		if s = strings.TrimSpace(s); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+func resolve(base *url.URL, ref string) string {
+	if ref == "" || base == nil {
+		return ref
+	}
+
+	u, err := base.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return ""
+	}
+
+	return u.String()
+}
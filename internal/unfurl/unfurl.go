@@ -0,0 +1,254 @@
+// Package unfurl fetches link previews from OpenGraph, Twitter card
+// and oEmbed metadata of untrusted URLs.
+package unfurl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	maxPageSize   = 1 << 20
+	maxOEmbedSize = 64 << 10
+	maxRedirects  = 3
+	// fields are cut to these many runes
+	maxTitleLen       = 300
+	maxDescriptionLen = 1000
+	maxURLLen         = 2048
+)
+
+var (
+	// ErrBlockedAddress used when a URL resolves to a private, loopback or otherwise internal address
+	ErrBlockedAddress = errors.New("blocked address")
+	// ErrUnsupportedURL used for non http(s) URLs
+	ErrUnsupportedURL = errors.New("unsupported url")
+	// ErrNotHTML used when a page is not an HTML document
+	ErrNotHTML = errors.New("not an html page")
+)
+
+// Preview of a linked page
+type Preview struct {
+	Title       string
+	Description string
+	ImageURL    string
+	SiteName    string
+}
+
+// Fetcher fetches previews over a client that refuses to reach internal addresses.
+type Fetcher struct {
+	client *http.Client
+}
+
+// NewFetcher creates a Fetcher giving up on a page after timeout
+func NewFetcher(timeout time.Duration) *Fetcher {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		// Control runs after name resolution, on the actual address
+		// dialed, so DNS tricks cannot point us to internal services.
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			ip := net.ParseIP(host)
+			if ip == nil || !publicIP(ip) {
+				return ErrBlockedAddress
+			}
+
+			return nil
+		},
+	}
+
+	return &Fetcher{client: &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// a proxy would do the dialing for us, skipping the checks above
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       time.Minute,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("too many redirects")
+			}
+			return checkURL(req.URL)
+		},
+	}}
+}
+
+var blockedNets = func() []*net.IPNet {
+	var nn []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",
+		"100.64.0.0/10", // carrier grade NAT
+		"192.0.0.0/24",
+		"198.18.0.0/15", // benchmarking
+		"240.0.0.0/4",
+		"64:ff9b::/96", // NAT64 could reach IPv4 internals
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nn = append(nn, n)
+	}
+	return nn
+}()
+
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" || u.User != nil {
+		return ErrUnsupportedURL
+	}
+
+	if p := u.Port(); p != "" && p != "80" && p != "443" {
+		return ErrUnsupportedURL
+	}
+
+	return nil
+}
+
+// Fetch downloads the page at rawURL and extracts its preview,
+// falling back to its oEmbed endpoint for missing fields.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (Preview, error) {
+	var p Preview
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return p, ErrUnsupportedURL
+	}
+
+	if err = checkURL(u); err != nil {
+		return p, err
+	}
+
+	resp, err := f.get(ctx, u.String(), "text/html")
+	if err != nil {
+		return p, err
+	}
+
+	defer resp.Body.Close()
+
+	if mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mt != "text/html" && mt != "application/xhtml+xml" {
+		return p, ErrNotHTML
+	}
+
+	p, oembedURL := Parse(io.LimitReader(resp.Body, maxPageSize), resp.Request.URL)
+
+	if oembedURL != "" && (p.Title == "" || p.ImageURL == "") {
+		// oEmbed is a nice to have, the page metadata is still good without it
+		if o, err := f.oembed(ctx, oembedURL); err == nil {
+			if p.Title == "" {
+				p.Title = o.Title
+			}
+			if p.ImageURL == "" {
+				p.ImageURL = o.ThumbnailURL
+			}
+			if p.SiteName == "" {
+				p.SiteName = o.ProviderName
+			}
+		}
+	}
+
+	return p.clean(), nil
+}
+
+type oembedResponse struct {
+	Title        string `json:"title"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	ProviderName string `json:"provider_name"`
+}
+
+func (f *Fetcher) oembed(ctx context.Context, rawURL string) (oembedResponse, error) {
+	var o oembedResponse
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return o, ErrUnsupportedURL
+	}
+
+	if err = checkURL(u); err != nil {
+		return o, err
+	}
+
+	resp, err := f.get(ctx, u.String(), "application/json")
+	if err != nil {
+		return o, err
+	}
+
+	defer resp.Body.Close()
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxOEmbedSize)).Decode(&o)
+	return o, err
+}
+
+func (f *Fetcher) get(ctx context.Context, rawURL, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %v", err)
+	}
+
+	req.Header.Set("Accept", accept)
+	req.Header.Set("User-Agent", "BondLinkPreview/1.0")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrBlockedAddress) {
+			return nil, ErrBlockedAddress
+		}
+		return nil, fmt.Errorf("could not fetch %s: %v", rawURL, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("could not fetch %s: unexpected status %s", rawURL, resp.Status)
+	}
+
+	return resp, nil
+}
+
+func (p Preview) clean() Preview {
+	p.Title = truncate(p.Title, maxTitleLen)
+	p.Description = truncate(p.Description, maxDescriptionLen)
+	p.SiteName = truncate(p.SiteName, maxTitleLen)
+
+	// only keep web images, a data: or javascript: URL has no business here
+	if u, err := url.Parse(p.ImageURL); err != nil || checkURL(u) != nil || len(p.ImageURL) > maxURLLen {
+		p.ImageURL = ""
+	}
+
+	return p
+}
+
+func truncate(s string, max int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > max {
+		return string(r[:max])
+	}
+	return s
+}
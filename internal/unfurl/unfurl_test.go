@@ -0,0 +1,66 @@
+package unfurl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	page := `<!doctype html><html><head>
+		<title>Plain title</title>
+		<meta name="description" content="Plain description">
+		<meta property="og:title" content="OG title">
+		<meta name="twitter:title" content="Twitter title">
+		<meta name="twitter:image" content="/img/card.png">
+		<link rel="alternate" type="application/json+oembed" href="/oembed?url=x">
+		</head><body><meta property="og:description" content="ignored"></body></html>`
+
+	base, _ := url.Parse("https://example.com/posts/1")
+	p, oembedURL := Parse(strings.NewReader(page), base)
+
+	if p.Title != "OG title" {
+		t.Errorf("expected og:title to win, got %q", p.Title)
+	}
+
+	if p.Description != "Plain description" {
+		t.Errorf("expected meta description fallback, got %q", p.Description)
+	}
+
+	if p.ImageURL != "https://example.com/img/card.png" {
+		t.Errorf("expected resolved twitter image, got %q", p.ImageURL)
+	}
+
+	if oembedURL != "https://example.com/oembed?url=x" {
+		t.Errorf("expected resolved oembed url, got %q", oembedURL)
+	}
+}
+
+func TestFetchBlocksInternalAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<title>internal</title>"))
+	}))
+	defer srv.Close()
+
+	f := NewFetcher(time.Second)
+
+	// the test server listens on a loopback address and a non standard port
+	if _, err := f.Fetch(context.Background(), srv.URL); err != ErrUnsupportedURL {
+		t.Errorf("expected ErrUnsupportedURL for non standard port, got %v", err)
+	}
+
+	for _, u := range []string{"http://127.0.0.1/", "http://[::1]/", "http://10.0.0.1/", "http://169.254.169.254/latest/meta-data"} {
+		if _, err := f.Fetch(context.Background(), u); err != ErrBlockedAddress {
+			t.Errorf("expected ErrBlockedAddress for %s, got %v", u, err)
+		}
+	}
+
+	if _, err := f.Fetch(context.Background(), "file:///etc/passwd"); err != ErrUnsupportedURL {
+		t.Errorf("expected ErrUnsupportedURL for file url, got %v", err)
+	}
+}
@@ -6,52 +6,262 @@ DROP TABLE timeline;
 DROP TABLE posts;
 DROP TABLE users;
 
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
 -- Users
 CREATE TABLE IF NOT EXISTS users (
     id SERIAL NOT NULL PRIMARY KEY,
     username VARCHAR NOT NULL UNIQUE,
     email VARCHAR NOT NULL UNIQUE,
-    karma INTEGER NOT NULL DEFAULT 0,
+    -- user, moderator or admin
+    role VARCHAR NOT NULL DEFAULT 'user',
+    post_karma INTEGER NOT NULL DEFAULT 0,
+    comment_karma INTEGER NOT NULL DEFAULT 0,
+    followers_count INTEGER NOT NULL DEFAULT 0,
+    followees_count INTEGER NOT NULL DEFAULT 0,
+    -- bumped to invalidate every session of the user
+    token_version INTEGER NOT NULL DEFAULT 0,
+    -- content and votes of shadowbanned users are only visible to themselves
+    shadowbanned BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
     password VARCHAR(255) UNIQUE NOT NULL
 );
 
+CREATE INDEX IF NOT EXISTS users_username_trgm_idx ON users USING GIN (username gin_trgm_ops);
+
+-- Communities
+CREATE TABLE IF NOT EXISTS communities (
+    id SERIAL NOT NULL PRIMARY KEY,
+    name VARCHAR NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    rules TEXT[] NOT NULL DEFAULT '{}',
+    -- public, restricted or private
+    visibility VARCHAR NOT NULL DEFAULT 'public',
+    creator_id INT REFERENCES users ON DELETE SET NULL,
+    members_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- names are unique regardless of case
+CREATE UNIQUE INDEX IF NOT EXISTS communities_name_idx ON communities (lower(name));
+CREATE INDEX IF NOT EXISTS sorted_communities ON communities (members_count DESC, id DESC);
+
+CREATE TABLE IF NOT EXISTS community_members (
+    community_id INT NOT NULL REFERENCES communities ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
+    -- member or moderator
+    role VARCHAR NOT NULL DEFAULT 'member',
+    joined_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (community_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS community_join_requests (
+    community_id INT NOT NULL REFERENCES communities ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (community_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS sorted_community_join_requests ON community_join_requests (community_id, created_at, user_id);
+
+CREATE TABLE IF NOT EXISTS community_invites (
+    code VARCHAR NOT NULL PRIMARY KEY,
+    community_id INT NOT NULL REFERENCES communities ON DELETE CASCADE,
+    creator_id INT REFERENCES users ON DELETE SET NULL,
+    -- NULL means no limit
+    expires_at TIMESTAMPTZ,
+    max_uses INTEGER,
+    uses INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Post ranking
+-- Reddit-style hot score: vote magnitude in log scale plus a time bonus,
+-- so newer posts need fewer votes to rank the same as older ones.
+CREATE OR REPLACE FUNCTION post_hot_score(ups INTEGER, downs INTEGER, created_at TIMESTAMPTZ)
+RETURNS DOUBLE PRECISION AS $$
+  SELECT ROUND((
+    SIGN(ups - downs) * LOG(GREATEST(ABS(ups - downs), 1))
+    + (EXTRACT(EPOCH FROM created_at) - 1134028003) / 45000
+  )::NUMERIC, 7)::DOUBLE PRECISION;
+$$ LANGUAGE sql IMMUTABLE;
+
+-- Controversy grows with the number of votes and how evenly they are split.
+CREATE OR REPLACE FUNCTION post_controversy_score(ups INTEGER, downs INTEGER)
+RETURNS DOUBLE PRECISION AS $$
+  SELECT CASE
+    WHEN ups <= 0 OR downs <= 0 THEN 0
+    ELSE POWER(ups + downs, LEAST(ups, downs)::DOUBLE PRECISION / GREATEST(ups, downs))
+  END;
+$$ LANGUAGE sql IMMUTABLE;
+
 -- Posts
 CREATE TABLE IF NOT EXISTS posts (
     id SERIAL NOT NULL PRIMARY KEY,
     user_id INT NOT NULL REFERENCES users, 
+    -- NULL for posts outside of any community
+    community_id INT REFERENCES communities ON DELETE CASCADE,
 
     upvotes INTEGER NOT NULL DEFAULT 0,
     downvotes INTEGER NOT NULL DEFAULT 0,
     views BIGINT NOT NULL DEFAULT 0,
     created_at TIMESTAMP with TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
 
+    -- refreshed periodically from the vote counters, see Service.RefreshPostScores
+    hot_score DOUBLE PRECISION NOT NULL DEFAULT post_hot_score(0, 0, CURRENT_TIMESTAMP),
+    controversy_score DOUBLE PRECISION NOT NULL DEFAULT 0,
+
     title TEXT,
     body TEXT,
     link TEXT,
 
-    album JSONB,
-    poll JSONB
+    -- quote posts reference the post they quote
+    quoted_post_id INT REFERENCES posts ON DELETE SET NULL,
+    reposts_count INTEGER NOT NULL DEFAULT 0,
+    quotes_count INTEGER NOT NULL DEFAULT 0,
+
+    -- removed posts are hidden from every listing
+    deleted_at TIMESTAMPTZ,
+    deleted_by INT REFERENCES users ON DELETE SET NULL,
+    -- hold or hide when caught by the content filters, visible to the author only
+    filter_action VARCHAR,
+
+    search_vector TSVECTOR GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', COALESCE(title, '')), 'A') ||
+        setweight(to_tsvector('english', COALESCE(body, '')), 'B')
+    ) STORED
+);
+
+CREATE INDEX IF NOT EXISTS posts_search_idx ON posts USING GIN (search_vector);
+
+CREATE INDEX IF NOT EXISTS sorted_posts ON posts(created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS sorted_user_posts ON posts(user_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS hot_posts ON posts(hot_score DESC, id DESC);
+CREATE INDEX IF NOT EXISTS top_posts ON posts((upvotes - downvotes) DESC, id DESC, created_at);
+CREATE INDEX IF NOT EXISTS controversial_posts ON posts(controversy_score DESC, id DESC, created_at);
+CREATE INDEX IF NOT EXISTS community_new_posts ON posts(community_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS community_hot_posts ON posts(community_id, hot_score DESC, id DESC);
+CREATE INDEX IF NOT EXISTS community_top_posts ON posts(community_id, (upvotes - downvotes) DESC, id DESC, created_at);
+CREATE INDEX IF NOT EXISTS community_controversial_posts ON posts(community_id, controversy_score DESC, id DESC, created_at);
+
+-- Polls
+CREATE TABLE IF NOT EXISTS polls (
+    post_id INT NOT NULL PRIMARY KEY REFERENCES posts ON DELETE CASCADE,
+    question TEXT NOT NULL,
+    multiple BOOLEAN NOT NULL DEFAULT FALSE,
+    closes_at TIMESTAMPTZ,
+    voters INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS poll_options (
+    id SERIAL NOT NULL PRIMARY KEY,
+    post_id INT NOT NULL REFERENCES polls ON DELETE CASCADE,
+    position SMALLINT NOT NULL,
+    text TEXT NOT NULL,
+    votes INTEGER NOT NULL DEFAULT 0,
+    UNIQUE (post_id, position)
+);
+
+CREATE TABLE IF NOT EXISTS poll_votes (
+    user_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
+    post_id INT NOT NULL REFERENCES polls ON DELETE CASCADE,
+    option_id INT NOT NULL REFERENCES poll_options ON DELETE CASCADE,
+    PRIMARY KEY (user_id, option_id)
+);
+
+CREATE INDEX IF NOT EXISTS poll_votes_user_post ON poll_votes(user_id, post_id);
+
+-- Media
+CREATE TABLE IF NOT EXISTS media (
+    id SERIAL NOT NULL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
+    hash VARCHAR NOT NULL,
+    blob_key VARCHAR NOT NULL,
+    content_type VARCHAR NOT NULL,
+    size INTEGER NOT NULL,
+    -- processing, ready or failed, see Service.ProcessPendingMedia
+    status VARCHAR NOT NULL DEFAULT 'processing',
+    width INTEGER,
+    height INTEGER,
+    blurhash VARCHAR,
+    processed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, hash)
+);
+
+CREATE INDEX IF NOT EXISTS pending_media ON media(id) WHERE status = 'processing';
+
+CREATE TABLE IF NOT EXISTS media_variants (
+    media_id INT NOT NULL REFERENCES media ON DELETE CASCADE,
+    name VARCHAR NOT NULL,
+    blob_key VARCHAR NOT NULL,
+    content_type VARCHAR NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    size INTEGER NOT NULL,
+    PRIMARY KEY (media_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS post_media (
+    post_id INT NOT NULL REFERENCES posts ON DELETE CASCADE,
+    media_id INT NOT NULL REFERENCES media ON DELETE CASCADE,
+    position SMALLINT NOT NULL,
+    PRIMARY KEY (post_id, media_id)
+);
+
+-- Link previews, cached per URL
+CREATE TABLE IF NOT EXISTS link_previews (
+    url TEXT NOT NULL PRIMARY KEY,
+    -- pending, ready or failed, see Service.UnfurlPendingLinks
+    status VARCHAR NOT NULL DEFAULT 'pending',
+    title TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    image_url TEXT NOT NULL DEFAULT '',
+    site_name TEXT NOT NULL DEFAULT '',
+    fetched_at TIMESTAMPTZ
 );
 
-CREATE INDEX IF NOT EXISTS sorted_posts ON posts(created_at DESC);
+CREATE INDEX IF NOT EXISTS pending_link_previews ON link_previews(url) WHERE status = 'pending';
+
+-- Reposts
+CREATE TABLE IF NOT EXISTS reposts (
+    id SERIAL NOT NULL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
+    post_id INT NOT NULL REFERENCES posts ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, post_id)
+);
 
 -- Timeline
 CREATE TABLE IF NOT EXISTS timeline (
     id SERIAL NOT NULL PRIMARY KEY,
     user_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
-    post_id INT NOT NULL REFERENCES posts ON DELETE CASCADE    
+    post_id INT NOT NULL REFERENCES posts ON DELETE CASCADE,
+    -- set for items shared by a repost, removed along with it
+    repost_id INT REFERENCES reposts ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 
 CREATE UNIQUE INDEX IF NOT EXISTS timeline_unique ON timeline(user_id, post_id);
+CREATE INDEX IF NOT EXISTS sorted_timeline ON timeline(user_id, created_at DESC, id DESC);
 
 -- Post Votes
 CREATE TABLE IF NOT EXISTS post_votes (
     user_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
     post_id INT NOT NULL REFERENCES posts ON DELETE CASCADE,
-    vote_type VARCHAR NOT NULL,
+    value SMALLINT NOT NULL CHECK (value IN (-1, 1)),
+    -- suspicious votes are kept but left out of counters and karma
+    counted BOOLEAN NOT NULL DEFAULT TRUE,
+    -- uncounted votes of a shadowbanned user, counted again once lifted
+    withheld BOOLEAN NOT NULL DEFAULT FALSE,
+    ip INET,
+    device VARCHAR,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
     PRIMARY KEY(user_id, post_id)
 );
 
+CREATE INDEX IF NOT EXISTS recent_post_votes_idx ON post_votes (user_id, created_at DESC);
+
 CREATE UNIQUE INDEX IF NOT EXISTS vote_unique_idx ON post_votes(user_id, post_id);
 
 -- Comments
@@ -61,12 +271,56 @@ CREATE TABLE post_comments (
   id SERIAL PRIMARY KEY,
   post_id INT NOT NULL REFERENCES posts ON DELETE CASCADE,
   parent_id INTEGER REFERENCES post_comments(id) ON DELETE CASCADE,
+  user_id INT REFERENCES users ON DELETE SET NULL,
   path ltree,
   content TEXT NOT NULL,
-  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+  replies_count INTEGER NOT NULL DEFAULT 0,
+  upvotes INTEGER NOT NULL DEFAULT 0,
+  downvotes INTEGER NOT NULL DEFAULT 0,
+  -- comment_wilson_score(upvotes, downvotes), kept in sync by votes
+  score DOUBLE PRECISION NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  edited_at TIMESTAMPTZ,
+  -- deleted comments are kept as placeholders so their replies survive
+  deleted_at TIMESTAMPTZ,
+  deleted_by INT REFERENCES users ON DELETE SET NULL,
+  -- hold or hide when caught by the content filters, visible to the author only
+  filter_action VARCHAR,
+  search_vector TSVECTOR GENERATED ALWAYS AS (to_tsvector('english', content)) STORED
 );
 
+CREATE INDEX IF NOT EXISTS comments_search_idx ON post_comments USING GIN (search_vector);
+
 CREATE UNIQUE INDEX comments_path_idx ON post_comments (path);
+CREATE INDEX IF NOT EXISTS sorted_post_comments ON post_comments (post_id, parent_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS top_post_comments ON post_comments (post_id, parent_id, score DESC, id DESC);
+CREATE INDEX IF NOT EXISTS comments_parent_idx ON post_comments (parent_id);
+CREATE INDEX IF NOT EXISTS comments_path_gist_idx ON post_comments USING GIST (path);
+
+-- Lower bound of the Wilson score interval at 95% confidence
+-- for the proportion of upvotes.
+CREATE OR REPLACE FUNCTION comment_wilson_score(upvotes INTEGER, downvotes INTEGER)
+RETURNS DOUBLE PRECISION AS $$
+  SELECT CASE WHEN upvotes + downvotes = 0 THEN 0 ELSE
+    ((upvotes + 1.9208) / (upvotes + downvotes)
+      - 1.96 * SQRT((upvotes * downvotes) / (upvotes + downvotes)::DOUBLE PRECISION + 0.9604) / (upvotes + downvotes))
+    / (1 + 3.8416 / (upvotes + downvotes))
+  END
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE TABLE IF NOT EXISTS comment_votes (
+    user_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
+    comment_id INT NOT NULL REFERENCES post_comments ON DELETE CASCADE,
+    value SMALLINT NOT NULL CHECK (value IN (-1, 1)),
+    counted BOOLEAN NOT NULL DEFAULT TRUE,
+    withheld BOOLEAN NOT NULL DEFAULT FALSE,
+    ip INET,
+    device VARCHAR,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY(user_id, comment_id)
+);
+
+CREATE INDEX IF NOT EXISTS recent_comment_votes_idx ON comment_votes (user_id, created_at DESC);
 
 CREATE OR REPLACE FUNCTION comments_path_trigger()
 RETURNS TRIGGER AS $$
@@ -85,3 +339,232 @@ BEFORE INSERT OR UPDATE ON post_comments
 FOR EACH ROW
 EXECUTE FUNCTION comments_path_trigger();
 
+-- Saved posts and comments, optionally grouped in named collections
+CREATE TABLE IF NOT EXISTS saved_items (
+    id SERIAL NOT NULL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
+    post_id INT REFERENCES posts ON DELETE CASCADE,
+    comment_id INT REFERENCES post_comments ON DELETE CASCADE,
+    -- empty when in no collection
+    collection VARCHAR NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK ((post_id IS NULL) <> (comment_id IS NULL))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS saved_items_post_unique ON saved_items (user_id, post_id);
+CREATE UNIQUE INDEX IF NOT EXISTS saved_items_comment_unique ON saved_items (user_id, comment_id);
+CREATE INDEX IF NOT EXISTS saved_items_user_idx ON saved_items (user_id, created_at DESC, id DESC);
+
+-- Follows
+CREATE TABLE IF NOT EXISTS user_follows (
+    follower_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
+    followee_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (follower_id, followee_id)
+);
+
+CREATE INDEX IF NOT EXISTS followers_idx ON user_follows (followee_id, follower_id);
+
+-- Notifications
+CREATE TABLE IF NOT EXISTS notifications (
+    id SERIAL NOT NULL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
+    type VARCHAR NOT NULL,
+    post_id INT REFERENCES posts ON DELETE CASCADE,
+    comment_id INT REFERENCES post_comments ON DELETE CASCADE,
+    -- ids of the users who caused it, oldest first
+    actors INT[] NOT NULL,
+    read_at TIMESTAMPTZ,
+    issued_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- events on the same target aggregate into the one unread notification
+CREATE UNIQUE INDEX IF NOT EXISTS unread_notifications_target
+    ON notifications (user_id, type, COALESCE(post_id, 0), COALESCE(comment_id, 0))
+    WHERE read_at IS NULL;
+CREATE INDEX IF NOT EXISTS sorted_notifications ON notifications (user_id, issued_at DESC, id DESC);
+
+-- Mentions and tags
+CREATE TABLE IF NOT EXISTS post_mentions (
+    post_id INT NOT NULL REFERENCES posts ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
+    PRIMARY KEY (post_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS comment_mentions (
+    comment_id INT NOT NULL REFERENCES post_comments ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
+    PRIMARY KEY (comment_id, user_id)
+);
+
+-- tags are stored lowercased, without the leading #
+CREATE TABLE IF NOT EXISTS post_tags (
+    post_id INT NOT NULL REFERENCES posts ON DELETE CASCADE,
+    tag VARCHAR NOT NULL,
+    PRIMARY KEY (post_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS tag_posts_idx ON post_tags (tag, post_id);
+
+CREATE TABLE IF NOT EXISTS comment_tags (
+    comment_id INT NOT NULL REFERENCES post_comments ON DELETE CASCADE,
+    tag VARCHAR NOT NULL,
+    PRIMARY KEY (comment_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS tag_comments_idx ON comment_tags (tag, comment_id);
+
+-- Reports and moderation
+-- reports on the same target group into a single case until it is resolved
+CREATE TABLE IF NOT EXISTS moderation_cases (
+    id SERIAL NOT NULL PRIMARY KEY,
+    target_type VARCHAR NOT NULL,
+    target_id INT NOT NULL,
+    community_id INT REFERENCES communities ON DELETE CASCADE,
+    -- the reported content as it was when first reported
+    snapshot VARCHAR NOT NULL,
+    status VARCHAR NOT NULL DEFAULT 'open',
+    reports_count INT NOT NULL DEFAULT 0 CHECK (reports_count >= 0),
+    claimed_by INT REFERENCES users ON DELETE SET NULL,
+    claimed_at TIMESTAMPTZ,
+    resolution VARCHAR,
+    resolution_note VARCHAR,
+    resolved_by INT REFERENCES users ON DELETE SET NULL,
+    resolved_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS unresolved_moderation_cases_target
+    ON moderation_cases (target_type, target_id)
+    WHERE status <> 'resolved';
+CREATE INDEX IF NOT EXISTS moderation_queue_idx ON moderation_cases (status, created_at, id);
+CREATE INDEX IF NOT EXISTS community_moderation_queue_idx
+    ON moderation_cases (community_id, status, created_at, id);
+
+CREATE TABLE IF NOT EXISTS reports (
+    id SERIAL NOT NULL PRIMARY KEY,
+    case_id INT NOT NULL REFERENCES moderation_cases ON DELETE CASCADE,
+    reporter_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
+    reason VARCHAR NOT NULL,
+    details VARCHAR,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (case_id, reporter_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_suspensions (
+    id SERIAL NOT NULL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
+    reason VARCHAR,
+    -- NULL for a permanent suspension
+    expires_at TIMESTAMPTZ,
+    created_by INT REFERENCES users ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    lifted_at TIMESTAMPTZ,
+    lifted_by INT REFERENCES users ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS user_suspensions_idx ON user_suspensions (user_id, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS suspension_appeals (
+    id SERIAL NOT NULL PRIMARY KEY,
+    suspension_id INT NOT NULL UNIQUE REFERENCES user_suspensions ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
+    message VARCHAR NOT NULL,
+    -- pending, approved or rejected
+    status VARCHAR NOT NULL DEFAULT 'pending',
+    resolved_by INT REFERENCES users ON DELETE SET NULL,
+    resolved_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS pending_appeals_idx ON suspension_appeals (status, created_at, id);
+
+-- Moderation log
+-- every privileged action, append-only
+CREATE TABLE IF NOT EXISTS mod_log (
+    id SERIAL NOT NULL PRIMARY KEY,
+    -- no foreign key, entries outlive their actors
+    actor_id INT NOT NULL,
+    action VARCHAR NOT NULL,
+    target_type VARCHAR NOT NULL,
+    target_id INT NOT NULL,
+    community_id INT,
+    reason VARCHAR NOT NULL DEFAULT '',
+    before JSONB,
+    after JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS sorted_mod_log ON mod_log (created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS actor_mod_log_idx ON mod_log (actor_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS target_mod_log_idx ON mod_log (target_type, target_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS community_mod_log_idx ON mod_log (community_id, created_at DESC);
+
+CREATE OR REPLACE FUNCTION mod_log_append_only()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'mod_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS mod_log_append_only ON mod_log;
+CREATE TRIGGER mod_log_append_only
+BEFORE UPDATE OR DELETE OR TRUNCATE ON mod_log
+FOR EACH STATEMENT
+EXECUTE FUNCTION mod_log_append_only();
+
+-- Content filters
+CREATE TABLE IF NOT EXISTS content_filters (
+    id SERIAL NOT NULL PRIMARY KEY,
+    -- word, regex or domain
+    kind VARCHAR NOT NULL,
+    pattern VARCHAR NOT NULL,
+    -- reject, hold or hide
+    action VARCHAR NOT NULL,
+    created_by INT REFERENCES users ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS content_filter_hits (
+    id SERIAL NOT NULL PRIMARY KEY,
+    -- hits outlive their filter, keeping its pattern
+    filter_id INT REFERENCES content_filters ON DELETE SET NULL,
+    pattern VARCHAR NOT NULL,
+    action VARCHAR NOT NULL,
+    user_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
+    target_type VARCHAR NOT NULL,
+    -- NULL for rejected content
+    target_id INT,
+    excerpt VARCHAR NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS sorted_content_filter_hits ON content_filter_hits (created_at DESC, id DESC);
+
+-- Spam and vote manipulation
+-- where users signed up, logged in and voted from
+CREATE TABLE IF NOT EXISTS user_clients (
+    user_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
+    ip INET NOT NULL,
+    -- empty when the app sent no device id
+    device VARCHAR NOT NULL DEFAULT '',
+    first_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, ip, device)
+);
+
+CREATE INDEX IF NOT EXISTS user_clients_ip_idx ON user_clients (ip, last_seen_at DESC);
+CREATE INDEX IF NOT EXISTS user_clients_device_idx ON user_clients (device, last_seen_at DESC) WHERE device <> '';
+
+-- accounts suspected of manipulation, their votes do not count until cleared
+CREATE TABLE IF NOT EXISTS user_flags (
+    user_id INT NOT NULL PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    reasons TEXT[] NOT NULL,
+    cleared_at TIMESTAMPTZ,
+    cleared_by INT REFERENCES users ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS pending_user_flags_idx ON user_flags (updated_at DESC, user_id DESC) WHERE cleared_at IS NULL;